@@ -2,12 +2,10 @@ package main
 
 import (
 	"keysight/laas/controller/config"
-	// "keysight/laas/controller/internal/controller"
-	// inventory "keysight/laas/controller/internal/inventory/netbox"
+	"keysight/laas/controller/internal/controller"
 	"keysight/laas/controller/internal/service"
 
 	httpsvc "keysight/laas/controller/internal/service/http"
-	// graph "github.com/openconfig/ondatra/binding/portgraph"
 	"keysight/laas/controller/internal/timelimited"
 	"keysight/laas/controller/internal/validator"
 )
@@ -25,20 +23,6 @@ func main() {
 		Interface("config", config.Config).
 		Msg("Initialized application config")
 
-	// // Get inventory
-	// inventory.GetCreateInvFromNetbox(*config.Config.NetboxApiURL, *config.Config.NetboxUserToken)
-
-	// // This line is for debugging purpose only
-	// // testbedData := LoadTestbedData("testbed.json")
-	// // Convert Inventory Data type from other types to string
-	// controller.ConvertInventoryDataType()
-	// // Create Concrete Graph
-	// controller.InventoryConfig = controller.LoadInventoryData("inventory.json")
-	// controller.InventoryGraph = graph.ConcreteGraph{}
-	// controller.ConfigNodesToDevices = map[*graph.ConcreteNode]controller.Device{}
-	// controller.ConfigPortsToPorts = map[*graph.ConcretePort]controller.Port{}
-	// controller.LoadConcreteGraph()
-
 	// For timelimited binary, spawn build validity checker go-routine
 	if validator.TimeExpiryCheck {
 		if err := timelimited.SpawnTimeExpiryChecker(); err != nil {
@@ -51,6 +35,16 @@ func main() {
 		log.Error().Err(err).Msg("Failed starting streaming logs to stdout")
 	}
 
+	// Set up the inventory backend sessions are reserved from
+	if err := controller.InitInventory(); err != nil {
+		log.Fatal().Err(err).Msg("Failed initializing inventory")
+	}
+
+	// Reload reservation sessions persisted by a previous run
+	if err := controller.InitSessionStore(); err != nil {
+		log.Fatal().Err(err).Msg("Failed initializing session store")
+	}
+
 	termChan := service.TerminationChannels{
 		StopHttpServer: make(chan bool),
 	}
@@ -58,5 +52,18 @@ func main() {
 	// Initialize https server
 	termChan.ErrHttpServer = httpsvc.ServeHTTP(termChan.StopHttpServer)
 
+	// Release sessions whose lease ran out
+	if err := controller.SpawnLeaseReaper(); err != nil {
+		log.Fatal().Err(err).Msg("Failed starting lease reaper")
+	}
+	// Keep the inventory snapshot up to date
+	if err := controller.SpawnInventoryRefresher(); err != nil {
+		log.Fatal().Err(err).Msg("Failed starting inventory refresher")
+	}
+	// Activate scheduled reservations once due
+	if err := controller.SpawnScheduler(); err != nil {
+		log.Fatal().Err(err).Msg("Failed starting scheduler")
+	}
+
 	service.WaitForTermination(&termChan)
 }
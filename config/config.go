@@ -2,22 +2,48 @@ package config
 
 // all the fields are pointers because most of them will store *flags*
 type config struct {
-	RootDir                   *string
-	WebDir                    *string
-	CertsDir                  *string
-	LogDir                    *string
-	MaxLogSizeMB              *int
-	MaxLogBackups             *int
-	TerminationTimeoutSeconds *int
-	DisableStdOutLogging      *bool
-	Cleanup                   *bool
-	LogLevel                  *string
-	NetboxHost                *string
-	NetboxUserToken           *string
-	FrameworkName             *string
-	NetboxApiURL              *string
-	L1SwitchLocation          *string
-	HTTPPort                  *int
+	RootDir                    *string
+	WebDir                     *string
+	CertsDir                   *string
+	LogDir                     *string
+	MaxLogSizeMB               *int
+	MaxLogBackups              *int
+	TerminationTimeoutSeconds  *int
+	DisableStdOutLogging       *bool
+	Cleanup                    *bool
+	LogLevel                   *string
+	NetboxHost                 *string
+	NetboxUserToken            *string
+	FrameworkName              *string
+	NetboxApiURL               *string
+	L1SwitchLocation           *string
+	HTTPPort                   *int
+	SessionStore               *string
+	SessionStoreDir            *string
+	LeaseReaperIntervalSeconds *int
+	DebugArtifactsDir          *string
+	SchedulerIntervalSeconds   *int
+	AdminToken                 *string
+	InventoryBackend           *string
+	InventoryFile              *string
+	InventoryRefreshSeconds    *int
+	InventoryMaxAgeSeconds     *int
+	NetboxWebhookSecret        *string
+	NetboxConcurrency          *int
+	NetboxTimeoutSeconds       *int
+	NetboxRetries              *int
+	InventorySites             *string
+	InventoryLocations         *string
+	InventoryTenants           *string
+	InventoryRacks             *string
+	InventoryTags              *string
+	NetboxScheme               *string
+	NetboxCACert               *string
+	NetboxClientCert           *string
+	NetboxClientKey            *string
+	NetboxInsecureSkipVerify   *bool
+	NetboxJournal              *bool
+	NetboxSessionTags          *bool
 }
 
 var (
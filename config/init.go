@@ -6,22 +6,46 @@ import (
 	"keysight/laas/controller/internal/utils"
 	"os"
 	"path"
+	"strings"
 )
 
 func initConfig() error {
 	Config = &config{
-		RootDir:                   new(string),
-		LogDir:                    new(string),
-		WebDir:                    new(string),
-		CertsDir:                  new(string),
-		MaxLogSizeMB:              new(int),
-		MaxLogBackups:             new(int),
-		TerminationTimeoutSeconds: new(int),
-		NetboxHost:                new(string),
-		NetboxUserToken:           new(string),
-		FrameworkName:             new(string),
-		NetboxApiURL:              new(string),
-		L1SwitchLocation:          new(string),
+		RootDir:                    new(string),
+		LogDir:                     new(string),
+		WebDir:                     new(string),
+		CertsDir:                   new(string),
+		MaxLogSizeMB:               new(int),
+		MaxLogBackups:              new(int),
+		TerminationTimeoutSeconds:  new(int),
+		NetboxHost:                 new(string),
+		NetboxUserToken:            new(string),
+		FrameworkName:              new(string),
+		NetboxApiURL:               new(string),
+		L1SwitchLocation:           new(string),
+		SessionStore:               new(string),
+		SessionStoreDir:            new(string),
+		LeaseReaperIntervalSeconds: new(int),
+		DebugArtifactsDir:          new(string),
+		SchedulerIntervalSeconds:   new(int),
+		AdminToken:                 new(string),
+		InventoryBackend:           new(string),
+		InventoryFile:              new(string),
+		InventoryRefreshSeconds:    new(int),
+		InventoryMaxAgeSeconds:     new(int),
+		NetboxWebhookSecret:        new(string),
+		NetboxConcurrency:          new(int),
+		NetboxTimeoutSeconds:       new(int),
+		NetboxRetries:              new(int),
+		NetboxScheme:               new(string),
+		NetboxCACert:               new(string),
+		NetboxClientCert:           new(string),
+		NetboxClientKey:            new(string),
+		InventorySites:             new(string),
+		InventoryLocations:         new(string),
+		InventoryTenants:           new(string),
+		InventoryRacks:             new(string),
+		InventoryTags:              new(string),
 	}
 	*Config.MaxLogSizeMB = 25
 	*Config.MaxLogBackups = 25
@@ -32,12 +56,93 @@ func initConfig() error {
 
 	Config.NetboxHost = flag.String(
 		"netbox-host", "",
-		"NetBox hostname/ip:port (mandatory)",
+		"NetBox hostname/ip:port (mandatory with netbox inventory backend)",
 	)
 
 	Config.NetboxUserToken = flag.String(
 		"netbox-user-token", "",
-		"NetBox User Token (mandatory)",
+		"NetBox User Token (mandatory with netbox inventory backend)",
+	)
+	Config.NetboxScheme = flag.String(
+		"netbox-scheme", "http",
+		"Scheme of NetBox API URL - http/https",
+	)
+	Config.NetboxCACert = flag.String(
+		"netbox-ca-cert", "",
+		"PEM bundle of CA certificates trusted for NetBox in addition to system ones, e.g. etc/keysight-root.crt",
+	)
+	Config.NetboxClientCert = flag.String(
+		"netbox-client-cert", "",
+		"PEM client certificate presented to NetBox (requires netbox-client-key)",
+	)
+	Config.NetboxClientKey = flag.String(
+		"netbox-client-key", "",
+		"PEM private key of netbox-client-cert",
+	)
+	Config.NetboxInsecureSkipVerify = flag.Bool(
+		"netbox-insecure-skip-verify", false,
+		"Skip verification of NetBox server certificate (insecure, for testing only)",
+	)
+
+	Config.InventoryBackend = flag.String(
+		"inventory-backend", "netbox",
+		"Inventory backend - netbox/file",
+	)
+	Config.InventoryFile = flag.String(
+		"inventory-file", "",
+		"YAML/JSON inventory file used by file inventory backend (mandatory with file inventory backend)",
+	)
+	Config.InventoryRefreshSeconds = flag.Int(
+		"inventory-refresh-interval", 30,
+		"Interval in seconds at which the NetBox inventory snapshot is refreshed, and the inventory recorded for diffs, in background (disabled if 0, inventory diffs then only cover changes between diff and export requests)",
+	)
+	Config.InventoryMaxAgeSeconds = flag.Int(
+		"inventory-max-age", 60,
+		"Age in seconds beyond which the NetBox inventory snapshot is refreshed before use",
+	)
+	Config.InventorySites = flag.String(
+		"inventory-sites", "",
+		"Comma-separated slugs of the sites the inventory is limited to",
+	)
+	Config.InventoryLocations = flag.String(
+		"inventory-locations", "",
+		"Comma-separated slugs of the locations the inventory is limited to",
+	)
+	Config.InventoryTenants = flag.String(
+		"inventory-tenants", "",
+		"Comma-separated slugs of the tenants the inventory is limited to",
+	)
+	Config.InventoryRacks = flag.String(
+		"inventory-racks", "",
+		"Comma-separated names of the racks the inventory is limited to",
+	)
+	Config.InventoryTags = flag.String(
+		"inventory-tags", "",
+		"Comma-separated slugs of the tags the inventory is limited to",
+	)
+	Config.NetboxWebhookSecret = flag.String(
+		"netbox-webhook-secret", "",
+		"Secret NetBox webhooks posted to /netbox/webhook are signed with (webhook disabled if empty)",
+	)
+	Config.NetboxConcurrency = flag.Int(
+		"netbox-concurrency", 8,
+		"Maximum number of concurrent requests to NetBox",
+	)
+	Config.NetboxTimeoutSeconds = flag.Int(
+		"netbox-timeout", 30,
+		"Timeout in seconds of each request to NetBox (disabled if 0)",
+	)
+	Config.NetboxRetries = flag.Int(
+		"netbox-retries", 3,
+		"Number of retries, with exponential backoff, of NetBox requests failing with 429/5xx status code",
+	)
+	Config.NetboxJournal = flag.Bool(
+		"netbox-journal", false,
+		"Add NetBox journal entries to devices upon reserve and release",
+	)
+	Config.NetboxSessionTags = flag.Bool(
+		"netbox-session-tags", false,
+		"Tag NetBox devices and interfaces with a tag per session while reserved",
 	)
 
 	Config.FrameworkName = flag.String(
@@ -65,6 +170,31 @@ func initConfig() error {
 	)
 	Config.HTTPPort = flag.Int("http-port", 8080, "HTTP Server Port")
 
+	Config.SessionStore = flag.String(
+		"session-store", "file",
+		"Reservation session store - file/memory",
+	)
+	Config.SessionStoreDir = flag.String(
+		"session-store-dir", "",
+		"Directory used by file session store (default \"<root>/sessions\")",
+	)
+	Config.LeaseReaperIntervalSeconds = flag.Int(
+		"lease-reaper-interval", 60,
+		"Interval in seconds at which sessions with expired lease are released",
+	)
+	Config.SchedulerIntervalSeconds = flag.Int(
+		"scheduler-interval", 30,
+		"Interval in seconds at which due scheduled reservations are activated",
+	)
+	Config.AdminToken = flag.String(
+		"admin-token", "",
+		"Token to pass in X-Admin-Token header for admin-only operations e.g. preemptive reserve or priority above 0 (disabled if empty)",
+	)
+	Config.DebugArtifactsDir = flag.String(
+		"debug-artifacts-dir", "",
+		"Directory to keep per-session copies of intermediate reservation data (disabled if empty)",
+	)
+
 	// this is set by Dockerfile
 	if *Config.RootDir = os.Getenv("SRC_ROOT"); *Config.RootDir == "" {
 		*Config.RootDir = "."
@@ -85,20 +215,61 @@ func ParseFlags() {
 		os.Exit(1)
 	}
 
-	addr, err := utils.ParseAddr(*Config.NetboxHost)
-	if err != nil {
+	*Config.InventoryBackend = strings.ToLower(*Config.InventoryBackend)
+	switch *Config.InventoryBackend {
+	case "netbox":
+		addr, err := utils.ParseAddr(*Config.NetboxHost)
+		if err != nil {
+			flag.Usage()
+			log.Fatal().Msgf("Error parsing value '%s' for mandatory input netbox-host: %s",
+				*Config.NetboxHost, err.Error())
+			os.Exit(2)
+		}
+		scheme := strings.ToLower(*Config.NetboxScheme)
+		if scheme != "http" && scheme != "https" {
+			flag.Usage()
+			log.Fatal().Msgf("Error parsing value '%s' for input netbox-scheme: invalid scheme, please check usage",
+				*Config.NetboxScheme)
+			os.Exit(2)
+		}
+		*Config.NetboxApiURL = fmt.Sprintf("%s://%s:%d/api/", scheme, addr.Host, addr.Port)
+
+		if (*Config.NetboxClientCert == "") != (*Config.NetboxClientKey == "") {
+			flag.Usage()
+			log.Fatal().Msgf("Error parsing value '%s' for input netbox-client-cert: netbox-client-cert and netbox-client-key must be given together",
+				*Config.NetboxClientCert)
+			os.Exit(2)
+		}
+
+		if len(*Config.NetboxUserToken) == 0 {
+			flag.Usage()
+			log.Fatal().Msgf("Error parsing value '%s' for mandatory input netbox-user-token: token can not be empty",
+				*Config.NetboxUserToken)
+			os.Exit(3)
+		}
+	case "file":
+		if len(*Config.InventoryFile) == 0 {
+			flag.Usage()
+			log.Fatal().Msgf("Error parsing value '%s' for mandatory input inventory-file: path can not be empty",
+				*Config.InventoryFile)
+			os.Exit(3)
+		}
+	default:
 		flag.Usage()
-		log.Fatal().Msgf("Error parsing value '%s' for mandatory input netbox-host: %s",
-			*Config.NetboxHost, err.Error())
+		log.Fatal().Msgf("Error parsing value '%s' for input inventory-backend: invalid inventory backend, please check usage",
+			*Config.InventoryBackend)
 		os.Exit(2)
 	}
-	*Config.NetboxApiURL = fmt.Sprintf("http://%s:%d/api/", addr.Host, addr.Port)
 
-	if len(*Config.NetboxUserToken) == 0 {
+	if *Config.NetboxConcurrency <= 0 {
 		flag.Usage()
-		log.Fatal().Msgf("Error parsing value '%s' for mandatory input netbox-user-token: token can not be empty",
-			*Config.NetboxUserToken)
-		os.Exit(3)
+		log.Fatal().Msgf("Error parsing value '%d' for input netbox-concurrency: must be positive",
+			*Config.NetboxConcurrency)
+		os.Exit(2)
+	}
+
+	if len(*Config.SessionStoreDir) == 0 {
+		*Config.SessionStoreDir = path.Join(*Config.RootDir, "sessions")
 	}
 
 	if err := validateLogLevel(*Config.LogLevel); err != nil {
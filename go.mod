@@ -5,6 +5,7 @@ go 1.21.4
 replace github.com/openconfig/ondatra => github.com/open-traffic-generator/ondatra v0.0.0-20240422051422-f92428db5b29
 
 require (
+	github.com/ghodss/yaml v1.0.0
 	github.com/gorilla/mux v1.8.1
 	github.com/open-traffic-generator/openl1s/gol1s v0.0.0-20240730105808-bdfb71f88b3d
 	github.com/open-traffic-generator/opentestbed/goopentestbed v0.0.4
@@ -18,7 +19,6 @@ require (
 
 require (
 	github.com/Masterminds/semver/v3 v3.2.1 // indirect
-	github.com/ghodss/yaml v1.0.0 // indirect
 	github.com/golang/glog v1.2.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
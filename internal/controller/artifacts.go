@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"encoding/json"
+	"keysight/laas/controller/config"
+	"os"
+	"path"
+)
+
+// writeArtifact keeps an on-disk copy of intermediate reservation data under
+// the debug artifacts directory of the session, if one is configured; data is
+// written as is when it's a byte slice and as indented JSON otherwise
+func writeArtifact(sessionID string, name string, data interface{}) {
+	if len(*config.Config.DebugArtifactsDir) == 0 {
+		return
+	}
+	content, ok := data.([]byte)
+	if !ok {
+		var err error
+		content, err = json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			log.Warn().Err(err).Str("UserID", sessionID).Str("Artifact", name).Msg("Failed to marshal debug artifact")
+			return
+		}
+	}
+	dir := path.Join(*config.Config.DebugArtifactsDir, sessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warn().Err(err).Str("UserID", sessionID).Str("Artifact", name).Msg("Failed to create debug artifacts directory")
+		return
+	}
+	if err := os.WriteFile(path.Join(dir, name), content, 0644); err != nil {
+		log.Warn().Err(err).Str("UserID", sessionID).Str("Artifact", name).Msg("Failed to write debug artifact")
+	}
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"keysight/laas/controller/internal/profile"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+	graph "github.com/openconfig/ondatra/binding/portgraph"
+)
+
+// Feasibility tells whether a testbed fits the inventory
+type Feasibility struct {
+	// Global is the assignment of the testbed onto the whole inventory,
+	// regardless of reservations, or nil if it does not fit
+	Global *Candidate
+	// Available is the assignment of the testbed onto the devices and ports
+	// free right now, or nil if it does not fit
+	Available *Candidate
+}
+
+// Candidate is an assignment of a testbed onto the inventory
+type Candidate struct {
+	// Devices maps testbed devices to inventory devices
+	Devices map[string]string
+	// Ports maps testbed ports to inventory ports, in "device:port" format
+	Ports map[string]string
+	// Links are the inventory links the testbed links are assigned to
+	Links []Link
+}
+
+// Check solves the testbed against the whole inventory and against what is
+// free right now, both limited to scope, without claiming anything nor
+// touching the inventory or the L1 switch
+func Check(ctx context.Context, data goopentestbed.Testbed, scope inven.Scope) (Feasibility, error) {
+	defer profile.LogFuncDuration(time.Now(), "Check", "", "controller")
+
+	result := Feasibility{}
+	if _, err := CheckForDuplicateIDs(data); err != nil {
+		return result, err
+	}
+	testbedConfig := ConvertData(data)
+	testbed := graph.AbstractGraph{}
+	LoadAbstractGraph(testbedConfig, &testbed)
+
+	globalInventory, availableInventory, err := inventoryProvider.Inventory(ctx, scope)
+	if err != nil {
+		return result, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	globalConfig, err := ConvertInventory(globalInventory)
+	if err != nil {
+		return result, err
+	}
+	inventoryConfig, err := ConvertInventory(availableInventory)
+	if err != nil {
+		return result, err
+	}
+
+	result.Global, err = candidate(ctx, &testbed, loadFreeConcreteGraph(globalConfig))
+	if err != nil || result.Global == nil {
+		return result, err
+	}
+
+	// the free inventory is what a reservation made right now would see
+	inventory := LoadConcreteGraph(inventoryConfig)
+	claims.exclude(inventory)
+	booked, err := bookedResources("", time.Now(), nil)
+	if err != nil {
+		return result, err
+	}
+	markReserved(inventory, booked)
+	result.Available, err = candidate(ctx, &testbed, inventory)
+	return result, err
+}
+
+// candidate solves the testbed against the inventory graph and returns the
+// assignment found, or nil if there's none
+func candidate(ctx context.Context, testbed *graph.AbstractGraph, inventory *ConcreteInventory) (*Candidate, error) {
+	assignment, err := graph.Solve(ctx, testbed, &inventory.Graph)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Info().Err(err).Msg("Testbed does not fit the inventory")
+		return nil, nil
+	}
+	result := &Candidate{Devices: map[string]string{}, Ports: map[string]string{}, Links: []Link{}}
+	for _, node := range testbed.Nodes {
+		result.Devices[node.Desc] = assignment.Node2Node[node].Desc
+		for _, port := range node.Ports {
+			result.Ports[port.Desc] = assignment.Port2Port[port].Desc
+		}
+	}
+	for _, edge := range testbed.Edges {
+		result.Links = append(result.Links, concreteLink(assignment.Port2Port[edge.Src].Desc, assignment.Port2Port[edge.Dst].Desc))
+	}
+	return result, nil
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// claimRegistry tracks which devices and ports are held by which session;
+// claiming is the only step of a reservation that is serialized
+type claimRegistry struct {
+	mu      sync.Mutex
+	devices map[string]string
+	ports   map[string]string
+}
+
+var claims = &claimRegistry{
+	devices: map[string]string{},
+	ports:   map[string]string{},
+}
+
+// ClaimConflictError is returned when resources picked for a session are
+// already held by another session
+type ClaimConflictError struct {
+	Devices []string
+	Ports   []string
+}
+
+func (e *ClaimConflictError) Error() string {
+	return fmt.Sprintf("resources already claimed by another session, devices: %v, ports: %v", e.Devices, e.Ports)
+}
+
+// claimedResources lists the devices and ports (in "device:port" format)
+// a session needs to hold exclusively
+type claimedResources struct {
+	devices []string
+	ports   []string
+}
+
+// exclusiveDevice reports whether a device of the given role can only be
+// used by one session at a time; ATEs and L1 switches are shared between
+// sessions on a per port basis
+func exclusiveDevice(role string) bool {
+	role = strings.ToLower(role)
+	return role != "ate" && role != "l1s"
+}
+
+// resourcesOf returns the resources held by a set of reserved devices
+func resourcesOf(devices map[string]BDevice) claimedResources {
+	resources := claimedResources{}
+	for _, device := range devices {
+		if exclusiveDevice(device.Attrs["role"]) {
+			resources.devices = append(resources.devices, device.Id)
+		}
+		for _, port := range device.Ports {
+			resources.ports = append(resources.ports, port.Id)
+		}
+	}
+	return resources
+}
+
+// claim atomically marks all resources as held by userID; either all of them
+// are claimed or none, in which case a ClaimConflictError is returned
+func (c *claimRegistry) claim(userID string, resources claimedResources) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conflict := &ClaimConflictError{}
+	for _, device := range resources.devices {
+		if owner, ok := c.devices[device]; ok && owner != userID {
+			conflict.Devices = append(conflict.Devices, device)
+		}
+	}
+	for _, port := range resources.ports {
+		if owner, ok := c.ports[port]; ok && owner != userID {
+			conflict.Ports = append(conflict.Ports, port)
+		}
+	}
+	if len(conflict.Devices) != 0 || len(conflict.Ports) != 0 {
+		sort.Strings(conflict.Devices)
+		sort.Strings(conflict.Ports)
+		return conflict
+	}
+
+	for _, device := range resources.devices {
+		c.devices[device] = userID
+	}
+	for _, port := range resources.ports {
+		c.ports[port] = userID
+	}
+	return nil
+}
+
+// release drops every claim held by userID
+func (c *claimRegistry) release(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for device, owner := range c.devices {
+		if owner == userID {
+			delete(c.devices, device)
+		}
+	}
+	for port, owner := range c.ports {
+		if owner == userID {
+			delete(c.ports, port)
+		}
+	}
+}
+
+// unclaim drops the claims userID holds on the given resources
+func (c *claimRegistry) unclaim(userID string, resources claimedResources) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, device := range resources.devices {
+		if c.devices[device] == userID {
+			delete(c.devices, device)
+		}
+	}
+	for _, port := range resources.ports {
+		if c.ports[port] == userID {
+			delete(c.ports, port)
+		}
+	}
+}
+
+// transfer hands the claims from holds on the given resources over to to,
+// without letting go of them in between; the resources moved are returned
+func (c *claimRegistry) transfer(from string, to string, resources claimedResources) claimedResources {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	moved := claimedResources{}
+	for _, device := range resources.devices {
+		if c.devices[device] == from {
+			c.devices[device] = to
+			moved.devices = append(moved.devices, device)
+		}
+	}
+	for _, port := range resources.ports {
+		if c.ports[port] == from {
+			c.ports[port] = to
+			moved.ports = append(moved.ports, port)
+		}
+	}
+	return moved
+}
+
+// held returns the resources held by each session
+func (c *claimRegistry) held() map[string]claimedResources {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	held := map[string]claimedResources{}
+	for device, owner := range c.devices {
+		resources := held[owner]
+		resources.devices = append(resources.devices, device)
+		held[owner] = resources
+	}
+	for port, owner := range c.ports {
+		resources := held[owner]
+		resources.ports = append(resources.ports, port)
+		held[owner] = resources
+	}
+	return held
+}
+
+// exclude marks claimed devices and ports of the inventory graph as reserved,
+// so that the solver does not pick them
+func (c *claimRegistry) exclude(inventory *ConcreteInventory) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, node := range inventory.Graph.Nodes {
+		if _, ok := c.devices[node.Desc]; ok {
+			node.Attrs["reserved"] = "yes"
+		}
+		for _, port := range node.Ports {
+			if _, ok := c.ports[port.Desc]; ok {
+				port.Attrs["reserved"] = "yes"
+			}
+		}
+	}
+}
+
+// keyedMutex serializes operations on the same key, e.g. release and renew
+// of the same session, without blocking operations on other keys
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+var sessionLocks = &keyedMutex{locks: map[string]*keyedLock{}}
+
+// lock acquires the lock for key and returns the function releasing it
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
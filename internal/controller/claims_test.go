@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	graph "github.com/openconfig/ondatra/binding/portgraph"
+)
+
+func newClaimRegistry() *claimRegistry {
+	return &claimRegistry{devices: map[string]string{}, ports: map[string]string{}}
+}
+
+func TestClaimRegistryClaim(t *testing.T) {
+	tests := []struct {
+		name      string
+		held      map[string]claimedResources
+		userID    string
+		resources claimedResources
+		conflict  *ClaimConflictError
+	}{
+		{
+			name:      "free resources",
+			userID:    "a",
+			resources: claimedResources{devices: []string{"dut1"}, ports: []string{"ate1:p1"}},
+		},
+		{
+			name:      "resources held by the same session",
+			held:      map[string]claimedResources{"a": {devices: []string{"dut1"}, ports: []string{"ate1:p1"}}},
+			userID:    "a",
+			resources: claimedResources{devices: []string{"dut1"}, ports: []string{"ate1:p1", "ate1:p2"}},
+		},
+		{
+			name:      "device held by another session",
+			held:      map[string]claimedResources{"b": {devices: []string{"dut1"}}},
+			userID:    "a",
+			resources: claimedResources{devices: []string{"dut1", "dut2"}},
+			conflict:  &ClaimConflictError{Devices: []string{"dut1"}},
+		},
+		{
+			name:      "ports held by other sessions",
+			held:      map[string]claimedResources{"b": {ports: []string{"ate1:p2"}}, "c": {ports: []string{"ate1:p1"}}},
+			userID:    "a",
+			resources: claimedResources{devices: []string{"dut1"}, ports: []string{"ate1:p2", "ate1:p1", "ate1:p3"}},
+			conflict:  &ClaimConflictError{Ports: []string{"ate1:p1", "ate1:p2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClaimRegistry()
+			for owner, resources := range tt.held {
+				if err := c.claim(owner, resources); err != nil {
+					t.Fatalf("claim(%q) failed: %v", owner, err)
+				}
+			}
+			before := c.held()
+
+			err := c.claim(tt.userID, tt.resources)
+			if tt.conflict == nil {
+				if err != nil {
+					t.Fatalf("claim() = %v, want nil", err)
+				}
+				for _, device := range tt.resources.devices {
+					if c.devices[device] != tt.userID {
+						t.Errorf("device %s held by %q, want %q", device, c.devices[device], tt.userID)
+					}
+				}
+				for _, port := range tt.resources.ports {
+					if c.ports[port] != tt.userID {
+						t.Errorf("port %s held by %q, want %q", port, c.ports[port], tt.userID)
+					}
+				}
+				return
+			}
+			var conflict *ClaimConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("claim() = %v, want a ClaimConflictError", err)
+			}
+			if !reflect.DeepEqual(conflict, tt.conflict) {
+				t.Errorf("claim() conflict = %+v, want %+v", conflict, tt.conflict)
+			}
+			// nothing is claimed upon a conflict
+			if after := c.held(); len(after[tt.userID].devices) != len(before[tt.userID].devices) || len(after[tt.userID].ports) != len(before[tt.userID].ports) {
+				t.Errorf("claim() held %+v after a conflict, want %+v", after[tt.userID], before[tt.userID])
+			}
+		})
+	}
+}
+
+func TestClaimRegistryRelease(t *testing.T) {
+	c := newClaimRegistry()
+	if err := c.claim("a", claimedResources{devices: []string{"dut1"}, ports: []string{"ate1:p1", "ate1:p2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.claim("b", claimedResources{devices: []string{"dut2"}, ports: []string{"ate1:p3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// unclaim leaves resources held by other sessions alone
+	c.unclaim("a", claimedResources{devices: []string{"dut2"}, ports: []string{"ate1:p2", "ate1:p3"}})
+	want := map[string]claimedResources{
+		"a": {devices: []string{"dut1"}, ports: []string{"ate1:p1"}},
+		"b": {devices: []string{"dut2"}, ports: []string{"ate1:p3"}},
+	}
+	if held := c.held(); !reflect.DeepEqual(held, want) {
+		t.Errorf("held() after unclaim = %+v, want %+v", held, want)
+	}
+
+	c.release("a")
+	want = map[string]claimedResources{"b": {devices: []string{"dut2"}, ports: []string{"ate1:p3"}}}
+	if held := c.held(); !reflect.DeepEqual(held, want) {
+		t.Errorf("held() after release = %+v, want %+v", held, want)
+	}
+	if err := c.claim("c", claimedResources{devices: []string{"dut1"}, ports: []string{"ate1:p1"}}); err != nil {
+		t.Errorf("claim() of released resources = %v, want nil", err)
+	}
+}
+
+func TestClaimRegistryExclude(t *testing.T) {
+	c := newClaimRegistry()
+	if err := c.claim("a", claimedResources{devices: []string{"dut1"}, ports: []string{"ate1:p1"}}); err != nil {
+		t.Fatal(err)
+	}
+	newNode := func(name string, ports ...string) *graph.ConcreteNode {
+		node := &graph.ConcreteNode{Desc: name, Attrs: map[string]string{"reserved": "no"}}
+		for _, port := range ports {
+			node.Ports = append(node.Ports, &graph.ConcretePort{Desc: name + ":" + port, Attrs: map[string]string{"reserved": "no"}})
+		}
+		return node
+	}
+	inventory := &ConcreteInventory{Graph: graph.ConcreteGraph{Nodes: []*graph.ConcreteNode{
+		newNode("dut1", "eth1"),
+		newNode("dut2", "eth1"),
+		newNode("ate1", "p1", "p2"),
+	}}}
+
+	c.exclude(inventory)
+	want := map[string]string{
+		"dut1": "yes", "dut1:eth1": "no",
+		"dut2": "no", "dut2:eth1": "no",
+		"ate1": "no", "ate1:p1": "yes", "ate1:p2": "no",
+	}
+	for _, node := range inventory.Graph.Nodes {
+		if got := node.Attrs["reserved"]; got != want[node.Desc] {
+			t.Errorf("node %s reserved = %q, want %q", node.Desc, got, want[node.Desc])
+		}
+		for _, port := range node.Ports {
+			if got := port.Attrs["reserved"]; got != want[port.Desc] {
+				t.Errorf("port %s reserved = %q, want %q", port.Desc, got, want[port.Desc])
+			}
+		}
+	}
+}
+
+func TestClaimRegistryConcurrentClaims(t *testing.T) {
+	c := newClaimRegistry()
+	resources := claimedResources{devices: []string{"dut1"}, ports: []string{"ate1:p1"}}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		winners []string
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			if err := c.claim(userID, resources); err == nil {
+				mu.Lock()
+				winners = append(winners, userID)
+				mu.Unlock()
+			}
+		}(fmt.Sprint("session-", i))
+	}
+	wg.Wait()
+	if len(winners) != 1 {
+		t.Errorf("%d sessions claimed the same resources, want 1: %v", len(winners), winners)
+	}
+}
+
+func TestClaimRegistryTransfer(t *testing.T) {
+	c := newClaimRegistry()
+	if err := c.claim("victim", claimedResources{devices: []string{"dut1", "dut2"}, ports: []string{"dut1:eth1", "ate1:p1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.claim("other", claimedResources{ports: []string{"ate1:p2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the victim's claims on the needed resources change hands
+	needed := claimedResources{devices: []string{"dut1"}, ports: []string{"dut1:eth1", "ate1:p2", "ate1:p3"}}
+	moved := c.transfer("victim", "holder", needed)
+	want := claimedResources{devices: []string{"dut1"}, ports: []string{"dut1:eth1"}}
+	if !reflect.DeepEqual(moved, want) {
+		t.Errorf("transfer() = %+v, want %+v", moved, want)
+	}
+
+	// releasing the victim keeps the claims handed over
+	c.release("victim")
+	held := c.held()
+	if got := held["holder"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("holder holds %+v, want %+v", got, want)
+	}
+	if got := held["other"]; !reflect.DeepEqual(got.ports, []string{"ate1:p2"}) {
+		t.Errorf("other holds %+v, want ate1:p2", got)
+	}
+	if err := c.claim("queued", claimedResources{devices: []string{"dut1"}}); err == nil {
+		t.Error("claim() of a device handed over = nil, want a conflict")
+	}
+	if err := c.claim("queued", claimedResources{devices: []string{"dut2"}, ports: []string{"ate1:p1"}}); err != nil {
+		t.Errorf("claim() of resources released with the victim = %v, want nil", err)
+	}
+}
+
+func TestClaimedResourcesWithout(t *testing.T) {
+	r := claimedResources{devices: []string{"dut1", "dut2"}, ports: []string{"dut1:eth1", "ate1:p1", "dut2"}}
+	got := r.without(claimedResources{devices: []string{"dut2"}, ports: []string{"ate1:p1", "dut1"}})
+	want := claimedResources{devices: []string{"dut1"}, ports: []string{"dut1:eth1", "dut2"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("without() = %+v, want %+v", got, want)
+	}
+}
+
+func TestKeyedMutex(t *testing.T) {
+	k := &keyedMutex{locks: map[string]*keyedLock{}}
+	// counters of the same key are only updated under its lock
+	counts := map[string]*int{"key-0": new(int), "key-1": new(int)}
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			unlock := k.lock(key)
+			defer unlock()
+			*counts[key]++
+		}(fmt.Sprint("key-", i%2))
+	}
+	wg.Wait()
+	for key, count := range counts {
+		if *count != 16 {
+			t.Errorf("key %s counted %d, want 16", key, *count)
+		}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.locks) != 0 {
+		t.Errorf("%d locks left after every one was released", len(k.locks))
+	}
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keysight/laas/controller/internal/store"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key comes along
+// with a testbed other than the one it was first used for
+var ErrIdempotencyKeyReused = errors.New("idempotency key already used for a different testbed")
+
+// requestLocks serializes reservations carrying the same idempotency key, so
+// that a retry waits for the original request instead of racing it
+var requestLocks = &keyedMutex{locks: map[string]*keyedLock{}}
+
+// requestHash returns the digest identifying the testbed of a request,
+// regardless of how its JSON was formatted. Hashes are stored along with
+// sessions, so the digest is taken over canonical JSON, with sorted keys and
+// no whitespace, rather than protojson output whose formatting differs
+// between builds
+func requestHash(data goopentestbed.Testbed) (string, error) {
+	request, err := data.Marshal().ToJson()
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal testbed: %w", err)
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(request), &value); err != nil {
+		return "", fmt.Errorf("failed to unmarshal testbed: %w", err)
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal testbed: %w", err)
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sessionByIdempotencyKey returns the session reserved with the given
+// idempotency key, or nil if there's none
+func sessionByIdempotencyKey(key string) (*store.Session, error) {
+	sessions, err := sessionStore.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if session.IdempotencyKey == key {
+			return session, nil
+		}
+	}
+	return nil, nil
+}
+
+// reserveIdempotent reserves the testbed unless a session was reserved for the
+// same idempotency key already, in which case that session is returned as
+// long as the testbed is the same
+func reserveIdempotent(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (goopentestbed.ReserveResponse, error) {
+	hash, err := requestHash(data)
+	if err != nil {
+		return goopentestbed.NewReserveResponse(), err
+	}
+	unlock := requestLocks.lock(opts.IdempotencyKey)
+	defer unlock()
+
+	session, err := sessionByIdempotencyKey(opts.IdempotencyKey)
+	if err != nil {
+		return goopentestbed.NewReserveResponse(), err
+	}
+	if session == nil {
+		opts.requestHash = hash
+		return reserveWithOptions(ctx, data, opts)
+	}
+	if session.RequestHash != hash {
+		return goopentestbed.NewReserveResponse(), fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, opts.IdempotencyKey)
+	}
+	if !session.Active() {
+		return goopentestbed.NewReserveResponse(), fmt.Errorf("session %s reserved for idempotency key %s is %s", session.ID, opts.IdempotencyKey, session.Status)
+	}
+	log.Info().Str("UserID", session.ID).Str("IdempotencyKey", opts.IdempotencyKey).Msg("Returning session reserved for idempotency key")
+	result := goopentestbed.NewReserveResponse()
+	result.YieldResponse().SetSessionid(session.ID)
+	result.YieldResponse().SetTestbed(session.Testbed)
+	return result, nil
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"keysight/laas/controller/internal/store"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// newTestbed returns the testbed given in JSON format
+func newTestbed(t *testing.T, request string) goopentestbed.Testbed {
+	t.Helper()
+	data := goopentestbed.NewTestbed()
+	if err := data.Unmarshal().FromJson(request); err != nil {
+		t.Fatalf("failed to unmarshal testbed: %v", err)
+	}
+	return data
+}
+
+func TestReserveIdempotent(t *testing.T) {
+	const (
+		original = `{"devices": [{"id": "dut1", "role": "DUT", "ports": [{"id": "port1", "speed": "S_10GB"}]}]}`
+		// the same testbed, formatted differently
+		reformatted = `{
+			"devices": [
+				{"id": "dut1", "role": "DUT", "ports": [{"speed": "S_10GB", "id": "port1"}]}
+			]
+		}`
+		different = `{"devices": [{"id": "dut1", "role": "DUT", "ports": [{"id": "port1", "speed": "S_100GB"}]}]}`
+	)
+	hash, err := requestHash(newTestbed(t, original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		status  store.SessionStatus
+		request string
+		wantErr error
+		errText string
+	}{
+		{name: "same key, same body", request: original},
+		{name: "same key, same body formatted differently", request: reformatted},
+		{name: "same key, different body", request: different, wantErr: ErrIdempotencyKeyReused},
+		{name: "same key, same body, session preempted", status: store.StatusPreempted, request: original, errText: "is preempted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := sessionStore
+			sessionStore = store.NewMemoryStore()
+			defer func() { sessionStore = previous }()
+			session := &store.Session{
+				ID:             "session-1",
+				CreatedAt:      time.Now(),
+				Testbed:        "testbed",
+				Status:         tt.status,
+				IdempotencyKey: "key-1",
+				RequestHash:    hash,
+			}
+			if err := sessionStore.Save(session); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := reserveIdempotent(context.Background(), newTestbed(t, tt.request), ReserveOptions{IdempotencyKey: "key-1"})
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("reserveIdempotent() = %v, want %v", err, tt.wantErr)
+				}
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("reserveIdempotent() = %v, want an error containing %q", err, tt.errText)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("reserveIdempotent() = %v, want nil", err)
+				}
+				if got := result.YieldResponse().Sessionid(); got != session.ID {
+					t.Errorf("reserveIdempotent() session = %q, want %q", got, session.ID)
+				}
+				if got := result.YieldResponse().Testbed(); got != session.Testbed {
+					t.Errorf("reserveIdempotent() testbed = %q, want %q", got, session.Testbed)
+				}
+			}
+			sessions, err := sessionStore.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sessions) != 1 {
+				t.Errorf("%d sessions stored, want the original one only", len(sessions))
+			}
+		})
+	}
+}
+
+func TestRequestHashStable(t *testing.T) {
+	// hashes are stored along with sessions, so they must not change across
+	// builds; this one is the SHA-256 digest of the canonical JSON
+	// {"devices":[{"id":"dut1","ports":[{"id":"port1","pmd":"PMD_UNSPECIFIED","speed":"S_10GB"}],"role":"DUT"}]}
+	const want = "3c34453f9db280f82b09cfd1abe309be8da7ec351da51ba933a2f19929f5dcc6"
+	requests := []string{
+		`{"devices": [{"id": "dut1", "role": "DUT", "ports": [{"id": "port1", "speed": "S_10GB"}]}]}`,
+		`{"devices":[{"role":"DUT","ports":[{"speed":"S_10GB","pmd":"PMD_UNSPECIFIED","id":"port1"}],"id":"dut1"}]}`,
+	}
+	for _, request := range requests {
+		got, err := requestHash(newTestbed(t, request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("requestHash(%s) = %s, want %s", request, got, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keysight/laas/controller/config"
+	fileinv "keysight/laas/controller/internal/inventory/file"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"strings"
+	"time"
+)
+
+// InventoryProvider is the source of the lab inventory, keeping track of what
+// is reserved by which session
+type InventoryProvider interface {
+	// Inventory returns the complete inventory, devices along with their
+	// ports and links, and the inventory available for reservation, both
+	// limited to the devices in scope
+	Inventory(ctx context.Context, scope inven.Scope) (inven.Dut, inven.Dut, error)
+	// Reserve marks the devices and ports as reserved by the session; every
+	// object updated, even if the update fails midway, is appended to
+	// changes and to the release state of the session
+	Reserve(ctx context.Context, userID string, metadata map[string]string, releaseState map[string][]map[string]interface{}, devices []inven.ReservedDevice, changes *[]inven.StateChange) error
+	// Release marks the devices and ports reserved by the session as
+	// available; every object updated is appended to changes
+	Release(userID string, devices []inven.ReservedDevice, changes *[]inven.StateChange) error
+	// ReleaseSession marks every object in the release state of the session
+	// as available
+	ReleaseSession(userID string, releaseState map[string][]map[string]interface{}) error
+	// Restore puts an object back to the state recorded in change
+	Restore(change inven.StateChange) error
+	// Recheck returns an inven.ReservedError if any of the devices and ports
+	// picked for a reservation got reserved since the inventory was fetched
+	Recheck(ctx context.Context, devices []inven.ReservedDevice) error
+}
+
+// ErrInvalidInventoryEvent is returned for NetBox events which can't be
+// applied to the inventory
+var ErrInvalidInventoryEvent = errors.New("invalid inventory event")
+
+// ErrInventoryEventsUnsupported is returned when NetBox events are sent while
+// the inventory isn't kept in NetBox
+var ErrInventoryEventsUnsupported = errors.New("inventory backend does not take NetBox events")
+
+// eventApplier is implemented by inventory providers taking NetBox webhook
+// events
+type eventApplier interface {
+	ApplyEvent(event inven.Event) error
+}
+
+// refresher is implemented by inventory providers serving a snapshot which
+// needs to be refreshed in background
+type refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// InventoryBackend specifies the inventory provider implementation
+type InventoryBackend string
+
+const (
+	// Supported inventory backends
+	InventoryNetbox InventoryBackend = "netbox"
+	InventoryFile   InventoryBackend = "file"
+)
+
+var inventoryProvider InventoryProvider
+
+// SplitList splits a comma-separated list, dropping blank items
+func SplitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// configScope returns the scope the inventory is limited to by configuration
+func configScope() inven.Scope {
+	return inven.Scope{
+		Sites:     SplitList(*config.Config.InventorySites),
+		Locations: SplitList(*config.Config.InventoryLocations),
+		Tenants:   SplitList(*config.Config.InventoryTenants),
+		Racks:     SplitList(*config.Config.InventoryRacks),
+		Tags:      SplitList(*config.Config.InventoryTags),
+	}
+}
+
+// InitInventory sets up the configured inventory backend
+func InitInventory() error {
+	scope := configScope()
+	switch InventoryBackend(*config.Config.InventoryBackend) {
+	case InventoryNetbox:
+		if err := inven.InitHTTPClient(); err != nil {
+			return err
+		}
+		inventoryProvider = &netboxInventory{
+			url:      *config.Config.NetboxApiURL,
+			token:    *config.Config.NetboxUserToken,
+			snapshot: inven.NewSnapshot(*config.Config.NetboxApiURL, *config.Config.NetboxUserToken, scope),
+			maxAge:   time.Duration(*config.Config.InventoryMaxAgeSeconds) * time.Second,
+		}
+	case InventoryFile:
+		provider, err := fileinv.New(*config.Config.InventoryFile, scope)
+		if err != nil {
+			return err
+		}
+		inventoryProvider = provider
+	default:
+		return fmt.Errorf("unsupported inventory backend: %s", *config.Config.InventoryBackend)
+	}
+	log.Info().Str("Backend", *config.Config.InventoryBackend).Interface("Scope", scope).Msg("Initialized inventory")
+	return nil
+}
+
+// SpawnInventoryRefresher spawns a goroutine in background that refreshes the
+// inventory snapshot every interval, if the inventory backend keeps one, and
+// records the inventory for diffs
+func SpawnInventoryRefresher() error {
+	interval := time.Duration(*config.Config.InventoryRefreshSeconds) * time.Second
+	if interval <= 0 {
+		log.Warn().Msg("Inventory refresher disabled, inventory diffs only cover changes between requests")
+		return nil
+	}
+	r, ok := inventoryProvider.(refresher)
+	log.Info().Dur("interval", interval).Msg("Inventory refresher initiated")
+
+	go func() {
+		for {
+			if ok {
+				if err := r.Refresh(context.Background()); err != nil {
+					log.Error().Err(err).Msg("Failed to refresh inventory")
+				}
+			}
+			if _, err := recordInventory(context.Background()); err != nil {
+				log.Error().Err(err).Msg("Failed to record inventory")
+			}
+			time.Sleep(interval)
+		}
+	}()
+
+	return nil
+}
+
+// ApplyInventoryEvent applies a NetBox webhook event, given as sent by NetBox,
+// to the inventory right away
+func ApplyInventoryEvent(payload []byte) error {
+	applier, ok := inventoryProvider.(eventApplier)
+	if !ok {
+		return ErrInventoryEventsUnsupported
+	}
+	event := inven.Event{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInventoryEvent, err)
+	}
+	if err := applier.ApplyEvent(event); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidInventoryEvent, err)
+	}
+	return nil
+}
+
+// netboxInventory is the inventory kept in NetBox, with reservation state
+// held in custom fields of devices and interfaces; the inventory is served
+// from a snapshot no older than maxAge
+type netboxInventory struct {
+	url      string
+	token    string
+	snapshot *inven.Snapshot
+	maxAge   time.Duration
+}
+
+func (n *netboxInventory) Inventory(ctx context.Context, scope inven.Scope) (inven.Dut, inven.Dut, error) {
+	return n.snapshot.Inventory(ctx, n.maxAge, scope)
+}
+
+func (n *netboxInventory) Refresh(ctx context.Context) error {
+	return n.snapshot.Refresh(ctx)
+}
+
+func (n *netboxInventory) ApplyEvent(event inven.Event) error {
+	return n.snapshot.ApplyEvent(event)
+}
+
+func (n *netboxInventory) Lint(ctx context.Context, scope inven.Scope) ([]inven.LintIssue, error) {
+	return n.snapshot.Lint(ctx, n.maxAge, scope)
+}
+
+func (n *netboxInventory) Recheck(ctx context.Context, devices []inven.ReservedDevice) error {
+	return n.snapshot.Recheck(ctx, devices)
+}
+
+// the snapshot is invalidated whenever objects are patched, so that the next
+// reservation sees them in their new state; releases aren't abandoned midway
+// so they don't take a context
+func (n *netboxInventory) Reserve(ctx context.Context, userID string, metadata map[string]string, releaseState map[string][]map[string]interface{}, devices []inven.ReservedDevice, changes *[]inven.StateChange) error {
+	defer n.snapshot.Invalidate()
+	_, err := inven.UpdateInventory(ctx, n.url, n.token, userID, metadata, releaseState, devices, changes)
+	return err
+}
+
+func (n *netboxInventory) Release(userID string, devices []inven.ReservedDevice, changes *[]inven.StateChange) error {
+	defer n.snapshot.Invalidate()
+	return inven.ReleaseInventory(context.Background(), n.url, n.token, userID, devices, changes)
+}
+
+func (n *netboxInventory) ReleaseSession(userID string, releaseState map[string][]map[string]interface{}) error {
+	defer n.snapshot.Invalidate()
+	return inven.ReleaseStateWithInvenData(context.Background(), releaseState, userID)
+}
+
+func (n *netboxInventory) Restore(change inven.StateChange) error {
+	defer n.snapshot.Invalidate()
+	return inven.RestoreState(context.Background(), n.token, change)
+}
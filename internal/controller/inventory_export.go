@@ -0,0 +1,327 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"keysight/laas/controller/internal/profile"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxInventoryVersions bounds the number of inventory versions kept for
+// diffs; versions are only recorded when the inventory changed
+const maxInventoryVersions = 256
+
+// InventoryFilter selects the inventory devices to export; empty fields
+// select every device
+type InventoryFilter struct {
+	Role   string
+	Vendor string
+	// State is the reservation state of devices, e.g. available or reserved
+	State string
+	// Session selects the devices, or devices with ports, reserved by it
+	Session string
+	Scope   inven.Scope
+}
+
+// ExportInventory returns the complete inventory, in the normalized model the
+// solver uses, limited to the devices selected by filter and the links
+// attached to them
+func ExportInventory(ctx context.Context, filter InventoryFilter) (Inventory, error) {
+	defer profile.LogFuncDuration(time.Now(), "ExportInventory", "", "controller")
+
+	globalInventory, _, err := inventoryProvider.Inventory(ctx, filter.Scope)
+	if err != nil {
+		return Inventory{}, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	inventory, err := ConvertInventory(globalInventory)
+	if err != nil {
+		return Inventory{}, err
+	}
+	if filter.Scope.IsZero() {
+		history.record(inventory)
+	}
+
+	result := Inventory{Desc: inventory.Desc, Devices: map[string]Device{}, Links: []Link{}}
+	for id, device := range inventory.Devices {
+		if filter.match(device) {
+			result.Devices[id] = device
+		}
+	}
+	for _, link := range inventory.Links {
+		if _, ok := result.Devices[link.Src.Device]; ok {
+			result.Links = append(result.Links, link)
+		} else if _, ok := result.Devices[link.Dst.Device]; ok {
+			result.Links = append(result.Links, link)
+		}
+	}
+	return result, nil
+}
+
+// match reports whether the filter selects the device
+func (f InventoryFilter) match(device Device) bool {
+	if f.Role != "" && !strings.EqualFold(device.Role, f.Role) {
+		return false
+	}
+	if f.Vendor != "" && !strings.EqualFold(device.Vendor, f.Vendor) {
+		return false
+	}
+	if f.State != "" && !strings.EqualFold(attrState(device.Attrs), f.State) {
+		return false
+	}
+	if f.Session == "" || strings.EqualFold(attrSession(device.Attrs), f.Session) {
+		return true
+	}
+	for _, port := range device.Ports {
+		if strings.EqualFold(attrSession(port.Attrs), f.Session) {
+			return true
+		}
+	}
+	return false
+}
+
+// attrState returns the reservation state held by the attributes of a device
+// or port; objects without one are available
+func attrState(attrs map[string]string) string {
+	state := strings.ToLower(attrs["state"])
+	if state == "" || state == "null" {
+		return "available"
+	}
+	return state
+}
+
+// attrSession returns the session holding a device or port, as recorded in
+// its attributes, or "" if there's none
+func attrSession(attrs map[string]string) string {
+	session := attrs["session_id"]
+	if session == "null" {
+		return ""
+	}
+	return session
+}
+
+// InventoryDiff lists what changed in the inventory between two times
+type InventoryDiff struct {
+	// From is the time of the inventory version compared against, the latest
+	// one recorded at or before the time asked for; Truncated is set if none
+	// was recorded that early, and the oldest one is compared against
+	From           time.Time         `json:"from"`
+	To             time.Time         `json:"to"`
+	Truncated      bool              `json:"truncated"`
+	DevicesAdded   []string          `json:"devices_added"`
+	DevicesRemoved []string          `json:"devices_removed"`
+	PortsAdded     []string          `json:"ports_added"`
+	PortsRemoved   []string          `json:"ports_removed"`
+	LinksAdded     []string          `json:"links_added"`
+	LinksRemoved   []string          `json:"links_removed"`
+	Changes        []InventoryChange `json:"changes"`
+}
+
+// InventoryChange is a change of a field of a device, or of a port in
+// "device:port" format
+type InventoryChange struct {
+	Object string `json:"object"`
+	Field  string `json:"field"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// inventoryVersion is the part of the inventory diffs are computed from, as
+// recorded at a given time
+type inventoryVersion struct {
+	at      time.Time
+	devices map[string]deviceVersion
+	links   map[string]bool
+}
+
+// deviceVersion holds the fields of a device, and of its ports, whose
+// changes are reported
+type deviceVersion struct {
+	fields map[string]string
+	ports  map[string]map[string]string
+}
+
+// inventoryHistory keeps the versions of the inventory, oldest first
+type inventoryHistory struct {
+	mu       sync.Mutex
+	versions []inventoryVersion
+}
+
+var history = &inventoryHistory{}
+
+// newInventoryVersion returns the version of the inventory at the given time
+func newInventoryVersion(inventory Inventory, at time.Time) inventoryVersion {
+	version := inventoryVersion{at: at, devices: map[string]deviceVersion{}, links: map[string]bool{}}
+	for id, device := range inventory.Devices {
+		dv := deviceVersion{
+			fields: map[string]string{
+				"role":    device.Role,
+				"vendor":  device.Vendor,
+				"model":   device.Model,
+				"state":   attrState(device.Attrs),
+				"session": attrSession(device.Attrs),
+			},
+			ports: map[string]map[string]string{},
+		}
+		for _, port := range device.Ports {
+			dv.ports[port.Name] = map[string]string{
+				"speed":   port.Speed,
+				"state":   attrState(port.Attrs),
+				"session": attrSession(port.Attrs),
+			}
+		}
+		version.devices[id] = dv
+	}
+	for _, link := range inventory.Links {
+		src, dst := link.Src.Device+":"+link.Src.Port, link.Dst.Device+":"+link.Dst.Port
+		// links are undirected
+		if dst < src {
+			src, dst = dst, src
+		}
+		version.links[src+" - "+dst] = true
+	}
+	return version
+}
+
+// record adds the inventory as the latest version, unless it's unchanged
+func (h *inventoryHistory) record(inventory Inventory) inventoryVersion {
+	version := newInventoryVersion(inventory, time.Now())
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := len(h.versions); n != 0 {
+		last := h.versions[n-1]
+		if reflect.DeepEqual(last.devices, version.devices) && reflect.DeepEqual(last.links, version.links) {
+			return version
+		}
+	}
+	h.versions = append(h.versions, version)
+	if len(h.versions) > maxInventoryVersions {
+		h.versions = h.versions[len(h.versions)-maxInventoryVersions:]
+	}
+	return version
+}
+
+// at returns the version of the inventory at the given time, or the oldest
+// one recorded if none is older, reporting it as truncated
+func (h *inventoryHistory) at(t time.Time) (inventoryVersion, bool, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.versions) == 0 {
+		return inventoryVersion{}, false, false
+	}
+	i := sort.Search(len(h.versions), func(i int) bool { return h.versions[i].at.After(t) })
+	if i == 0 {
+		return h.versions[0], true, true
+	}
+	return h.versions[i-1], false, true
+}
+
+// recordInventory records the current complete inventory for diffs
+func recordInventory(ctx context.Context) (inventoryVersion, error) {
+	globalInventory, _, err := inventoryProvider.Inventory(ctx, inven.Scope{})
+	if err != nil {
+		return inventoryVersion{}, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	inventory, err := ConvertInventory(globalInventory)
+	if err != nil {
+		return inventoryVersion{}, err
+	}
+	return history.record(inventory), nil
+}
+
+// DiffInventory returns what changed in the inventory since the given time.
+// Inventory versions are recorded in memory by the inventory refresher, and
+// upon diff and complete export requests, so diffs need the refresher enabled
+// to cover every change; changes from before the controller started are not
+// known, the diff is truncated then
+func DiffInventory(ctx context.Context, since time.Time) (InventoryDiff, error) {
+	defer profile.LogFuncDuration(time.Now(), "DiffInventory", "", "controller")
+
+	base, truncated, ok := history.at(since)
+	current, err := recordInventory(ctx)
+	if err != nil {
+		return InventoryDiff{}, err
+	}
+	if !ok {
+		base, truncated = current, true
+	}
+	diff := diffVersions(base, current)
+	diff.Truncated = truncated
+	return diff, nil
+}
+
+// diffVersions returns the changes from one inventory version to another
+func diffVersions(from inventoryVersion, to inventoryVersion) InventoryDiff {
+	diff := InventoryDiff{
+		From:           from.at,
+		To:             to.at,
+		DevicesAdded:   []string{},
+		DevicesRemoved: []string{},
+		PortsAdded:     []string{},
+		PortsRemoved:   []string{},
+		LinksAdded:     []string{},
+		LinksRemoved:   []string{},
+		Changes:        []InventoryChange{},
+	}
+	for id, device := range to.devices {
+		old, ok := from.devices[id]
+		if !ok {
+			diff.DevicesAdded = append(diff.DevicesAdded, id)
+			continue
+		}
+		diff.Changes = append(diff.Changes, fieldChanges(id, old.fields, device.fields)...)
+		for name, port := range device.ports {
+			oldPort, ok := old.ports[name]
+			if !ok {
+				diff.PortsAdded = append(diff.PortsAdded, id+":"+name)
+				continue
+			}
+			diff.Changes = append(diff.Changes, fieldChanges(id+":"+name, oldPort, port)...)
+		}
+		for name := range old.ports {
+			if _, ok := device.ports[name]; !ok {
+				diff.PortsRemoved = append(diff.PortsRemoved, id+":"+name)
+			}
+		}
+	}
+	for id := range from.devices {
+		if _, ok := to.devices[id]; !ok {
+			diff.DevicesRemoved = append(diff.DevicesRemoved, id)
+		}
+	}
+	for link := range to.links {
+		if !from.links[link] {
+			diff.LinksAdded = append(diff.LinksAdded, link)
+		}
+	}
+	for link := range from.links {
+		if !to.links[link] {
+			diff.LinksRemoved = append(diff.LinksRemoved, link)
+		}
+	}
+	for _, list := range [][]string{diff.DevicesAdded, diff.DevicesRemoved, diff.PortsAdded, diff.PortsRemoved, diff.LinksAdded, diff.LinksRemoved} {
+		sort.Strings(list)
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Object != diff.Changes[j].Object {
+			return diff.Changes[i].Object < diff.Changes[j].Object
+		}
+		return diff.Changes[i].Field < diff.Changes[j].Field
+	})
+	return diff
+}
+
+// fieldChanges returns the changes of the fields of an object
+func fieldChanges(object string, from map[string]string, to map[string]string) []InventoryChange {
+	changes := []InventoryChange{}
+	for field, value := range to {
+		if from[field] != value {
+			changes = append(changes, InventoryChange{Object: object, Field: field, From: from[field], To: value})
+		}
+	}
+	return changes
+}
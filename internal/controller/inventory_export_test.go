@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testInventory returns an inventory of a DUT and an ATE linked by one link
+func testInventory() Inventory {
+	return Inventory{
+		Devices: map[string]Device{
+			"dut1": {Id: "dut1", Role: "DUT", Vendor: "cisco", Model: "8000", Attrs: map[string]string{}, Ports: []Port{
+				{Id: "eth1", Name: "eth1", Speed: "S_100GB", Attrs: map[string]string{}},
+				{Id: "eth2", Name: "eth2", Speed: "S_100GB", Attrs: map[string]string{}},
+			}},
+			"ate1": {Id: "ate1", Role: "ATE", Vendor: "keysight", Attrs: map[string]string{}, Ports: []Port{
+				{Id: "p1", Name: "p1", Speed: "S_100GB", Attrs: map[string]string{}},
+			}},
+		},
+		Links: []Link{{Src: InputLinkEndpoint{Device: "dut1", Port: "eth1"}, Dst: InputLinkEndpoint{Device: "ate1", Port: "p1"}}},
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		change func(inventory *Inventory)
+		want   InventoryDiff
+	}{
+		{
+			name:   "unchanged",
+			change: func(inventory *Inventory) {},
+			want:   InventoryDiff{},
+		},
+		{
+			name: "link listed from the other end",
+			change: func(inventory *Inventory) {
+				inventory.Links = []Link{{Src: InputLinkEndpoint{Device: "ate1", Port: "p1"}, Dst: InputLinkEndpoint{Device: "dut1", Port: "eth1"}}}
+			},
+			want: InventoryDiff{},
+		},
+		{
+			name: "device added and removed",
+			change: func(inventory *Inventory) {
+				delete(inventory.Devices, "ate1")
+				inventory.Devices["dut2"] = Device{Id: "dut2", Role: "DUT", Attrs: map[string]string{}}
+			},
+			want: InventoryDiff{DevicesAdded: []string{"dut2"}, DevicesRemoved: []string{"ate1"}},
+		},
+		{
+			name: "ports added and removed",
+			change: func(inventory *Inventory) {
+				dut := inventory.Devices["dut1"]
+				dut.Ports = []Port{dut.Ports[0], {Id: "eth3", Name: "eth3", Speed: "S_10GB", Attrs: map[string]string{}}}
+				inventory.Devices["dut1"] = dut
+			},
+			want: InventoryDiff{PortsAdded: []string{"dut1:eth3"}, PortsRemoved: []string{"dut1:eth2"}},
+		},
+		{
+			name: "link moved",
+			change: func(inventory *Inventory) {
+				inventory.Links = []Link{{Src: InputLinkEndpoint{Device: "dut1", Port: "eth2"}, Dst: InputLinkEndpoint{Device: "ate1", Port: "p1"}}}
+			},
+			want: InventoryDiff{LinksAdded: []string{"ate1:p1 - dut1:eth2"}, LinksRemoved: []string{"ate1:p1 - dut1:eth1"}},
+		},
+		{
+			name: "device and port reserved",
+			change: func(inventory *Inventory) {
+				dut := inventory.Devices["dut1"]
+				dut.Attrs = map[string]string{"state": "Reserved", "session_id": "s1"}
+				dut.Ports[1].Attrs = map[string]string{"state": "reserved", "session_id": "s1"}
+				dut.Ports[1].Speed = "S_400GB"
+				inventory.Devices["dut1"] = dut
+			},
+			want: InventoryDiff{Changes: []InventoryChange{
+				{Object: "dut1", Field: "session", From: "", To: "s1"},
+				{Object: "dut1", Field: "state", From: "available", To: "reserved"},
+				{Object: "dut1:eth2", Field: "session", From: "", To: "s1"},
+				{Object: "dut1:eth2", Field: "speed", From: "S_100GB", To: "S_400GB"},
+				{Object: "dut1:eth2", Field: "state", From: "available", To: "reserved"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := testInventory()
+			tt.change(&changed)
+			got := diffVersions(newInventoryVersion(testInventory(), from), newInventoryVersion(changed, to))
+
+			want := tt.want
+			want.From, want.To = from, to
+			for _, list := range []*[]string{&want.DevicesAdded, &want.DevicesRemoved, &want.PortsAdded, &want.PortsRemoved, &want.LinksAdded, &want.LinksRemoved} {
+				if *list == nil {
+					*list = []string{}
+				}
+			}
+			if want.Changes == nil {
+				want.Changes = []InventoryChange{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("diffVersions() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestInventoryHistoryAt(t *testing.T) {
+	h := &inventoryHistory{}
+	if _, _, ok := h.at(time.Now()); ok {
+		t.Fatal("at() found a version in an empty history")
+	}
+	first := h.record(testInventory())
+	// unchanged inventories aren't recorded again
+	h.record(testInventory())
+	changed := testInventory()
+	delete(changed.Devices, "ate1")
+	second := h.record(changed)
+	if len(h.versions) != 2 {
+		t.Fatalf("history holds %d versions, want 2", len(h.versions))
+	}
+
+	tests := []struct {
+		name          string
+		at            time.Time
+		want          time.Time
+		wantTruncated bool
+	}{
+		{name: "before any version", at: first.at.Add(-time.Second), want: first.at, wantTruncated: true},
+		{name: "at the first version", at: first.at, want: first.at},
+		{name: "after the latest version", at: second.at.Add(time.Second), want: second.at},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, truncated, ok := h.at(tt.at)
+			if !ok || !version.at.Equal(tt.want) || truncated != tt.wantTruncated {
+				t.Errorf("at() = %v, %v, %v, want %v, %v, true", version.at, truncated, ok, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
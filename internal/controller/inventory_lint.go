@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"keysight/laas/controller/internal/profile"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// switchPortPattern matches L1 switch ports given by position, e.g. 1.2
+var switchPortPattern = regexp.MustCompile(`^\d+(\.\d+){1,3}$`)
+
+// isSwitchPort reports whether the port follows the L1 switch port
+// convention, p-prefixed or given by position
+func isSwitchPort(port string) bool {
+	return len(port) > 0 && (strings.ToLower(port)[0] == 'p' || switchPortPattern.MatchString(port))
+}
+
+// enumValues returns the values of a goopentestbed enum
+func enumValues(enum interface{}) map[string]bool {
+	values := map[string]bool{}
+	v := reflect.ValueOf(enum)
+	for i := 0; i < v.NumField(); i++ {
+		values[v.Field(i).String()] = true
+	}
+	return values
+}
+
+var (
+	portSpeeds = enumValues(goopentestbed.PortSpeed)
+	portPmds   = enumValues(goopentestbed.PortPmd)
+)
+
+// InventoryLint is the report of the problems found in the inventory
+type InventoryLint struct {
+	Errors   int               `json:"errors"`
+	Warnings int               `json:"warnings"`
+	Issues   []inven.LintIssue `json:"issues"`
+}
+
+// linter is implemented by inventory providers which check the data the
+// inventory is built from
+type linter interface {
+	Lint(ctx context.Context, scope inven.Scope) ([]inven.LintIssue, error)
+}
+
+// LintInventory checks the inventory of the devices in scope against what
+// the solver relies on; problems which make it fail, or silently leave
+// devices, ports and links out of reservations, are reported
+func LintInventory(ctx context.Context, scope inven.Scope) (InventoryLint, error) {
+	defer profile.LogFuncDuration(time.Now(), "LintInventory", "", "controller")
+
+	issues := []inven.LintIssue{}
+	if l, ok := inventoryProvider.(linter); ok {
+		backendIssues, err := l.Lint(ctx, scope)
+		if err != nil {
+			return InventoryLint{}, fmt.Errorf("failed to lint inventory: %w", err)
+		}
+		issues = append(issues, backendIssues...)
+	}
+	globalInventory, _, err := inventoryProvider.Inventory(ctx, scope)
+	if err != nil {
+		return InventoryLint{}, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	if inventory, err := ConvertInventory(globalInventory); err != nil {
+		issues = append(issues, inven.LintIssue{
+			Severity: inven.LintError,
+			Rule:     "inventory-invalid",
+			Object:   globalInventory.Name,
+			Message:  err.Error(),
+		})
+	} else {
+		issues = append(issues, lintInventory(inventory)...)
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Severity != issues[j].Severity {
+			return issues[i].Severity == inven.LintError
+		}
+		return issues[i].Object < issues[j].Object
+	})
+	report := InventoryLint{Issues: issues}
+	for _, issue := range issues {
+		if issue.Severity == inven.LintError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+	log.Info().Int("Errors", report.Errors).Int("Warnings", report.Warnings).Msg("Linted inventory")
+	return report, nil
+}
+
+// lintInventory returns the problems of the inventory LoadConcreteGraph
+// builds the solver graph from
+func lintInventory(inventory Inventory) []inven.LintIssue {
+	issues := []inven.LintIssue{}
+	add := func(severity string, rule string, object string, format string, args ...interface{}) {
+		issues = append(issues, inven.LintIssue{Severity: severity, Rule: rule, Object: object, Message: fmt.Sprintf(format, args...)})
+	}
+
+	ports := map[string]bool{}
+	for id, device := range inventory.Devices {
+		role := strings.ToLower(device.Role)
+		for _, port := range device.Ports {
+			name := id + ":" + port.Id
+			if port.Id == "" {
+				// devices without interfaces get a single empty port
+				continue
+			}
+			if ports[name] {
+				add(inven.LintError, "duplicate-port", name, "port is listed more than once, only one is used")
+			}
+			ports[name] = true
+			if port.Speed == "" || port.Speed == "SPEED_UNSPECIFIED" {
+				if role == "dut" {
+					add(inven.LintError, "speed-missing", name, "DUT port has no speed, it never matches a testbed port asking for one")
+				}
+			} else if !portSpeeds[port.Speed] {
+				add(inven.LintError, "speed-invalid", name, "speed %q is not a valid port speed", port.Speed)
+			}
+			if port.Pmd != "" && !portPmds[port.Pmd] {
+				add(inven.LintError, "pmd-invalid", name, "pmd %q is not a valid port pmd", port.Pmd)
+			}
+			if role == "l1s" && !isSwitchPort(port.Id) {
+				add(inven.LintError, "switch-port-name", name, "L1 switch port is neither p-prefixed nor given by position, it's never configured")
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, link := range inventory.Links {
+		src, dst := link.Src.Device+":"+link.Src.Port, link.Dst.Device+":"+link.Dst.Port
+		// links are undirected, and listed from both ends
+		if dst < src {
+			src, dst = dst, src
+		}
+		name := src + " - " + dst
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		for _, end := range []InputLinkEndpoint{link.Src, link.Dst} {
+			if _, ok := inventory.Devices[end.Device]; !ok {
+				add(inven.LintError, "link-unknown-device", name, "device %q is not in the inventory, the link is left out", end.Device)
+			} else if !ports[end.Device+":"+end.Port] {
+				add(inven.LintError, "link-unknown-port", name, "port %q is not in the inventory, the link is left out", end.Device+":"+end.Port)
+			}
+		}
+	}
+	return issues
+}
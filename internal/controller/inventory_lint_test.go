@@ -0,0 +1,124 @@
+package controller
+
+import (
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestIsSwitchPort(t *testing.T) {
+	tests := map[string]bool{
+		"p1":    true,
+		"P12":   true,
+		"1.2":   true,
+		"1.2.3": true,
+		"1":     false,
+		"eth1":  false,
+		"":      false,
+	}
+	for port, want := range tests {
+		if got := isSwitchPort(port); got != want {
+			t.Errorf("isSwitchPort(%q) = %v, want %v", port, got, want)
+		}
+	}
+}
+
+func TestLintInventory(t *testing.T) {
+	link := func(src string, srcPort string, dst string, dstPort string) Link {
+		return Link{Src: InputLinkEndpoint{Device: src, Port: srcPort}, Dst: InputLinkEndpoint{Device: dst, Port: dstPort}}
+	}
+	tests := []struct {
+		name   string
+		change func(inventory *Inventory)
+		want   []inven.LintIssue
+	}{
+		{
+			name:   "consistent inventory",
+			change: func(inventory *Inventory) {},
+		},
+		{
+			name: "DUT port without speed",
+			change: func(inventory *Inventory) {
+				inventory.Devices["dut1"].Ports[1].Speed = "SPEED_UNSPECIFIED"
+				inventory.Devices["ate1"].Ports[0].Speed = ""
+			},
+			want: []inven.LintIssue{{Severity: inven.LintError, Rule: "speed-missing", Object: "dut1:eth2"}},
+		},
+		{
+			name: "invalid speed and pmd",
+			change: func(inventory *Inventory) {
+				inventory.Devices["dut1"].Ports[0].Speed = "100000000"
+				inventory.Devices["dut1"].Ports[0].Pmd = "PMD_100G_BOGUS"
+			},
+			want: []inven.LintIssue{
+				{Severity: inven.LintError, Rule: "speed-invalid", Object: "dut1:eth1"},
+				{Severity: inven.LintError, Rule: "pmd-invalid", Object: "dut1:eth1"},
+			},
+		},
+		{
+			name: "duplicate port",
+			change: func(inventory *Inventory) {
+				dut := inventory.Devices["dut1"]
+				dut.Ports = append(dut.Ports, dut.Ports[0])
+				inventory.Devices["dut1"] = dut
+			},
+			want: []inven.LintIssue{{Severity: inven.LintError, Rule: "duplicate-port", Object: "dut1:eth1"}},
+		},
+		{
+			name: "L1 switch port not following the convention",
+			change: func(inventory *Inventory) {
+				inventory.Devices["l1s1"] = Device{Id: "l1s1", Role: "L1S", Ports: []Port{{Id: "p1"}, {Id: "2.1"}, {Id: "eth3"}}}
+			},
+			want: []inven.LintIssue{{Severity: inven.LintError, Rule: "switch-port-name", Object: "l1s1:eth3"}},
+		},
+		{
+			name: "links to unknown devices and ports, listed from both ends",
+			change: func(inventory *Inventory) {
+				inventory.Links = append(inventory.Links,
+					link("dut1", "eth2", "server1", "eno1"),
+					link("server1", "eno1", "dut1", "eth2"),
+					link("dut1", "eth9", "ate1", "p1"),
+				)
+			},
+			want: []inven.LintIssue{
+				{Severity: inven.LintError, Rule: "link-unknown-device", Object: "dut1:eth2 - server1:eno1"},
+				{Severity: inven.LintError, Rule: "link-unknown-port", Object: "ate1:p1 - dut1:eth9"},
+			},
+		},
+		{
+			name: "device without interfaces",
+			change: func(inventory *Inventory) {
+				inventory.Devices["dut2"] = Device{Id: "dut2", Role: "DUT", Ports: []Port{{}}}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inventory := testInventory()
+			tt.change(&inventory)
+			got := lintInventory(inventory)
+			// messages are for people, rules tell issues apart
+			for i := range got {
+				got[i].Message = ""
+			}
+			want := tt.want
+			if want == nil {
+				want = []inven.LintIssue{}
+			}
+			less := func(issues []inven.LintIssue) func(i, j int) bool {
+				return func(i, j int) bool {
+					if issues[i].Object != issues[j].Object {
+						return issues[i].Object < issues[j].Object
+					}
+					return issues[i].Rule < issues[j].Rule
+				}
+			}
+			sort.Slice(got, less(got))
+			sort.Slice(want, less(want))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("lintInventory() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
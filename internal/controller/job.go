@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// JobStatus is the state of an asynchronous reservation job
+type JobStatus string
+
+const (
+	JobRunning    JobStatus = "running"
+	JobSucceeded  JobStatus = "succeeded"
+	JobFailed     JobStatus = "failed"
+	JobCancelling JobStatus = "cancelling"
+	JobCancelled  JobStatus = "cancelled"
+)
+
+// jobRetention is how long finished jobs are kept around for polling
+const jobRetention = time.Hour
+
+// ErrJobNotFound is returned for unknown (or expired) job IDs
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStage is a progress point reached by a job
+type JobStage struct {
+	Stage ReserveStage
+	At    time.Time
+}
+
+// Job is a snapshot of an asynchronous reservation
+type Job struct {
+	ID     string
+	Status JobStatus
+	Stages []JobStage
+	// QueuePosition is the position of the job in the wait queue, if queued
+	QueuePosition int
+	CreatedAt     time.Time
+	FinishedAt    *time.Time
+	SessionID     string
+	Testbed       string
+	Error         string
+}
+
+type reserveJob struct {
+	Job
+	cancel context.CancelFunc
+}
+
+var (
+	jobs      = map[string]*reserveJob{}
+	jobsMutex sync.Mutex
+)
+
+// StartReserveJob reserves the testbed in the background and returns the job
+// tracking it
+func StartReserveJob(data goopentestbed.Testbed, opts ReserveOptions) (Job, error) {
+	id, err := generateUserID()
+	if err != nil {
+		return Job{}, fmt.Errorf("error generating job ID: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &reserveJob{
+		Job:    Job{ID: "job-" + id, Status: JobRunning, CreatedAt: time.Now()},
+		cancel: cancel,
+	}
+
+	jobsMutex.Lock()
+	pruneJobs(job.CreatedAt)
+	jobs[job.ID] = job
+	snapshot := job.snapshot()
+	jobsMutex.Unlock()
+
+	opts.queueID = job.ID
+	opts.Progress = func(stage ReserveStage) {
+		jobsMutex.Lock()
+		defer jobsMutex.Unlock()
+		job.Stages = append(job.Stages, JobStage{Stage: stage, At: time.Now()})
+	}
+	go runReserveJob(ctx, job, data, opts)
+
+	log.Info().Str("JobID", job.ID).Msg("Reserve job started")
+	return snapshot, nil
+}
+
+func runReserveJob(ctx context.Context, job *reserveJob, data goopentestbed.Testbed, opts ReserveOptions) {
+	defer job.cancel()
+	result, err := Reserve(ctx, data, opts)
+
+	jobsMutex.Lock()
+	if err == nil {
+		job.SessionID = result.YieldResponse().Sessionid()
+		job.Testbed = result.YieldResponse().Testbed()
+	}
+	cancelled := job.Status == JobCancelling
+	jobsMutex.Unlock()
+
+	if err == nil && cancelled {
+		// cancelled after the last checkpoint, undo the reservation
+		releaseJobSession(job)
+		return
+	}
+	finishJob(job, err, cancelled)
+}
+
+// releaseJobSession releases the session reserved by a cancelled job
+func releaseJobSession(job *reserveJob) {
+	userID := job.SessionID
+	unlock := sessionLocks.lock(userID)
+	_, err := releaseSession(userID)
+	unlock()
+	if err != nil {
+		err = fmt.Errorf("failed to release session %s of cancelled job: %w", userID, err)
+	}
+	finishJob(job, err, true)
+}
+
+// finishJob records the outcome of a job
+func finishJob(job *reserveJob, err error, cancelled bool) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	finishedAt := time.Now()
+	job.FinishedAt = &finishedAt
+	switch {
+	case cancelled && err == nil:
+		job.Status = JobCancelled
+		job.Testbed = ""
+	case cancelled && errors.Is(err, context.Canceled):
+		job.Status = JobCancelled
+		job.Error = err.Error()
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobSucceeded
+	}
+	log.Info().Str("JobID", job.ID).Str("Status", string(job.Status)).Msg("Reserve job finished")
+}
+
+// GetJob returns the current state of a job
+func GetJob(id string) (Job, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	job, ok := jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job.snapshot(), nil
+}
+
+// CancelJob cancels a running job, rolling back whatever it did so far; a
+// job which already succeeded gets its session released
+func CancelJob(id string) (Job, error) {
+	jobsMutex.Lock()
+	job, ok := jobs[id]
+	if !ok {
+		jobsMutex.Unlock()
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	status := job.Status
+	if status == JobRunning || status == JobSucceeded {
+		job.Status = JobCancelling
+		log.Info().Str("JobID", id).Msg("Reserve job cancelling")
+	}
+	jobsMutex.Unlock()
+
+	switch status {
+	case JobRunning:
+		job.cancel()
+	case JobSucceeded:
+		releaseJobSession(job)
+	}
+	return GetJob(id)
+}
+
+// snapshot copies the job; must be called with jobsMutex held
+func (job *reserveJob) snapshot() Job {
+	snapshot := job.Job
+	snapshot.Stages = append([]JobStage{}, job.Stages...)
+	snapshot.QueuePosition = queue.position(job.ID)
+	return snapshot
+}
+
+// pruneJobs forgets jobs finished longer than jobRetention ago; must be
+// called with jobsMutex held
+func pruneJobs(now time.Time) {
+	for id, job := range jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+}
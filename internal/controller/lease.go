@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"fmt"
+	"keysight/laas/controller/config"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/store"
+	"time"
+)
+
+// Renew extends the lease of a session by the given duration, counted from now
+func Renew(userID string, lease time.Duration) (*store.Session, error) {
+	defer profile.LogFuncDuration(time.Now(), "Renew", "", "controller")
+	if lease <= 0 {
+		return nil, fmt.Errorf("lease duration must be positive, got %v", lease)
+	}
+
+	unlock := sessionLocks.lock(userID)
+	defer unlock()
+
+	session, err := sessionStore.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Expired(time.Now()) {
+		return nil, fmt.Errorf("lease of session %s has already expired", userID)
+	}
+	if !session.Active() {
+		return nil, fmt.Errorf("session %s is not active", userID)
+	}
+	expiresAt := time.Now().Add(lease)
+
+	bookingMutex.Lock()
+	defer bookingMutex.Unlock()
+	if err := checkBookings(session, expiresAt); err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = &expiresAt
+	if err := sessionStore.Save(session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	log.Info().Str("UserID", userID).Time("ExpiresAt", expiresAt).Msg("Renewed session lease")
+	return session, nil
+}
+
+// SpawnLeaseReaper spawns a goroutine in background that releases sessions
+// with an expired lease every interval
+func SpawnLeaseReaper() error {
+	interval := time.Duration(*config.Config.LeaseReaperIntervalSeconds) * time.Second
+	if interval <= 0 {
+		return fmt.Errorf("lease reaper interval must be positive, got %v", interval)
+	}
+	log.Info().Dur("interval", interval).Msg("Lease reaper initiated")
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			reapExpiredSessions()
+		}
+	}()
+
+	return nil
+}
+
+func reapExpiredSessions() {
+	log.Debug().Msg("Checking for expired sessions")
+	sessions, err := sessionStore.List()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list sessions")
+		return
+	}
+	for _, session := range sessions {
+		if session.Expired(time.Now()) {
+			reapSession(session.ID)
+		}
+	}
+}
+
+func reapSession(userID string) {
+	unlock := sessionLocks.lock(userID)
+	defer unlock()
+
+	// the session may have been renewed or released in the meantime
+	session, err := sessionStore.Get(userID)
+	if err != nil || !session.Expired(time.Now()) {
+		return
+	}
+
+	log.Warn().Str("UserID", userID).Time("ExpiresAt", *session.ExpiresAt).Msg("Releasing session with expired lease")
+	if _, err := releaseSession(userID); err != nil {
+		log.Error().Err(err).Str("UserID", userID).Msg("Failed to release expired session")
+	}
+}
@@ -0,0 +1,540 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"keysight/laas/controller/config"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/store"
+	"strings"
+	"time"
+
+	"github.com/open-traffic-generator/openl1s/gol1s"
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+	graph "github.com/openconfig/ondatra/binding/portgraph"
+)
+
+// pinAttr is the graph attribute pinning the devices and ports a session
+// already holds to the same inventory devices and ports while it's modified
+const pinAttr = "pin"
+
+// ModifySession adds the devices, ports and links of data to an active
+// session; a device whose ID is already part of the session gets the ports
+// added to it. Only the added resources are solved for, claimed, connected
+// and marked as reserved, the ones the session holds are left as they are
+func ModifySession(ctx context.Context, userID string, data goopentestbed.Testbed) (*store.Session, error) {
+	defer profile.LogFuncDuration(time.Now(), "ModifySession", "", "controller")
+
+	unlock := sessionLocks.lock(userID)
+	defer unlock()
+
+	session, err := sessionStore.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.Active() {
+		return nil, fmt.Errorf("session %s is not active", userID)
+	}
+	if _, err := CheckForDuplicateIDs(data); err != nil {
+		return nil, err
+	}
+	topology, err := sessionTopology(session)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("UserID", userID).Interface("Request", data).Msg("Modify request")
+
+	// links may refer to devices of the session, which ConvertData does not
+	// know about
+	additions := ConvertData(data)
+	additions.Links = nil
+	for _, link := range data.Links().Items() {
+		additions.Links = append(additions.Links, Link{
+			Src: InputLinkEndpoint{Device: link.Src().Device(), Port: link.Src().Port()},
+			Dst: InputLinkEndpoint{Device: link.Dst().Device(), Port: link.Dst().Port()},
+		})
+	}
+	merged, err := mergeTopology(topology, additions)
+	if err != nil {
+		return nil, err
+	}
+	testbed := graph.AbstractGraph{}
+	LoadAbstractGraph(merged, &testbed)
+	pinAbstractGraph(&testbed, topology)
+
+	globalInventory, _, err := inventoryProvider.Inventory(ctx, inven.Scope{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	globalConfig, err := ConvertInventory(globalInventory)
+	if err != nil {
+		return nil, err
+	}
+	booked, err := bookedResources(userID, time.Now(), session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		assignment *graph.Assignment
+		devices    map[string]BDevice
+		added      map[string]BDevice
+		resources  claimedResources
+		reserved   []inven.ReservedDevice
+	)
+	// resources found reserved in the inventory upon recheck
+	stale := claimedResources{}
+	for attempt := 1; ; attempt++ {
+		// Resources held by other sessions are left out while the session's
+		// own resources stay available to the devices and ports pinned to them
+		inventory := LoadConcreteGraph(globalConfig)
+		claims.exclude(inventory)
+		markReserved(inventory, booked)
+		markReserved(inventory, stale)
+		markFree(inventory, sessionResources(session))
+		pinConcreteGraph(inventory)
+		assignment, err = graph.Solve(ctx, &testbed, &inventory.Graph)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("found inventory mismatch: %w", err)
+		}
+		devices = assignedDevices(&testbed, assignment)
+		unpinConcreteGraph(inventory)
+
+		added = addedDevices(topology, devices)
+		resources = claimedResources{}
+		// devices already reserved by the session only get their ports updated
+		reserved = []inven.ReservedDevice{}
+		for id, device := range added {
+			_, held := topology.Devices[id]
+			if !held && exclusiveDevice(device.Attrs["role"]) {
+				resources.devices = append(resources.devices, device.Id)
+			}
+			for _, port := range device.Ports {
+				resources.ports = append(resources.ports, port.Id)
+			}
+			item := reservedDevices(map[string]BDevice{id: device})[0]
+			item.PortsOnly = held
+			reserved = append(reserved, item)
+		}
+		err = claimAdditional(userID, resources, session.ExpiresAt)
+		if err == nil {
+			// The inventory may be a little stale, so the state of the added
+			// resources is checked once more now that they're claimed
+			err = inventoryProvider.Recheck(ctx, reserved)
+			if err == nil {
+				break
+			}
+			claims.unclaim(userID, resources)
+			reservedErr := &inven.ReservedError{}
+			if !errors.As(err, &reservedErr) {
+				return nil, err
+			}
+			stale.add(claimedResources{devices: reservedErr.Devices, ports: reservedErr.Ports})
+		}
+		if attempt == maxClaimAttempts {
+			return nil, fmt.Errorf("failed to claim resources: %w: %w", ErrResourcesUnavailable, err)
+		}
+		log.Warn().Err(err).Str("UserID", userID).Int("Attempt", attempt).Msg("Resources claimed concurrently, solving again")
+	}
+
+	// Every side effect from here on is recorded, so that a failing step
+	// leaves the session as it was before the request
+	previous := *session
+	tx := newTransaction(userID)
+	tx.record("unclaim added resources", func() error {
+		claims.unclaim(userID, resources)
+		return nil
+	})
+	fail := func(err error) (*store.Session, error) {
+		rollbackErr := tx.rollback()
+		*session = previous
+		if err := saveSession(session); err != nil {
+			log.Error().Err(err).Str("UserID", userID).Msg("Failed to save session")
+		}
+		return nil, &ReserveError{Err: err, RollbackErr: rollbackErr}
+	}
+
+	links := append([]Link{}, topology.Links...)
+	for _, edge := range testbed.Edges {
+		destLink := concreteLink(assignment.Port2Port[edge.Src].Desc, assignment.Port2Port[edge.Dst].Desc)
+		if err := setupLink(tx, userID, globalConfig, destLink); err != nil {
+			return fail(err)
+		}
+		links = append(links, destLink)
+	}
+	if err := saveSession(session); err != nil {
+		return fail(fmt.Errorf("failed to save session: %w", err))
+	}
+
+	if err := reserveInventory(ctx, tx, userID, session.Metadata, reserved); err != nil {
+		return fail(err)
+	}
+	response, err := generateTestbed(userID, devices, links)
+	if err != nil {
+		return fail(err)
+	}
+
+	session.Devices = sessionDevices(devices)
+	session.Testbed = response
+	if err := setTopology(session, devices, links); err != nil {
+		return fail(err)
+	}
+	if err := saveSession(session); err != nil {
+		return fail(fmt.Errorf("failed to save session: %w", err))
+	}
+	log.Info().Str("UserID", userID).Interface("Added", added).Msg("Modified session")
+	return session, nil
+}
+
+// mergeTopology returns the testbed holding the devices and ports of the
+// session, without any constraint, along with the additions
+func mergeTopology(topology Testbed, additions Testbed) (Testbed, error) {
+	if len(additions.Devices) == 0 && len(additions.Links) == 0 {
+		return Testbed{}, fmt.Errorf("no devices or links to add")
+	}
+	merged := Testbed{Desc: "testbed", Devices: map[string]BDevice{}}
+	linked := map[string]bool{}
+	for _, link := range topology.Links {
+		linked[link.Src.Device+":"+link.Src.Port] = true
+		linked[link.Dst.Device+":"+link.Dst.Port] = true
+	}
+	for id, device := range topology.Devices {
+		ports := map[string]Port{}
+		for desc := range device.Ports {
+			ports[strings.TrimPrefix(desc, id+":")] = Port{Attrs: map[string]string{}}
+		}
+		merged.Devices[id] = BDevice{Id: id, Attrs: map[string]string{}, Ports: ports}
+	}
+	for id, device := range additions.Devices {
+		current, ok := merged.Devices[id]
+		if !ok {
+			merged.Devices[id] = device
+			continue
+		}
+		for pid, port := range device.Ports {
+			if _, ok := current.Ports[pid]; ok {
+				return Testbed{}, fmt.Errorf("port %s of device %s is already part of the session", pid, id)
+			}
+			current.Ports[pid] = port
+		}
+	}
+	for _, link := range additions.Links {
+		for _, endpoint := range []InputLinkEndpoint{link.Src, link.Dst} {
+			device, ok := merged.Devices[endpoint.Device]
+			if !ok {
+				return Testbed{}, fmt.Errorf("link endpoint %s:%s refers to an unknown device", endpoint.Device, endpoint.Port)
+			}
+			if _, ok := device.Ports[endpoint.Port]; !ok {
+				return Testbed{}, fmt.Errorf("link endpoint %s:%s refers to an unknown port", endpoint.Device, endpoint.Port)
+			}
+			// ports of the session already linked can not take another link
+			if current, ok := topology.Devices[endpoint.Device]; ok {
+				if port, ok := current.Ports[endpoint.Device+":"+endpoint.Port]; ok && linked[port.Id] {
+					return Testbed{}, fmt.Errorf("port %s:%s is already linked", endpoint.Device, endpoint.Port)
+				}
+			}
+		}
+	}
+	merged.Links = additions.Links
+	return merged, nil
+}
+
+// pinAbstractGraph constrains the testbed devices and ports of the session to
+// the inventory devices and ports they're mapped to
+func pinAbstractGraph(testbed *graph.AbstractGraph, topology Testbed) {
+	for _, node := range testbed.Nodes {
+		device, ok := topology.Devices[node.Desc]
+		if !ok {
+			continue
+		}
+		node.Constraints[pinAttr] = graph.Equal(device.Id)
+		for _, port := range node.Ports {
+			if mapped, ok := device.Ports[port.Desc]; ok {
+				port.Constraints[pinAttr] = graph.Equal(mapped.Id)
+			}
+		}
+	}
+}
+
+// pinConcreteGraph sets the attribute matched by pinned testbed devices and
+// ports on every device and port of the inventory graph
+func pinConcreteGraph(inventory *ConcreteInventory) {
+	for _, node := range inventory.Graph.Nodes {
+		node.Attrs[pinAttr] = node.Desc
+		for _, port := range node.Ports {
+			port.Attrs[pinAttr] = port.Desc
+		}
+	}
+}
+
+// unpinConcreteGraph drops the attributes set by pinConcreteGraph, so that
+// they do not end up in the generated testbed
+func unpinConcreteGraph(inventory *ConcreteInventory) {
+	for _, node := range inventory.Graph.Nodes {
+		delete(node.Attrs, pinAttr)
+		for _, port := range node.Ports {
+			delete(port.Attrs, pinAttr)
+		}
+	}
+}
+
+// addedDevices returns the devices of the solved testbed which are not part
+// of the session yet, along with the ports added to devices which are
+func addedDevices(topology Testbed, devices map[string]BDevice) map[string]BDevice {
+	added := map[string]BDevice{}
+	for id, device := range devices {
+		current, ok := topology.Devices[id]
+		if !ok {
+			added[id] = device
+			continue
+		}
+		ports := map[string]Port{}
+		for desc, port := range device.Ports {
+			if _, ok := current.Ports[desc]; !ok {
+				ports[desc] = port
+			}
+		}
+		if len(ports) != 0 {
+			device.Ports = ports
+			added[id] = device
+		}
+	}
+	return added
+}
+
+// claimAdditional claims more resources for a session holding resources
+// until the given time, unless some of them are booked by a scheduled
+// session in the meantime
+func claimAdditional(userID string, resources claimedResources, until *time.Time) error {
+	bookingMutex.Lock()
+	defer bookingMutex.Unlock()
+
+	if err := claims.claim(userID, resources); err != nil {
+		return err
+	}
+	booked, err := bookedResources(userID, time.Now(), until)
+	if err != nil {
+		claims.unclaim(userID, resources)
+		return err
+	}
+	if conflict := booked.conflict(resources); conflict != nil {
+		claims.unclaim(userID, resources)
+		return conflict
+	}
+	return nil
+}
+
+// ReleasePartial releases part of an active session; devices of data listed
+// without ports are released entirely, along with their ports, otherwise only
+// the listed ports are. Releasing a link releases the ports at both its ends,
+// and links are torn down along with the ports they connect
+func ReleasePartial(userID string, data goopentestbed.Testbed) (*store.Session, error) {
+	defer profile.LogFuncDuration(time.Now(), "ReleasePartial", "", "controller")
+
+	unlock := sessionLocks.lock(userID)
+	defer unlock()
+
+	session, err := sessionStore.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !session.Active() {
+		return nil, fmt.Errorf("session %s is not active", userID)
+	}
+	topology, err := sessionTopology(session)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("UserID", userID).Interface("Request", data).Msg("Partial release request")
+
+	wholeDevices, releasedPorts, err := releasedResources(topology, data)
+	if err != nil {
+		return nil, err
+	}
+	remaining := map[string]BDevice{}
+	released := map[string]BDevice{}
+	releasedIDs := map[string]bool{}
+	for id, device := range topology.Devices {
+		kept := map[string]Port{}
+		freed := map[string]Port{}
+		for desc, port := range device.Ports {
+			if releasedPorts[desc] {
+				freed[desc] = port
+				releasedIDs[port.Id] = true
+			} else {
+				kept[desc] = port
+			}
+		}
+		if len(freed) != 0 || wholeDevices[id] {
+			releasedDevice := device
+			releasedDevice.Ports = freed
+			released[id] = releasedDevice
+		}
+		if !wholeDevices[id] {
+			keptDevice := device
+			keptDevice.Ports = kept
+			remaining[id] = keptDevice
+		}
+	}
+	if len(remaining) == 0 {
+		return nil, fmt.Errorf("request releases every device of session %s, release the session instead", userID)
+	}
+	links := []Link{}
+	tornDown := []Link{}
+	for _, link := range topology.Links {
+		if releasedIDs[link.Src.Device+":"+link.Src.Port] || releasedIDs[link.Dst.Device+":"+link.Dst.Port] {
+			tornDown = append(tornDown, link)
+		} else {
+			links = append(links, link)
+		}
+	}
+
+	// The topology is only updated once every step succeeded; steps already
+	// done are skipped when the request is retried
+	if len(tornDown) != 0 {
+		globalInventory, _, err := inventoryProvider.Inventory(context.Background(), inven.Scope{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get inventory: %w", err)
+		}
+		globalConfig, err := ConvertInventory(globalInventory)
+		if err != nil {
+			return nil, err
+		}
+		for _, link := range tornDown {
+			cfg := switchConfigOf(userID, ProcessInventory(globalConfig, link))
+			if cfg == nil {
+				continue
+			}
+			if err := deleteSwitchLinks(*config.Config.L1SwitchLocation, userID, cfg); err != nil {
+				if saveErr := saveSession(session); saveErr != nil {
+					log.Error().Err(saveErr).Str("UserID", userID).Msg("Failed to save session")
+				}
+				return nil, err
+			}
+		}
+	}
+
+	devices := []inven.ReservedDevice{}
+	for id, device := range released {
+		item := reservedDevices(map[string]BDevice{id: device})[0]
+		item.PortsOnly = !wholeDevices[id]
+		devices = append(devices, item)
+	}
+	changes := []inven.StateChange{}
+	releaseErr := inventoryProvider.Release(userID, devices, &changes)
+	for _, change := range changes {
+		dropReleaseState(userID, change.URL)
+	}
+	if releaseErr != nil {
+		if err := saveSession(session); err != nil {
+			log.Error().Err(err).Str("UserID", userID).Msg("Failed to save session")
+		}
+		return nil, releaseErr
+	}
+
+	resources := claimedResources{}
+	for id, device := range released {
+		if wholeDevices[id] && exclusiveDevice(device.Attrs["role"]) {
+			resources.devices = append(resources.devices, device.Id)
+		}
+		for _, port := range device.Ports {
+			resources.ports = append(resources.ports, port.Id)
+		}
+	}
+	claims.unclaim(userID, resources)
+	// freed resources may let a queued reservation proceed
+	queue.notify()
+
+	response, err := generateTestbed(userID, remaining, links)
+	if err != nil {
+		return nil, err
+	}
+	session.Devices = sessionDevices(remaining)
+	session.Testbed = response
+	if err := setTopology(session, remaining, links); err != nil {
+		return nil, err
+	}
+	if err := saveSession(session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+	log.Info().Str("UserID", userID).Interface("Released", released).Msg("Partially released session")
+	return session, nil
+}
+
+// releasedResources returns the testbed devices released entirely and the
+// testbed ports (in "device:port" format) released by the request
+func releasedResources(topology Testbed, data goopentestbed.Testbed) (map[string]bool, map[string]bool, error) {
+	wholeDevices := map[string]bool{}
+	releasedPorts := map[string]bool{}
+	for _, device := range data.Devices().Items() {
+		current, ok := topology.Devices[device.Id()]
+		if !ok {
+			return nil, nil, fmt.Errorf("device %s is not part of the session", device.Id())
+		}
+		if len(device.Ports().Items()) == 0 {
+			wholeDevices[device.Id()] = true
+			for desc := range current.Ports {
+				releasedPorts[desc] = true
+			}
+			continue
+		}
+		for _, port := range device.Ports().Items() {
+			desc := device.Id() + ":" + port.Id()
+			if _, ok := current.Ports[desc]; !ok {
+				return nil, nil, fmt.Errorf("port %s is not part of the session", desc)
+			}
+			releasedPorts[desc] = true
+		}
+	}
+	for _, link := range data.Links().Items() {
+		src := link.Src().Device() + ":" + link.Src().Port()
+		dst := link.Dst().Device() + ":" + link.Dst().Port()
+		srcPort, srcOk := topology.Devices[link.Src().Device()].Ports[src]
+		dstPort, dstOk := topology.Devices[link.Dst().Device()].Ports[dst]
+		if !srcOk || !dstOk || !topology.linked(srcPort.Id, dstPort.Id) {
+			return nil, nil, fmt.Errorf("link %s - %s is not part of the session", src, dst)
+		}
+		releasedPorts[src] = true
+		releasedPorts[dst] = true
+	}
+	if len(releasedPorts) == 0 && len(wholeDevices) == 0 {
+		return nil, nil, fmt.Errorf("no devices or links to release")
+	}
+	return wholeDevices, releasedPorts, nil
+}
+
+// linked reports whether the testbed links the two inventory ports, given in
+// "device:port" format
+func (t Testbed) linked(a string, b string) bool {
+	for _, link := range t.Links {
+		src := link.Src.Device + ":" + link.Src.Port
+		dst := link.Dst.Device + ":" + link.Dst.Port
+		if (src == a && dst == b) || (src == b && dst == a) {
+			return true
+		}
+	}
+	return false
+}
+
+// switchConfigOf returns the L1 switch config pushed for a session to connect
+// the given switch ports, or nil if there's none
+func switchConfigOf(userID string, deviceMap map[string]L1Swport) gol1s.Config {
+	// setupSwitchLinks only connects ports of a single switch
+	if len(deviceMap) != 1 {
+		return nil
+	}
+	for _, ports := range deviceMap {
+		for _, cfg := range sessionL1sConfigs(userID) {
+			items := cfg.Links().Items()
+			if len(items) == 1 && items[0].Src() == ports.Src && items[0].Dst() == ports.Dst {
+				return cfg
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	fileinv "keysight/laas/controller/internal/inventory/file"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"keysight/laas/controller/internal/store"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useFileInventory backs the controller with a file inventory and a memory
+// session store for the duration of the test
+func useFileInventory(t *testing.T, content string) *fileinv.Provider {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inventory.yaml")
+	if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := fileinv.New(path, inven.Scope{})
+	if err != nil {
+		t.Fatalf("failed to load inventory: %v", err)
+	}
+	previousProvider, previousStore := inventoryProvider, sessionStore
+	inventoryProvider, sessionStore = provider, store.NewMemoryStore()
+	t.Cleanup(func() {
+		inventoryProvider, sessionStore = previousProvider, previousStore
+	})
+	return provider
+}
+
+// reserveTestbed reserves the testbed given in JSON format and returns the
+// session ID
+func reserveTestbed(t *testing.T, request string) string {
+	t.Helper()
+	result, err := Reserve(context.Background(), newTestbed(t, request), ReserveOptions{})
+	if err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+	userID := result.YieldResponse().Sessionid()
+	t.Cleanup(func() { claims.release(userID) })
+	return userID
+}
+
+// staleInventory serves the inventory as it was when taken, as a snapshot
+// which missed the latest changes would
+type staleInventory struct {
+	*fileinv.Provider
+	global    inven.Dut
+	available inven.Dut
+}
+
+func (s *staleInventory) Inventory(ctx context.Context, scope inven.Scope) (inven.Dut, inven.Dut, error) {
+	return s.global, s.available, nil
+}
+
+const modifyInventory = `
+devices:
+  dut1:
+    vendor: Cisco
+    role: DUT
+    ports:
+      - name: eth1
+  dut2:
+    vendor: Arista
+    role: DUT
+    ports:
+      - name: eth1
+  dut3:
+    vendor: Arista
+    role: DUT
+    ports:
+      - name: eth1
+`
+
+func TestModifySessionRecheck(t *testing.T) {
+	provider := useFileInventory(t, modifyInventory)
+	userID := reserveTestbed(t, `{"devices": [{"id": "d1", "role": "DUT", "vendor": "cisco", "ports": [{"id": "p1"}]}]}`)
+
+	// another controller reserves dut2 after the inventory was last fetched
+	global, available, err := provider.Inventory(context.Background(), inven.Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventoryProvider = &staleInventory{Provider: provider, global: global, available: available}
+	other := []inven.ReservedDevice{{Name: "dut2", Role: "DUT"}}
+	if err := provider.Reserve(context.Background(), "other", nil, map[string][]map[string]interface{}{}, other, &[]inven.StateChange{}); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := ModifySession(context.Background(), userID, newTestbed(t, `{"devices": [{"id": "d2", "role": "DUT", "vendor": "arista", "ports": [{"id": "p1"}]}]}`))
+	if err != nil {
+		t.Fatalf("ModifySession() = %v", err)
+	}
+	names := map[string]bool{}
+	for _, device := range session.Devices {
+		names[device.Name] = true
+	}
+	if len(names) != 2 || !names["dut1"] || !names["dut3"] {
+		t.Errorf("session devices = %v, want dut1 and dut3", session.Devices)
+	}
+	if err := provider.Recheck(context.Background(), []inven.ReservedDevice{{Name: "dut3", Role: "DUT"}}); err == nil {
+		t.Error("dut3 not reserved in the inventory")
+	}
+}
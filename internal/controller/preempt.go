@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/store"
+	"sort"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+	graph "github.com/openconfig/ondatra/binding/portgraph"
+)
+
+// markFree marks the given devices and ports of the inventory graph as not
+// reserved
+func markFree(inventory *ConcreteInventory, resources claimedResources) {
+	devices := map[string]bool{}
+	for _, device := range resources.devices {
+		devices[device] = true
+	}
+	ports := map[string]bool{}
+	for _, port := range resources.ports {
+		ports[port] = true
+	}
+	for _, node := range inventory.Graph.Nodes {
+		if devices[node.Desc] {
+			node.Attrs["reserved"] = "no"
+		}
+		for _, port := range node.Ports {
+			if ports[port.Desc] {
+				port.Attrs["reserved"] = "no"
+			}
+		}
+	}
+}
+
+// preemptFor releases the fewest lowest priority sessions needed for the
+// testbed to fit the inventory; sessions are released through the regular
+// release path and kept as preempted so that their owners can tell why. The
+// resources picked for the testbed are claimed for opts.userID and returned,
+// so that the reservation retried next finds them still free
+func preemptFor(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (claimedResources, error) {
+	defer profile.LogFuncDuration(time.Now(), "preemptFor", "", "controller")
+
+	sessions, err := sessionStore.List()
+	if err != nil {
+		return claimedResources{}, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	// lowest priority first and, among equals, the most recently created
+	candidates := []*store.Session{}
+	for _, session := range sessions {
+		if session.Active() && session.Priority < opts.Priority {
+			candidates = append(candidates, session)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority < candidates[j].Priority
+		}
+		return candidates[i].CreatedAt.After(candidates[j].CreatedAt)
+	})
+	if len(candidates) == 0 {
+		return claimedResources{}, fmt.Errorf("found inventory mismatch: %w and none of lower priority than %d", ErrResourcesUnavailable, opts.Priority)
+	}
+
+	testbedConfig := ConvertData(data)
+	testbed := graph.AbstractGraph{}
+	LoadAbstractGraph(testbedConfig, &testbed)
+
+	globalInventory, _, err := inventoryProvider.Inventory(ctx, opts.Scope)
+	if err != nil {
+		return claimedResources{}, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	globalConfig, err := ConvertInventory(globalInventory)
+	if err != nil {
+		return claimedResources{}, err
+	}
+	from, until := opts.window()
+	booked, err := bookedResources("", from, until)
+	if err != nil {
+		return claimedResources{}, err
+	}
+
+	// free up candidates one by one until the testbed fits
+	freed := claimedResources{}
+	for i, candidate := range candidates {
+		freed.add(sessionResources(candidate))
+
+		inventory := LoadConcreteGraph(globalConfig)
+		claims.exclude(inventory)
+		markFree(inventory, freed)
+		markReserved(inventory, booked)
+		assignment, err := graph.Solve(ctx, &testbed, &inventory.Graph)
+		if err != nil {
+			if ctx.Err() != nil {
+				return claimedResources{}, ctx.Err()
+			}
+			continue
+		}
+
+		// only the sessions holding resources actually picked are preempted
+		needed := resourcesOf(assignedDevices(&testbed, assignment))
+		victims := []*store.Session{}
+		for _, c := range candidates[:i+1] {
+			if sessionResources(c).conflict(needed) != nil {
+				victims = append(victims, c)
+			}
+		}
+		reason := fmt.Sprintf("preempted at %s by a reservation of priority %d", time.Now().Format(time.RFC3339), opts.Priority)
+		held := claimedResources{}
+		for _, victim := range victims {
+			moved, err := preemptSession(victim.ID, reason, opts.userID, needed)
+			held.add(moved)
+			if err != nil {
+				return held, err
+			}
+		}
+		// the picked resources no victim held are claimed too, if still free
+		if err := claims.claim(opts.userID, needed); err != nil {
+			log.Warn().Err(err).Str("UserID", opts.userID).Msg("Failed to claim resources freed by preemption")
+			return held, nil
+		}
+		return needed, nil
+	}
+	return claimedResources{}, fmt.Errorf("found inventory mismatch: %w, preempting sessions of lower priority than %d would not help", ErrResourcesUnavailable, opts.Priority)
+}
+
+// preemptSession releases a session and keeps its record, marked as
+// preempted for the given reason; the claims it holds on the needed
+// resources are handed over to holder before the release, and returned
+func preemptSession(userID string, reason string, holder string, needed claimedResources) (claimedResources, error) {
+	unlock := sessionLocks.lock(userID)
+	defer unlock()
+
+	// the session may have been released in the meantime
+	session, err := sessionStore.Get(userID)
+	if err != nil || !session.Active() {
+		return claimedResources{}, nil
+	}
+	log.Warn().Str("UserID", userID).Str("Reason", reason).Msg("Preempting session")
+	moved := claims.transfer(userID, holder, needed)
+	if _, err := releaseSession(userID); err != nil {
+		claims.transfer(holder, userID, moved)
+		return claimedResources{}, fmt.Errorf("failed to preempt session %s: %w", userID, err)
+	}
+
+	session.Status = store.StatusPreempted
+	session.Reason = reason
+	session.NetboxObjects = nil
+	session.L1sConfigs = nil
+	session.Testbed = ""
+	session.Topology = ""
+	if err := sessionStore.Save(session); err != nil {
+		log.Error().Err(err).Str("UserID", userID).Msg("Failed to save preempted session")
+	}
+	return moved, nil
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// ErrResourcesUnavailable is returned when the testbed matches the inventory,
+// but the matching resources are held by other sessions
+var ErrResourcesUnavailable = errors.New("matching resources are reserved by other sessions")
+
+// queuePollInterval bounds how long the head of the queue waits before trying
+// again, in case resources got freed outside of this controller
+const queuePollInterval = 30 * time.Second
+
+// QueueEntry is a reservation waiting for resources to be released
+type QueueEntry struct {
+	ID         string
+	Position   int
+	EnqueuedAt time.Time
+	Deadline   time.Time
+}
+
+// waitQueue holds reservations waiting for resources in FIFO order; only the
+// head of the queue attempts to reserve
+type waitQueue struct {
+	mu      sync.Mutex
+	entries []*QueueEntry
+	// changed is closed (and replaced) whenever the head of the queue may be
+	// able to proceed
+	changed chan struct{}
+}
+
+var queue = &waitQueue{changed: make(chan struct{})}
+
+func (q *waitQueue) push(entry *QueueEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = append(q.entries, entry)
+}
+
+func (q *waitQueue) remove(entry *QueueEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.entries {
+		if e == entry {
+			q.entries = append(q.entries[:i:i], q.entries[i+1:]...)
+			break
+		}
+	}
+	q.notifyLocked()
+}
+
+// notify wakes up the head of the queue, e.g. when resources get released
+func (q *waitQueue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.notifyLocked()
+}
+
+func (q *waitQueue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// head reports whether entry is at the head of the queue, along with the
+// channel closed on the next change
+func (q *waitQueue) head(entry *QueueEntry) (bool, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries) != 0 && q.entries[0] == entry, q.changed
+}
+
+// position returns the 1-based position of the entry with given ID, or 0 if
+// it's not queued
+func (q *waitQueue) position(id string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.entries {
+		if e.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Queue returns the reservations waiting for resources in FIFO order
+func Queue() []QueueEntry {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	result := make([]QueueEntry, 0, len(queue.entries))
+	for i, e := range queue.entries {
+		entry := *e
+		entry.Position = i + 1
+		result = append(result, entry)
+	}
+	return result
+}
+
+// QueuePosition returns the 1-based queue position of a reservation, or 0 if
+// it's not waiting
+func QueuePosition(id string) int {
+	return queue.position(id)
+}
+
+// reserveQueued reserves the testbed, waiting in the queue up to opts.Wait
+// for resources held by other sessions to be released
+func reserveQueued(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (goopentestbed.ReserveResponse, error) {
+	if opts.queueID == "" {
+		id, err := generateUserID()
+		if err != nil {
+			return goopentestbed.NewReserveResponse(), fmt.Errorf("error generating queue ID: %w", err)
+		}
+		opts.queueID = "queue-" + id
+	}
+	now := time.Now()
+	entry := &QueueEntry{ID: opts.queueID, EnqueuedAt: now, Deadline: now.Add(opts.Wait)}
+	queue.push(entry)
+	defer queue.remove(entry)
+
+	// the deadline bounds the wait only, not a reservation in progress
+	waitCtx, cancel := context.WithDeadline(ctx, entry.Deadline)
+	defer cancel()
+
+	log.Info().Str("QueueID", entry.ID).Time("Deadline", entry.Deadline).Msg("Reserve request queued")
+	opts.report(entry.ID, StageQueued)
+	for {
+		isHead, changed := queue.head(entry)
+		var poll <-chan time.Time
+		if isHead {
+			result, err := reserveOrPreempt(ctx, data, opts)
+			if !errors.Is(err, ErrResourcesUnavailable) {
+				return result, err
+			}
+			log.Info().Str("QueueID", entry.ID).Msg("Resources unavailable, waiting for release")
+			poll = time.After(queuePollInterval)
+		}
+		select {
+		case <-changed:
+		case <-poll:
+		case <-waitCtx.Done():
+			return goopentestbed.NewReserveResponse(), fmt.Errorf("gave up waiting for resources: %w", waitCtx.Err())
+		}
+	}
+}
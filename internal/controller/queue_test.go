@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// isClosed reports whether the channel got closed
+func isClosed(changed <-chan struct{}) bool {
+	select {
+	case <-changed:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestWaitQueueOrder(t *testing.T) {
+	q := &waitQueue{changed: make(chan struct{})}
+	entries := map[string]*QueueEntry{}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		entries[id] = &QueueEntry{ID: id}
+		q.push(entries[id])
+	}
+
+	tests := []struct {
+		name      string
+		remove    string
+		head      string
+		positions map[string]int
+	}{
+		{
+			name:      "entries in push order",
+			head:      "a",
+			positions: map[string]int{"a": 1, "b": 2, "c": 3, "d": 4},
+		},
+		{
+			name:      "entry behind the head leaves",
+			remove:    "c",
+			head:      "a",
+			positions: map[string]int{"a": 1, "b": 2, "c": 0, "d": 3},
+		},
+		{
+			name:      "head leaves",
+			remove:    "a",
+			head:      "b",
+			positions: map[string]int{"a": 0, "b": 1, "c": 0, "d": 2},
+		},
+		{
+			name:      "last entries leave",
+			remove:    "d",
+			head:      "b",
+			positions: map[string]int{"a": 0, "b": 1, "c": 0, "d": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, changed := q.head(entries[tt.head])
+			if tt.remove != "" {
+				q.remove(entries[tt.remove])
+				if !isClosed(changed) {
+					t.Errorf("remove(%s) did not wake up the queue", tt.remove)
+				}
+			}
+			for id, entry := range entries {
+				isHead, _ := q.head(entry)
+				if isHead != (id == tt.head) {
+					t.Errorf("head(%s) = %v, want %v", id, isHead, id == tt.head)
+				}
+			}
+			positions := map[string]int{}
+			for id := range entries {
+				positions[id] = q.position(id)
+			}
+			if !reflect.DeepEqual(positions, tt.positions) {
+				t.Errorf("positions = %v, want %v", positions, tt.positions)
+			}
+		})
+	}
+}
+
+func TestWaitQueueNotify(t *testing.T) {
+	q := &waitQueue{changed: make(chan struct{})}
+	entry := &QueueEntry{ID: "a"}
+	q.push(entry)
+	_, changed := q.head(entry)
+	if isClosed(changed) {
+		t.Fatal("queue woken up before any change")
+	}
+	q.notify()
+	if !isClosed(changed) {
+		t.Error("notify() did not wake up the queue")
+	}
+	if _, next := q.head(entry); isClosed(next) {
+		t.Error("notify() closed the channel of the next change")
+	}
+}
+
+func TestQueuePositions(t *testing.T) {
+	now := time.Now()
+	entries := []*QueueEntry{
+		{ID: "queue-a", EnqueuedAt: now, Deadline: now.Add(time.Minute)},
+		{ID: "queue-b", EnqueuedAt: now.Add(time.Second), Deadline: now.Add(time.Minute)},
+	}
+	for _, entry := range entries {
+		queue.push(entry)
+		defer queue.remove(entry)
+	}
+	got := Queue()
+	if len(got) != len(entries) {
+		t.Fatalf("Queue() returned %d entries, want %d", len(got), len(entries))
+	}
+	for i, entry := range got {
+		if entry.ID != entries[i].ID || entry.Position != i+1 {
+			t.Errorf("Queue()[%d] = %s at %d, want %s at %d", i, entry.ID, entry.Position, entries[i].ID, i+1)
+		}
+		if QueuePosition(entry.ID) != i+1 {
+			t.Errorf("QueuePosition(%s) = %d, want %d", entry.ID, QueuePosition(entry.ID), i+1)
+		}
+	}
+}
+
+func TestWaitQueueConcurrentWaiters(t *testing.T) {
+	q := &waitQueue{changed: make(chan struct{})}
+	const waiters = 8
+	entries := make([]*QueueEntry, waiters)
+	for i := range entries {
+		entries[i] = &QueueEntry{ID: string(rune('a' + i))}
+		q.push(entries[i])
+	}
+
+	// each waiter proceeds once it's at the head, then leaves the queue
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		order []string
+	)
+	for _, entry := range entries {
+		wg.Add(1)
+		go func(entry *QueueEntry) {
+			defer wg.Done()
+			for {
+				isHead, changed := q.head(entry)
+				if isHead {
+					mu.Lock()
+					order = append(order, entry.ID)
+					mu.Unlock()
+					q.remove(entry)
+					return
+				}
+				<-changed
+			}
+		}(entry)
+	}
+	wg.Wait()
+	want := []string{}
+	for _, entry := range entries {
+		want = append(want, entry.ID)
+	}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("waiters proceeded in order %v, want %v", order, want)
+	}
+}
@@ -3,45 +3,175 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"keysight/laas/controller/config"
 	"keysight/laas/controller/internal/framework/cafy"
 	"keysight/laas/controller/internal/framework/ondatra"
 	inven "keysight/laas/controller/internal/inventory/netbox"
 	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/store"
 	"keysight/laas/controller/internal/utils"
-	"os"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/open-traffic-generator/openl1s/gol1s"
 	"github.com/open-traffic-generator/opentestbed/goopentestbed"
 	graph "github.com/openconfig/ondatra/binding/portgraph"
 )
 
 var log = config.GetLogger("controller")
 
-var (
-	releaseState     = make(map[string][]map[string]interface{})
-	globalL1sConfigs []gol1s.Config
-	userConfigs      = make(map[string][]gol1s.Config)
-	apiMutex         sync.Mutex
+// ReserveOptions holds optional reservation parameters
+type ReserveOptions struct {
+	// Lease is the duration after which the session is released
+	// automatically, unless renewed; zero means no expiry
+	Lease time.Duration
+	// Wait is how long the reservation may wait in the queue for resources
+	// held by other sessions to be released; zero means fail right away
+	Wait time.Duration
+	// Priority of the session; sessions of lower priority may be preempted
+	Priority int
+	// Preempt allows releasing lower priority sessions holding resources the
+	// reservation needs
+	Preempt bool
+	// Metadata describes who reserves the testbed and what for; it's stored
+	// with the session and written to the inventory
+	Metadata store.Metadata
+	// IdempotencyKey, if set, identifies the request across retries; a
+	// session reserved for the same key and testbed is returned as is
+	IdempotencyKey string
+	// Scope limits the inventory the testbed is reserved from, within the
+	// configured scope
+	Scope inven.Scope
+	// Progress, if set, is called as the reservation reaches each stage
+	Progress func(stage ReserveStage)
+	// requestHash identifies the testbed reserved with IdempotencyKey
+	requestHash string
+	// queueID identifies the request while it waits in the queue
+	queueID string
+	// booking is the scheduled session being activated, if any
+	booking *store.Session
+	// userID, if set, is the ID the session is reserved under
+	userID string
+	// held lists resources claimed for userID ahead of the reservation, e.g.
+	// those freed by preemption; the ones left unused are let go once the
+	// testbed is claimed
+	held claimedResources
+}
+
+// window returns the time range over which the reservation holds resources
+func (opts ReserveOptions) window() (time.Time, *time.Time) {
+	if opts.booking != nil {
+		return opts.booking.Start(), opts.booking.ExpiresAt
+	}
+	now := time.Now()
+	if opts.Lease > 0 {
+		until := now.Add(opts.Lease)
+		return now, &until
+	}
+	return now, nil
+}
+
+// ReserveStage is a progress point of a reservation
+type ReserveStage string
+
+const (
+	StageQueued             ReserveStage = "queued"
+	StageInventoryFetched   ReserveStage = "inventory_fetched"
+	StageSolved             ReserveStage = "solved"
+	StageSwitchesConfigured ReserveStage = "switches_configured"
+	StageInventoryUpdated   ReserveStage = "inventory_updated"
 )
 
-func Reserve(data goopentestbed.Testbed) (goopentestbed.ReserveResponse, error) {
+func (opts ReserveOptions) report(userID string, stage ReserveStage) {
+	log.Info().Str("UserID", userID).Str("Stage", string(stage)).Msg("Reserve progress")
+	if opts.Progress != nil {
+		opts.Progress(stage)
+	}
+}
+
+// maxClaimAttempts bounds how many times the solver is re-run when resources
+// it picked got claimed by a concurrent reservation in the meantime
+const maxClaimAttempts = 3
+
+// Reserve reserves the testbed; cancelling ctx aborts the reservation and
+// rolls back whatever was done so far
+func Reserve(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (goopentestbed.ReserveResponse, error) {
+	if opts.IdempotencyKey != "" {
+		return reserveIdempotent(ctx, data, opts)
+	}
+	return reserveWithOptions(ctx, data, opts)
+}
+
+// reserveWithOptions reserves the testbed, waiting in the queue if allowed
+func reserveWithOptions(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (goopentestbed.ReserveResponse, error) {
+	if opts.Wait > 0 {
+		return reserveQueued(ctx, data, opts)
+	}
+	return reserveOrPreempt(ctx, data, opts)
+}
+
+// reserveOrPreempt reserves the testbed, preempting lower priority sessions
+// if allowed and needed
+func reserveOrPreempt(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (goopentestbed.ReserveResponse, error) {
+	result, err := reserve(ctx, data, opts)
+	if !opts.Preempt || !errors.Is(err, ErrResourcesUnavailable) {
+		return result, err
+	}
+	// the resources freed by preemption are claimed for the session right
+	// away, so that queued reservations can't take them in the meantime
+	id, err := generateUserID()
+	if err != nil {
+		return goopentestbed.NewReserveResponse(), fmt.Errorf("error generating user ID: %w", err)
+	}
+	opts.userID = id
+	held, err := preemptFor(ctx, data, opts)
+	if err != nil {
+		claims.unclaim(opts.userID, held)
+		return goopentestbed.NewReserveResponse(), err
+	}
+	opts.held = held
+	result, err = reserve(ctx, data, opts)
+	if err != nil {
+		claims.unclaim(opts.userID, held)
+	}
+	return result, err
+}
+
+// reserve reserves the testbed; when resources it claimed turn out to be
+// reserved by another controller, or someone else, while being marked in the
+// inventory, the reservation is rolled back and solved again, the refreshed
+// inventory leaving them out
+func reserve(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (goopentestbed.ReserveResponse, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := reserveOnce(ctx, data, opts)
+		reserveErr := &ReserveError{}
+		reservedErr := &inven.ReservedError{}
+		if err == nil || opts.booking != nil || attempt == maxClaimAttempts ||
+			!errors.As(err, &reserveErr) || !reserveErr.RolledBack() || !errors.As(err, &reservedErr) {
+			return result, err
+		}
+		log.Warn().Err(err).Int("Attempt", attempt).Msg("Resources reserved concurrently in the inventory, reserving again")
+	}
+}
+
+func reserveOnce(ctx context.Context, data goopentestbed.Testbed, opts ReserveOptions) (goopentestbed.ReserveResponse, error) {
 	defer profile.LogFuncDuration(time.Now(), "Reserve", "", "controller")
-	// Lock the mutex before making the API call
-	apiMutex.Lock()
-	defer apiMutex.Unlock()
-	// Clear the globalL1sConfigs at the start of the function
-	globalL1sConfigs = []gol1s.Config{}
 
-	// Generate a unique userID
-	userID, usrerr := generateUserID()
-	if usrerr != nil {
-		return goopentestbed.NewReserveResponse(), fmt.Errorf("error generating user ID: %w", usrerr)
+	// Generate a unique userID, unless activating a scheduled session
+	userID := opts.userID
+	if opts.booking != nil {
+		userID = opts.booking.ID
+	} else if userID == "" {
+		var usrerr error
+		if userID, usrerr = generateUserID(); usrerr != nil {
+			return goopentestbed.NewReserveResponse(), fmt.Errorf("error generating user ID: %w", usrerr)
+		}
 	}
+	// Hold the session lock so that the session can not be released or
+	// renewed while it is still being reserved
+	unlock := sessionLocks.lock(userID)
+	defer unlock()
 
 	log.Info().Str("UserID", userID).Interface("Request", data).Msg("Reserve request")
 	log.Debug().Str("UserID", userID).Interface("Request-Debug", data).Msg("Reserve request")
@@ -61,20 +191,27 @@ func Reserve(data goopentestbed.Testbed) (goopentestbed.ReserveResponse, error)
 	LoadAbstractGraph(testbedConfig, &testbed)
 
 	// Get inventory
-	inven.GetCreateInvFromNetbox(*config.Config.NetboxApiURL, *config.Config.NetboxUserToken)
+	globalInventory, availableInventory, err := inventoryProvider.Inventory(ctx, opts.Scope)
+	if err != nil {
+		return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to get inventory: %w", err)
+	}
+	writeArtifact(userID, "inventory_global.json", globalInventory)
+	writeArtifact(userID, "inventory.json", availableInventory)
 
-	// This line is for debugging purpose only
-	// testbedData := LoadTestbedData("testbed.json")
 	// Convert Inventory Data type from other types to string
-	ConvertInventoryDataType()
-	// Create Concrete Graph
-	InventoryConfig = LoadInventoryData("inventory.json")
-	InventoryGraph = graph.ConcreteGraph{}
-	ConfigNodesToDevices = map[*graph.ConcreteNode]Device{}
-	ConfigPortsToPorts = map[*graph.ConcretePort]Port{}
-	LoadConcreteGraph()
-
-	inventory := InventoryGraph
+	inventoryConfig, err := ConvertInventory(availableInventory)
+	if err != nil {
+		return goopentestbed.NewReserveResponse(), err
+	}
+	globalConfig, err := ConvertInventory(globalInventory)
+	if err != nil {
+		return goopentestbed.NewReserveResponse(), err
+	}
+	opts.report(userID, StageInventoryFetched)
+	if err := ctx.Err(); err != nil {
+		return goopentestbed.NewReserveResponse(), err
+	}
+
 	// Print &testbed as JSON
 	testbedJSON, err := json.MarshalIndent(&testbed, "", "  ")
 	if err != nil {
@@ -82,176 +219,366 @@ func Reserve(data goopentestbed.Testbed) (goopentestbed.ReserveResponse, error)
 	}
 	log.Info().RawJSON("Testbed", testbedJSON).Msg("Abstract Graph")
 
-	// Print &inventory as JSON
-	inventoryJSON, err := json.MarshalIndent(&inventory, "", "  ")
+	assignment, devices, err := solveAndClaim(ctx, userID, &testbed, inventoryConfig, globalConfig, opts)
 	if err != nil {
-		return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to marshal inventory to JSON: %w", err)
+		return goopentestbed.NewReserveResponse(), err
 	}
-	log.Info().RawJSON("Inventory", inventoryJSON).Msg("Concrete Graph")
-	assignment, err := graph.Solve(context.Background(), &testbed, &inventory)
-	if err != nil {
-		if strings.Contains(err.Error(), "edges") {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("found inventory mismatch: %s", "failed to find nodes/links in inventory, please correct the inventory configuration and try again.")
-		} else {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("found inventory mismatch: %w", err)
+	// Persist the session as soon as resources are claimed, so that every side
+	// effect below can be torn down by releasing it, even after a restart
+	session := newSession(userID, devices, opts)
+	if err := saveSession(session); err != nil {
+		claims.release(userID)
+		return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to save session: %w", err)
+	}
+
+	// Every side effect from here on is recorded, so that a failing step rolls
+	// back the ones taken before it
+	tx := newTransaction(userID)
+	fail := func(err error) (goopentestbed.ReserveResponse, error) {
+		return goopentestbed.NewReserveResponse(), rollbackReserve(tx, session, err)
+	}
+
+	opts.report(userID, StageSolved)
+	if err := ctx.Err(); err != nil {
+		return fail(err)
+	}
+
+	links := []Link{}
+	for _, edge := range testbed.Edges {
+		destLink := concreteLink(assignment.Port2Port[edge.Src].Desc, assignment.Port2Port[edge.Dst].Desc)
+		if err := setupLink(tx, userID, inventoryConfig, destLink); err != nil {
+			return fail(err)
 		}
+		links = append(links, destLink)
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+	}
+	opts.report(userID, StageSwitchesConfigured)
+	// Persist the switch configuration before touching the inventory
+	if err := saveSession(session); err != nil {
+		return fail(fmt.Errorf("failed to save session: %w", err))
+	}
+	if err := reserveInventory(ctx, tx, userID, session.Metadata, reservedDevices(devices)); err != nil {
+		return fail(err)
+	}
+	opts.report(userID, StageInventoryUpdated)
+	if err := ctx.Err(); err != nil {
+		return fail(err)
 	}
+	if err := saveSession(session); err != nil {
+		return fail(fmt.Errorf("failed to save session: %w", err))
+	}
+	response, err := generateTestbed(userID, devices, links)
+	if err != nil {
+		return fail(err)
+	}
+
+	session.Testbed = response
+	if err := setTopology(session, devices, links); err != nil {
+		return fail(err)
+	}
+	if err := saveSession(session); err != nil {
+		return fail(fmt.Errorf("failed to save session: %w", err))
+	}
+
+	log.Info().Str("UserID", userID).Interface("Response", response).Msg("Reserve response")
+	result := goopentestbed.NewReserveResponse()
+	result.YieldResponse().SetSessionid(userID)
+	result.YieldResponse().SetTestbed(response)
+	return result, nil
+}
+
+// solveAndClaim finds an assignment of the testbed onto the inventory and
+// claims the assigned devices and ports for userID; when a concurrent
+// reservation claims any of them first, or they turn out to be reserved in
+// the inventory already, the solver is run again with those resources
+// excluded. ErrResourcesUnavailable is returned if the testbed only
+// fits the global inventory, i.e. its resources are held by other sessions
+func solveAndClaim(ctx context.Context, userID string, testbed *graph.AbstractGraph, inventoryConfig Inventory, globalConfig Inventory, opts ReserveOptions) (*graph.Assignment, map[string]BDevice, error) {
+	from, until := opts.window()
+	// resources found reserved in the inventory upon recheck
+	stale := claimedResources{}
+	for attempt := 1; ; attempt++ {
+		// Create Concrete Graph
+		inventory := LoadConcreteGraph(inventoryConfig)
+		claims.exclude(inventory)
+		markFree(inventory, opts.held)
+		// Resources booked by scheduled sessions are left out as well, unless
+		// the reservation ends before they're needed
+		booked, err := bookedResources(userID, from, until)
+		if err != nil {
+			return nil, nil, err
+		}
+		markReserved(inventory, booked)
+		markReserved(inventory, stale)
+		if opts.booking != nil {
+			restrictTo(inventory, sessionResources(opts.booking))
+		}
+
+		// Print &inventory as JSON
+		inventoryJSON, err := json.MarshalIndent(&inventory.Graph, "", "  ")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal inventory to JSON: %w", err)
+		}
+		log.Info().RawJSON("Inventory", inventoryJSON).Msg("Concrete Graph")
+		assignment, err := graph.Solve(ctx, testbed, &inventory.Graph)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			if satisfiable(ctx, testbed, globalConfig) {
+				return nil, nil, fmt.Errorf("found inventory mismatch: %w", ErrResourcesUnavailable)
+			}
+			if strings.Contains(err.Error(), "edges") {
+				return nil, nil, fmt.Errorf("found inventory mismatch: %s", "failed to find nodes/links in inventory, please correct the inventory configuration and try again.")
+			} else {
+				return nil, nil, fmt.Errorf("found inventory mismatch: %w", err)
+			}
+		}
+
+		// Ensure map initialization
+		if assignment.Port2Port == nil {
+			assignment.Port2Port = make(map[*graph.AbstractPort]*graph.ConcretePort)
+		}
 
-	// Ensure map initialization
-	if assignment.Port2Port == nil {
-		assignment.Port2Port = make(map[*graph.AbstractPort]*graph.ConcretePort)
+		devices := assignedDevices(testbed, assignment)
+		err = claimUnbooked(userID, resourcesOf(devices), from, until)
+		if err == nil {
+			// The inventory may be a little stale, so the state of the picked
+			// resources is checked once more now that they're claimed
+			err = inventoryProvider.Recheck(ctx, reservedDevices(devices))
+			if err == nil {
+				claims.unclaim(userID, opts.held.without(resourcesOf(devices)))
+				return assignment, devices, nil
+			}
+			claims.unclaim(userID, resourcesOf(devices))
+			reservedErr := &inven.ReservedError{}
+			if !errors.As(err, &reservedErr) {
+				return nil, nil, err
+			}
+			stale.add(claimedResources{devices: reservedErr.Devices, ports: reservedErr.Ports})
+		}
+		if attempt == maxClaimAttempts {
+			return nil, nil, fmt.Errorf("failed to claim resources: %w: %w", ErrResourcesUnavailable, err)
+		}
+		log.Warn().Err(err).Str("UserID", userID).Int("Attempt", attempt).Msg("Resources claimed concurrently, solving again")
 	}
+}
 
+// assignedDevices returns the inventory devices and ports assigned to the
+// testbed, keyed by testbed device
+func assignedDevices(testbed *graph.AbstractGraph, assignment *graph.Assignment) map[string]BDevice {
 	devices := map[string]BDevice{}
 	for _, node := range testbed.Nodes {
 		ports := map[string]Port{}
 		for _, port := range node.Ports {
-			ConfigPortsToPorts[assignment.Port2Port[port]].Attrs["reserved"] = "yes"
 			newPort := Port{Id: assignment.Port2Port[port].Desc, Attrs: assignment.Port2Port[port].Attrs}
 			ports[port.Desc] = newPort
 		}
-		ConfigNodesToDevices[assignment.Node2Node[node]].Attrs["reserved"] = "yes"
 		newNode := BDevice{Id: assignment.Node2Node[node].Desc, Attrs: assignment.Node2Node[node].Attrs, Ports: ports}
 		devices[node.Desc] = newNode
 	}
-	links := []Link{}
-	for _, edge := range testbed.Edges {
-		srcDevice, srcPort := utils.SplitString(assignment.Port2Port[edge.Src].Desc)
-		dstDevice, dstPort := utils.SplitString(assignment.Port2Port[edge.Dst].Desc)
-		srcEndpoint := InputLinkEndpoint{
-			Device: srcDevice,
-			Port:   srcPort,
-		}
-		dstEndpoint := InputLinkEndpoint{
-			Device: dstDevice,
-			Port:   dstPort,
-		}
-		destLink := Link{
-			Src: srcEndpoint,
-			Dst: dstEndpoint,
-		}
+	return devices
+}
 
-		deviceMap, err := ProcessInventory("inventory.json", destLink)
-		if err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("%w", err)
-		}
+// satisfiable reports whether the testbed would fit the inventory if none of
+// its devices and ports were reserved
+func satisfiable(ctx context.Context, testbed *graph.AbstractGraph, inventoryConfig Inventory) bool {
+	inventory := loadFreeConcreteGraph(inventoryConfig)
+	_, err := graph.Solve(ctx, testbed, &inventory.Graph)
+	return err == nil
+}
 
-		if len(deviceMap) != 0 {
-			err = setupSwitchLinks(deviceMap, *config.Config.L1SwitchLocation, userID)
-			if err != nil {
-				return goopentestbed.NewReserveResponse(), fmt.Errorf("%w", err)
-			}
+// loadFreeConcreteGraph creates the concrete graph of the inventory with none
+// of its devices and ports marked as reserved
+func loadFreeConcreteGraph(inventoryConfig Inventory) *ConcreteInventory {
+	inventory := LoadConcreteGraph(inventoryConfig)
+	for _, node := range inventory.Graph.Nodes {
+		node.Attrs["reserved"] = "no"
+		for _, port := range node.Ports {
+			port.Attrs["reserved"] = "no"
 		}
-		links = append(links, destLink)
 	}
-	content, err := json.Marshal(Testbed{Devices: devices, Links: links})
-	if err != nil {
-		return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to marshal data: %w", err)
+	return inventory
+}
+
+// concreteLink returns the link between two inventory ports, given in
+// "device:port" format
+func concreteLink(src string, dst string) Link {
+	srcDevice, srcPort := utils.SplitString(src)
+	dstDevice, dstPort := utils.SplitString(dst)
+	return Link{
+		Src: InputLinkEndpoint{Device: srcDevice, Port: srcPort},
+		Dst: InputLinkEndpoint{Device: dstDevice, Port: dstPort},
 	}
+}
 
-	err = os.WriteFile("output.json", content, 0644)
+// setupLink configures the L1 switch ports, if any, the link goes through
+func setupLink(tx *transaction, userID string, inventoryConfig Inventory, link Link) error {
+	deviceMap := ProcessInventory(inventoryConfig, link)
+	if len(deviceMap) == 0 {
+		return nil
+	}
+	l1sConfig, err := setupSwitchLinks(deviceMap, *config.Config.L1SwitchLocation, userID)
 	if err != nil {
-		return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to write data into file: %w", err)
+		return err
+	}
+	if l1sConfig != nil {
+		tx.record("delete L1S links", func() error {
+			return deleteSwitchLinks(*config.Config.L1SwitchLocation, userID, l1sConfig)
+		})
+	}
+	return nil
+}
+
+// inventoryMetadata returns the session metadata keyed by NetBox custom field
+// name, which the file inventory uses as well
+func inventoryMetadata(metadata store.Metadata) map[string]string {
+	return map[string]string{
+		"owner":      metadata.Owner,
+		"team":       metadata.Team,
+		"purpose":    metadata.Purpose,
+		"ci_job_url": metadata.CIJobURL,
+	}
+}
+
+// reserveInventory marks the devices and ports as reserved by userID in the
+// inventory
+func reserveInventory(ctx context.Context, tx *transaction, userID string, metadata store.Metadata, devices []inven.ReservedDevice) error {
+	updateState := map[string][]map[string]interface{}{}
+	changes := []inven.StateChange{}
+	updateerr := inventoryProvider.Reserve(ctx, userID, inventoryMetadata(metadata), updateState, devices, &changes)
+	addReleaseState(userID, updateState[userID])
+	for _, change := range changes {
+		change := change
+		tx.record("restore inventory object "+change.URL, func() error {
+			if err := inventoryProvider.Restore(change); err != nil {
+				return err
+			}
+			dropReleaseState(userID, change.URL)
+			return nil
+		})
 	}
-	msg, updateerr := inven.UpdateInventory(*config.Config.NetboxApiURL, *config.Config.NetboxUserToken, userID, releaseState)
 	if updateerr != nil {
-		// log.Fatal().Msgf("updateDevicesData failed: %v", updateerr)
-		return goopentestbed.NewReserveResponse(), fmt.Errorf("updateDevicesData failed: %v", updateerr)
+		reservedErr := &inven.ReservedError{}
+		if errors.As(updateerr, &reservedErr) {
+			return fmt.Errorf("failed to update inventory: %w: %w", ErrResourcesUnavailable, reservedErr)
+		}
+		return fmt.Errorf("failed to update inventory: %v", updateerr)
 	}
-	log.Info().Interface("UpdateInventory", msg).Msg("Update Inventory")
+	log.Info().Str("UserID", userID).Msg("Node/Interfaces details updated successfully as per testbed details.")
+	return nil
+}
+
+// generateTestbed returns the testbed of the reserved devices and links in
+// the configured framework format
+func generateTestbed(userID string, devices map[string]BDevice, links []Link) (string, error) {
+	content, err := json.MarshalIndent(Testbed{Devices: devices, Links: links}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal data: %w", err)
+	}
+	writeArtifact(userID, "output.json", content)
+
 	var response string
 	frameworkName := strings.ToLower(*config.Config.FrameworkName)
 	switch frameworkName {
 	case "cafy":
-		// cafy.CafyMain()
-		response, err = cafy.CafyMain()
+		response, err = cafy.CafyMain(content)
 		if err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("error in CafyMain function: %w", err)
+			return "", fmt.Errorf("error in CafyMain function: %w", err)
 		}
+		writeArtifact(userID, "cafy_testbed.json", []byte(response))
 	case "ondatra":
-		// ondatra.OndatraMain()
-		// bindingContent, err := ondatra.OndatraMain()
-		// if err != nil {
-		// 	return fmt.Errorf("error in OndatraMain: %w", err)
-		// }
-		// // Return JSON data
-		// c.IndentedJSON(http.StatusCreated, bindingContent)
-		// return nil
-		response, err = ondatra.OndatraMain()
+		response, err = ondatra.OndatraMain(content)
 		if err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("error in Ondatra function: %w", err)
+			return "", fmt.Errorf("error in Ondatra function: %w", err)
 		}
+		writeArtifact(userID, "ondatra_binding.txt", []byte(response))
 	default: //generic
-		fileContent, err := os.ReadFile("output.json")
-		if err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to read output file: %w", err)
-		}
-		var outputData Testbed
-		if err := json.Unmarshal(fileContent, &outputData); err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to unmarshal output data: %w", err)
-		}
-		resultJSON, err := json.MarshalIndent(outputData, "", "  ")
-		if err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to convert data to JSON file: %w", err)
-		}
-		outputFilePath := "output.json"
-
-		// Write the result JSON to the output file
-		err = os.WriteFile(outputFilePath, resultJSON, 0644)
-		if err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to write data to output file: %w", err)
-		}
 		log.Info().Msg("Successfully generated generic testbed file")
-		fileContent, err = os.ReadFile(outputFilePath)
-		if err != nil {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("failed to read output file: %w", err)
+		response = string(content)
+	}
+	return response, nil
+}
+
+// rollbackReserve undoes the side effects of a failed reservation; the session
+// is dropped once all of them are undone, otherwise it's kept so that the
+// remaining resources can be freed by releasing it
+func rollbackReserve(tx *transaction, session *store.Session, err error) error {
+	log.Error().Err(err).Str("UserID", session.ID).Msg("Reserve failed, rolling back")
+	rollbackErr := tx.rollback()
+	if rollbackErr == nil {
+		if delErr := deleteSession(session.ID); delErr != nil {
+			rollbackErr = fmt.Errorf("failed to delete session: %w", delErr)
 		}
+	} else if saveErr := saveSession(session); saveErr != nil {
+		log.Error().Err(saveErr).Str("UserID", session.ID).Msg("Failed to save session")
+	}
+	return &ReserveError{Err: err, RollbackErr: rollbackErr}
+}
 
-		response = string(fileContent)
-		// c.Data(http.StatusOK, "application/json; charset=utf-8", fileContent)
+// reservedDevices lists the devices picked for a session along with the names
+// of the ports picked on them, as recorded in the inventory
+func reservedDevices(devices map[string]BDevice) []inven.ReservedDevice {
+	reserved := make([]inven.ReservedDevice, 0, len(devices))
+	for _, device := range devices {
+		ports := make([]string, 0, len(device.Ports))
+		for _, port := range device.Ports {
+			if name, ok := port.Attrs["name"]; ok {
+				ports = append(ports, name)
+			}
+		}
+		reserved = append(reserved, inven.ReservedDevice{
+			Name:  device.Id,
+			Role:  device.Attrs["role"],
+			Ports: ports,
+		})
 	}
+	return reserved
+}
 
-	log.Info().Str("UserID", userID).Interface("Response", response).Msg("Reserve response")
-	result := goopentestbed.NewReserveResponse()
-	result.YieldResponse().SetSessionid(userID)
-	result.YieldResponse().SetTestbed(response)
+func Release(userId goopentestbed.Session) (goopentestbed.ReleaseResponse, error) {
+	unlock := sessionLocks.lock(userId.Id())
+	defer unlock()
+	msg, err := releaseSession(userId.Id())
+	if err != nil {
+		return goopentestbed.NewReleaseResponse(), err
+	}
+	result := goopentestbed.NewReleaseResponse()
+	result.Warning().SetWarnings([]string{msg})
 	return result, nil
 }
 
-func Release(userId goopentestbed.Session) (goopentestbed.ReleaseResponse, error) {
-	// Lock the mutex before making the API call
-	apiMutex.Lock()
-	defer apiMutex.Unlock()
-	if len(releaseState) != 0 && userPresentInReleaseState(userId.Id(), releaseState) {
-		configErr := removeSwitchLinks(*config.Config.L1SwitchLocation, userId.Id())
-		if configErr != nil {
-			return goopentestbed.NewReleaseResponse(), fmt.Errorf("%w", configErr)
-		}
-		nodeerr := inven.ReleaseStateWithInvenData(releaseState, userId.Id())
-		if nodeerr != nil {
-			return goopentestbed.NewReleaseResponse(), fmt.Errorf("%v", nodeerr)
-		} else {
-			result := goopentestbed.NewReleaseResponse()
-			result.Warning().SetWarnings([]string{"Node/Interfaces details updated successfully as per testbed details."})
-			return result, nil
+// releaseSession tears down switch links and resets inventory state of a
+// session; the session lock must be held by the caller
+func releaseSession(userID string) (string, error) {
+	state := sessionReleaseState(userID)
+	if state == nil && len(sessionL1sConfigs(userID)) == 0 {
+		if _, err := sessionStore.Get(userID); err != nil {
+			return "", fmt.Errorf("failed to find session %s: %w", userID, err)
 		}
-	} else {
-		configErr := removeSwitchLinks(*config.Config.L1SwitchLocation, userId.Id())
-		if configErr != nil {
-			return goopentestbed.NewReleaseResponse(), fmt.Errorf("%w", configErr)
-		}
-		msg, nodeerr := inven.UpdateInventory(*config.Config.NetboxApiURL, *config.Config.NetboxUserToken, userId.Id(), releaseState)
+	}
+	configErr := removeSwitchLinks(*config.Config.L1SwitchLocation, userID)
+	if configErr != nil {
+		return "", fmt.Errorf("%w", configErr)
+	}
+	if state != nil {
+		nodeerr := inventoryProvider.ReleaseSession(userID, state)
 		if nodeerr != nil {
-			return goopentestbed.NewReleaseResponse(), fmt.Errorf("%v", nodeerr)
-		} else {
-			result := goopentestbed.NewReleaseResponse()
-			result.Warning().SetWarnings([]string{msg})
-			return result, nil
+			return "", fmt.Errorf("%v", nodeerr)
 		}
 	}
-
+	if err := deleteSession(userID); err != nil {
+		log.Error().Err(err).Str("UserID", userID).Msg("Failed to delete session")
+	}
+	return "Node/Interfaces details updated successfully as per testbed details.", nil
 }
 
+// ErrInvalidTestbed is returned for testbeds which can't be reserved as
+// given, e.g. with duplicate device IDs
+var ErrInvalidTestbed = errors.New("invalid testbed")
+
 func CheckForDuplicateIDs(data goopentestbed.Testbed) (goopentestbed.ReserveResponse, error) {
 	idSet := make(map[string]struct{})
 	portIDSet := make(map[string]struct{})
@@ -260,12 +587,12 @@ func CheckForDuplicateIDs(data goopentestbed.Testbed) (goopentestbed.ReserveResp
 		// Check if the node has a "state" attribute (case-insensitive)
 		for _, attr := range node.Attributes().Items() {
 			if strings.EqualFold(strings.ToLower(attr.Key()), "state") {
-				return goopentestbed.NewReserveResponse(), fmt.Errorf("%s attribute not allowed for Device as user input", attr.Key())
+				return goopentestbed.NewReserveResponse(), fmt.Errorf("%w: %s attribute not allowed for Device as user input", ErrInvalidTestbed, attr.Key())
 			}
 		}
 
 		if _, exists := idSet[node.Id()]; exists {
-			return goopentestbed.NewReserveResponse(), fmt.Errorf("duplicate device ID found in Input Data: %s", node.Id())
+			return goopentestbed.NewReserveResponse(), fmt.Errorf("%w: duplicate device ID found in Input Data: %s", ErrInvalidTestbed, node.Id())
 		}
 		idSet[node.Id()] = struct{}{}
 
@@ -274,12 +601,12 @@ func CheckForDuplicateIDs(data goopentestbed.Testbed) (goopentestbed.ReserveResp
 			// Check if the port has a "state" attribute (case-insensitive)
 			for _, attr := range port.Attributes().Items() {
 				if strings.EqualFold(strings.ToLower(attr.Key()), "state") {
-					return goopentestbed.NewReserveResponse(), fmt.Errorf("%s attribute not allowed for Port as user input", attr.Key())
+					return goopentestbed.NewReserveResponse(), fmt.Errorf("%w: %s attribute not allowed for Port as user input", ErrInvalidTestbed, attr.Key())
 				}
 			}
 
 			if _, exists := portIDSet[port.Id()]; exists {
-				return goopentestbed.NewReserveResponse(), fmt.Errorf("duplicate port ID found in Input Data: %s", port.Id())
+				return goopentestbed.NewReserveResponse(), fmt.Errorf("%w: duplicate port ID found in Input Data: %s", ErrInvalidTestbed, port.Id())
 			}
 			portIDSet[port.Id()] = struct{}{}
 		}
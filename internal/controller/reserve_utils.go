@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	inven "keysight/laas/controller/internal/inventory/netbox"
 	"keysight/laas/controller/internal/profile"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -15,10 +17,13 @@ import (
 	graph "github.com/openconfig/ondatra/binding/portgraph"
 )
 
-var InventoryConfig Inventory
-var InventoryGraph graph.ConcreteGraph
-var ConfigNodesToDevices map[*graph.ConcreteNode]Device
-var ConfigPortsToPorts map[*graph.ConcretePort]Port
+// ConcreteInventory is the concrete graph built from an inventory along with
+// the mapping of graph nodes and ports back to inventory devices and ports
+type ConcreteInventory struct {
+	Graph          graph.ConcreteGraph
+	NodesToDevices map[*graph.ConcreteNode]Device
+	PortsToPorts   map[*graph.ConcretePort]Port
+}
 
 type Inventory struct {
 	Desc    string            `json:"desc"`
@@ -141,15 +146,20 @@ type TestData struct {
 	Links   []Link            `json:"links"`
 }
 
-func LoadConcreteGraph() {
+func LoadConcreteGraph(inventoryConfig Inventory) *ConcreteInventory {
 	log.Info().Msg("Invoked LoadConcreteGraph")
 	defer profile.LogFuncDuration(time.Now(), "LoadConcreteGraph", "", "controller")
 
+	inventory := &ConcreteInventory{
+		Graph:          graph.ConcreteGraph{},
+		NodesToDevices: map[*graph.ConcreteNode]Device{},
+		PortsToPorts:   map[*graph.ConcretePort]Port{},
+	}
 	nodes := []*graph.ConcreteNode{}
 	edges := []*graph.ConcreteEdge{}
 	portPointers := map[string]*graph.ConcretePort{}
 
-	for dname, device := range InventoryConfig.Devices {
+	for dname, device := range inventoryConfig.Devices {
 		ports := []*graph.ConcretePort{}
 
 		for _, port := range device.Ports {
@@ -169,7 +179,7 @@ func LoadConcreteGraph() {
 			}
 			newPort := &graph.ConcretePort{Desc: (dname + ":" + port.Id), Attrs: port.Attrs}
 			ports = append(ports, newPort)
-			ConfigPortsToPorts[newPort] = port
+			inventory.PortsToPorts[newPort] = port
 			portPointers[dname+":"+port.Id] = newPort
 		}
 
@@ -193,12 +203,12 @@ func LoadConcreteGraph() {
 		}
 		newNode := &graph.ConcreteNode{Desc: dname, Ports: ports, Attrs: device.Attrs}
 		nodes = append(nodes, newNode)
-		ConfigNodesToDevices[newNode] = device
+		inventory.NodesToDevices[newNode] = device
 	}
 
-	InventoryGraph.Nodes = nodes
+	inventory.Graph.Nodes = nodes
 
-	for _, link := range InventoryConfig.Links {
+	for _, link := range inventoryConfig.Links {
 		srcPort, srcExists := portPointers[link.Src.Device+":"+link.Src.Port]
 		dstPort, dstExists := portPointers[link.Dst.Device+":"+link.Dst.Port]
 		if !srcExists || !dstExists {
@@ -213,9 +223,10 @@ func LoadConcreteGraph() {
 		edges = append(edges, newEdge)
 	}
 
-	InventoryGraph.Edges = edges
+	inventory.Graph.Edges = edges
 
-	log.Info().Interface("InventoryGraph", InventoryGraph).Msg("Inventory graph")
+	log.Info().Interface("InventoryGraph", inventory.Graph).Msg("Inventory graph")
+	return inventory
 }
 
 func LoadAbstractGraph(testbedConfig Testbed, testbed *graph.AbstractGraph) {
@@ -437,56 +448,41 @@ func convertAttributesToStrings(data map[string]interface{}) {
 	}
 }
 
-func ConvertInventoryDataType() {
-	log.Info().Msg("Invoked ConvertInventoryDataType")
-	defer profile.LogFuncDuration(time.Now(), "ConvertInventoryDataType", "", "controller")
+// ConvertInventory converts the inventory obtained from NetBox, with device
+// attributes converted from other types to string
+func ConvertInventory(dut inven.Dut) (Inventory, error) {
+	log.Info().Msg("Invoked ConvertInventory")
+	defer profile.LogFuncDuration(time.Now(), "ConvertInventory", "", "controller")
 
-	// Read the JSON file
-	data, err := os.ReadFile("inventory.json")
+	data, err := json.Marshal(dut)
 	if err != nil {
-		log.Fatal().Msgf("Failed to read file: %v", err)
+		return Inventory{}, fmt.Errorf("failed to marshal inventory: %w", err)
 	}
-
-	// Unmarshal the JSON data into a map[string]interface{}
 	var jsonData map[string]interface{}
-	err = json.Unmarshal(data, &jsonData)
-	if err != nil {
-		log.Fatal().Msgf("Failed to unmarshal JSON: %v", err)
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		return Inventory{}, fmt.Errorf("failed to unmarshal inventory: %w", err)
 	}
 
 	// Convert integer and boolean values in the attributes dictionary to strings
 	convertAttributesToStrings(jsonData)
 
-	// Marshal the modified JSON data back to a JSON string
-	modifiedData, err := json.MarshalIndent(jsonData, "", "  ")
+	modifiedData, err := json.Marshal(jsonData)
 	if err != nil {
-		log.Fatal().Msgf("Failed to marshal JSON: %v", err)
+		return Inventory{}, fmt.Errorf("failed to marshal converted inventory: %w", err)
 	}
-
-	// Write the modified JSON to a new file
-	err = os.WriteFile("inventory.json", modifiedData, 0644)
-	if err != nil {
-		log.Fatal().Msgf("Failed to write file: %v", err)
+	var inventory Inventory
+	if err := json.Unmarshal(modifiedData, &inventory); err != nil {
+		return Inventory{}, fmt.Errorf("failed to unmarshal converted inventory: %w", err)
 	}
+	return inventory, nil
 }
 
-func ProcessInventory(filePath string, destLink Link) (map[string]L1Swport, error) {
+func ProcessInventory(inventory Inventory, destLink Link) map[string]L1Swport {
 	log.Info().Msg("Invoked ProcessInventory to get the Switch connected Ports")
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %v", err)
-	}
-	var inventory Inventory
-	err = json.Unmarshal(data, &inventory)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
-	}
-
 	deviceMap := make(map[string]L1Swport)
-	portPattern := regexp.MustCompile(`^\d+(\.\d+){1,3}$`)
 	for _, link := range inventory.Links {
 		if (link.Dst.Port == destLink.Dst.Port && link.Dst.Device == destLink.Dst.Device) || (link.Src.Port == destLink.Src.Port && link.Src.Device == destLink.Src.Device) {
-			if len(link.Src.Port) > 0 && (strings.ToLower(link.Src.Port)[0] == 'p' || portPattern.MatchString(link.Src.Port)) {
+			if isSwitchPort(link.Src.Port) {
 				if ports, ok := deviceMap[link.Src.Device]; ok {
 					ports.Src = link.Src.Port
 					deviceMap[link.Src.Device] = ports
@@ -494,7 +490,7 @@ func ProcessInventory(filePath string, destLink Link) (map[string]L1Swport, erro
 					deviceMap[link.Src.Device] = L1Swport{Src: link.Src.Port}
 				}
 			}
-			if len(link.Dst.Port) > 0 && (strings.ToLower(link.Dst.Port)[0] == 'p' || portPattern.MatchString(link.Dst.Port)) {
+			if isSwitchPort(link.Dst.Port) {
 				if ports, ok := deviceMap[link.Dst.Device]; ok {
 					ports.Dst = link.Dst.Port
 					deviceMap[link.Dst.Device] = ports
@@ -508,14 +504,16 @@ func ProcessInventory(filePath string, destLink Link) (map[string]L1Swport, erro
 	for device, ports := range deviceMap {
 		deviceMap[device] = L1Swport{Src: ports.Src, Dst: ports.Dst}
 	}
-	return deviceMap, nil
+	return deviceMap
 }
 
-func setupSwitchLinks(deviceMap map[string]L1Swport, switchLocation string, userId string) error {
+// setupSwitchLinks pushes the L1 switch config connecting the given ports and
+// returns it, or nil if there's nothing to connect
+func setupSwitchLinks(deviceMap map[string]L1Swport, switchLocation string, userId string) (gol1s.Config, error) {
 	log.Info().Msg("Invoked setupSwitchLinks to configure Switch Ports")
 	if len(deviceMap) > 1 {
 		// Skip processing if deviceMap has multiple keys
-		return nil
+		return nil, nil
 	}
 	api := gol1s.NewApi()
 	api.NewGrpcTransport().SetLocation(switchLocation)
@@ -526,62 +524,45 @@ func setupSwitchLinks(deviceMap map[string]L1Swport, switchLocation string, user
 		link := l1sConfig.Links().Add()
 		link.SetSrc(ports.Src).SetDst(ports.Dst)
 	}
-	// Append l1sConfig to the global list
-	globalL1sConfigs = append(globalL1sConfigs, l1sConfig)
-	// Add entry to the map
-	userConfigs[userId] = globalL1sConfigs
+	// Record l1sConfig against the session before pushing it
+	addL1sConfig(userId, l1sConfig)
 	_, err := api.SetConfig(l1sConfig)
 	if err != nil {
-		return fmt.Errorf("failed to configure switch ports: %w", err)
+		dropL1sConfig(userId, l1sConfig)
+		return nil, fmt.Errorf("failed to configure switch ports: %w", err)
 	}
-	return nil
+	return l1sConfig, nil
 }
 
 func removeSwitchLinks(switchLocation string, userId string) error {
 	log.Info().Msg("Invoked removeSwitchLinks method")
-	api := gol1s.NewApi()
-	api.NewGrpcTransport().SetLocation(switchLocation)
-	for key, value := range userConfigs {
-		if key == userId {
-			for _, config := range value {
-				// Modify the operation field from CREATE to DELETE
-				config.SetOperation(gol1s.ConfigOperation.DELETE)
-				_, err := api.SetConfig(config)
-				if err != nil {
-					return fmt.Errorf("failed to delete configured switch ports: %w", err)
-				}
-			}
+	for _, config := range sessionL1sConfigs(userId) {
+		if err := deleteSwitchLinks(switchLocation, userId, config); err != nil {
+			return err
 		}
-
 	}
-	globalL1sConfigs = []gol1s.Config{}
 	return nil
 }
 
-// Generate an 8-byte unique user ID
-func generateUserID() (string, error) {
-	// Get the hostname of the device
-	// hostname, err := os.Hostname()
-	// if err != nil {
-	// 	return "", fmt.Errorf("failed to get hostname: %v", err)
-	// }
-	hostname := "common"
-
-	// Get the current timestamp in RFC3339Nano format
-	timestamp := time.Now().Format(time.RFC3339Nano)
-
-	// Combine the hostname, random user ID, and timestamp
-	combinedID := fmt.Sprintf("%s-%s", hostname, timestamp)
-
-	return combinedID, nil
+// deleteSwitchLinks removes an L1 switch config pushed for a session
+func deleteSwitchLinks(switchLocation string, userId string, config gol1s.Config) error {
+	api := gol1s.NewApi()
+	api.NewGrpcTransport().SetLocation(switchLocation)
+	// Modify the operation field from CREATE to DELETE
+	config.SetOperation(gol1s.ConfigOperation.DELETE)
+	_, err := api.SetConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to delete configured switch ports: %w", err)
+	}
+	dropL1sConfig(userId, config)
+	return nil
 }
 
-// Helper function to check if the userId is present in releaseState
-func userPresentInReleaseState(userId string, releaseState map[string][]map[string]interface{}) bool {
-	for key := range releaseState {
-		if key == userId {
-			return true
-		}
+// generateUserID returns a random, opaque session ID
+func generateUserID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
 	}
-	return false
+	return hex.EncodeToString(id), nil
 }
@@ -0,0 +1,406 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"keysight/laas/controller/config"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/store"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+	graph "github.com/openconfig/ondatra/binding/portgraph"
+)
+
+// bookingMutex serializes booking resources for scheduled reservations
+// against claiming them, so that both can not pick the same resources for
+// overlapping time ranges
+var bookingMutex sync.Mutex
+
+// maxActivationAttempts bounds the number of times activating a scheduled
+// reservation is attempted, once per scheduler tick, before it's failed
+const maxActivationAttempts = 3
+
+// sessionResources returns the resources held by a session record
+func sessionResources(session *store.Session) claimedResources {
+	resources := claimedResources{}
+	for _, device := range session.Devices {
+		if exclusiveDevice(device.Role) {
+			resources.devices = append(resources.devices, device.Name)
+		}
+		for _, port := range device.Ports {
+			resources.ports = append(resources.ports, device.Name+":"+port)
+		}
+	}
+	return resources
+}
+
+// add appends other resources to r
+func (r *claimedResources) add(other claimedResources) {
+	r.devices = append(r.devices, other.devices...)
+	r.ports = append(r.ports, other.ports...)
+}
+
+// without returns the resources of r which aren't in other
+func (r claimedResources) without(other claimedResources) claimedResources {
+	excluded := map[string]bool{}
+	for _, device := range other.devices {
+		excluded["device:"+device] = true
+	}
+	for _, port := range other.ports {
+		excluded["port:"+port] = true
+	}
+	rest := claimedResources{}
+	for _, device := range r.devices {
+		if !excluded["device:"+device] {
+			rest.devices = append(rest.devices, device)
+		}
+	}
+	for _, port := range r.ports {
+		if !excluded["port:"+port] {
+			rest.ports = append(rest.ports, port)
+		}
+	}
+	return rest
+}
+
+// conflict returns the resources held by both r and other, or nil if none
+func (r claimedResources) conflict(other claimedResources) *ClaimConflictError {
+	devices := map[string]bool{}
+	for _, device := range r.devices {
+		devices[device] = true
+	}
+	ports := map[string]bool{}
+	for _, port := range r.ports {
+		ports[port] = true
+	}
+
+	conflict := &ClaimConflictError{}
+	for _, device := range other.devices {
+		if devices[device] {
+			conflict.Devices = append(conflict.Devices, device)
+		}
+	}
+	for _, port := range other.ports {
+		if ports[port] {
+			conflict.Ports = append(conflict.Ports, port)
+		}
+	}
+	if len(conflict.Devices) == 0 && len(conflict.Ports) == 0 {
+		return nil
+	}
+	sort.Strings(conflict.Devices)
+	sort.Strings(conflict.Ports)
+	return conflict
+}
+
+// bookedResources returns the resources of scheduled sessions, other than
+// skipID, that are booked at any time within [from, until)
+func bookedResources(skipID string, from time.Time, until *time.Time) (claimedResources, error) {
+	sessions, err := sessionStore.List()
+	if err != nil {
+		return claimedResources{}, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	booked := claimedResources{}
+	for _, session := range sessions {
+		if session.ID != skipID && session.Status == store.StatusScheduled && session.Overlaps(from, until) {
+			booked.add(sessionResources(session))
+		}
+	}
+	return booked, nil
+}
+
+// claimUnbooked claims resources for userID over [from, until), unless some
+// of them are booked by a scheduled session within that range
+func claimUnbooked(userID string, resources claimedResources, from time.Time, until *time.Time) error {
+	bookingMutex.Lock()
+	defer bookingMutex.Unlock()
+
+	if err := claims.claim(userID, resources); err != nil {
+		return err
+	}
+	booked, err := bookedResources(userID, from, until)
+	if err != nil {
+		claims.release(userID)
+		return err
+	}
+	if conflict := booked.conflict(resources); conflict != nil {
+		claims.release(userID)
+		return conflict
+	}
+	return nil
+}
+
+// markReserved marks the given devices and ports of the inventory graph as
+// reserved, so that the solver does not pick them
+func markReserved(inventory *ConcreteInventory, resources claimedResources) {
+	devices := map[string]bool{}
+	for _, device := range resources.devices {
+		devices[device] = true
+	}
+	ports := map[string]bool{}
+	for _, port := range resources.ports {
+		ports[port] = true
+	}
+	for _, node := range inventory.Graph.Nodes {
+		if devices[node.Desc] {
+			node.Attrs["reserved"] = "yes"
+		}
+		for _, port := range node.Ports {
+			if ports[port.Desc] {
+				port.Attrs["reserved"] = "yes"
+			}
+		}
+	}
+}
+
+// restrictTo marks every device and port of the inventory graph other than
+// the given ones as reserved, so that the solver only picks among them
+func restrictTo(inventory *ConcreteInventory, resources claimedResources) {
+	devices := map[string]bool{}
+	for _, device := range resources.devices {
+		devices[device] = true
+	}
+	ports := map[string]bool{}
+	for _, port := range resources.ports {
+		ports[port] = true
+	}
+	for _, node := range inventory.Graph.Nodes {
+		// shared devices (ATEs, L1 switches) are only booked by their ports
+		allowed := devices[node.Desc]
+		for _, port := range node.Ports {
+			if ports[port.Desc] {
+				allowed = true
+			} else {
+				port.Attrs["reserved"] = "yes"
+			}
+		}
+		if !allowed {
+			node.Attrs["reserved"] = "yes"
+		}
+	}
+}
+
+// Schedule books resources matching the testbed for [start, end); they're
+// reserved at start time and released at end time. Only the priority and
+// metadata of opts apply
+func Schedule(ctx context.Context, data goopentestbed.Testbed, start time.Time, end time.Time, opts ReserveOptions) (*store.Session, error) {
+	defer profile.LogFuncDuration(time.Now(), "Schedule", "", "controller")
+
+	if !start.After(time.Now()) {
+		return nil, fmt.Errorf("start time %s is not in the future", start.Format(time.RFC3339))
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("end time %s is not after start time %s", end.Format(time.RFC3339), start.Format(time.RFC3339))
+	}
+	if _, err := CheckForDuplicateIDs(data); err != nil {
+		return nil, err
+	}
+	request, err := data.Marshal().ToJson()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal testbed: %w", err)
+	}
+	userID, err := generateUserID()
+	if err != nil {
+		return nil, fmt.Errorf("error generating user ID: %w", err)
+	}
+
+	testbedConfig := ConvertData(data)
+	testbed := graph.AbstractGraph{}
+	LoadAbstractGraph(testbedConfig, &testbed)
+
+	// Bookings are checked against the whole inventory, regardless of what is
+	// reserved right now
+	globalInventory, _, err := inventoryProvider.Inventory(ctx, opts.Scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	globalConfig, err := ConvertInventory(globalInventory)
+	if err != nil {
+		return nil, err
+	}
+
+	bookingMutex.Lock()
+	defer bookingMutex.Unlock()
+
+	sessions, err := sessionStore.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	busy := claimedResources{}
+	stored := map[string]bool{}
+	for _, session := range sessions {
+		stored[session.ID] = true
+		if session.Overlaps(start, &end) {
+			busy.add(sessionResources(session))
+		}
+	}
+	// resources claimed by reservations in progress have no known end yet
+	for owner, resources := range claims.held() {
+		if !stored[owner] {
+			busy.add(resources)
+		}
+	}
+
+	inventory := loadFreeConcreteGraph(globalConfig)
+	markReserved(inventory, busy)
+	assignment, err := graph.Solve(ctx, &testbed, &inventory.Graph)
+	if err != nil {
+		if satisfiable(ctx, &testbed, globalConfig) {
+			return nil, fmt.Errorf("found inventory mismatch: %w between %s and %s", ErrResourcesUnavailable, start.Format(time.RFC3339), end.Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("found inventory mismatch: %w", err)
+	}
+
+	session := newSession(userID, assignedDevices(&testbed, assignment), ReserveOptions{Priority: opts.Priority, Metadata: opts.Metadata})
+	session.StartsAt = &start
+	session.ExpiresAt = &end
+	session.Status = store.StatusScheduled
+	session.Request = request
+	if err := sessionStore.Save(session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	log.Info().Str("UserID", userID).Time("StartsAt", start).Time("EndsAt", end).Msg("Scheduled reservation")
+	return session, nil
+}
+
+// checkBookings fails if resources of the session are booked by a scheduled
+// session before the given time; used when extending the session's lease
+func checkBookings(session *store.Session, until time.Time) error {
+	booked, err := bookedResources(session.ID, time.Now(), &until)
+	if err != nil {
+		return err
+	}
+	if conflict := booked.conflict(sessionResources(session)); conflict != nil {
+		return fmt.Errorf("resources are booked by a scheduled reservation before %s: %w", until.Format(time.RFC3339), conflict)
+	}
+	return nil
+}
+
+// SpawnScheduler spawns a goroutine in background that activates scheduled
+// reservations which are due every interval
+func SpawnScheduler() error {
+	interval := time.Duration(*config.Config.SchedulerIntervalSeconds) * time.Second
+	if interval <= 0 {
+		return fmt.Errorf("scheduler interval must be positive, got %v", interval)
+	}
+	log.Info().Dur("interval", interval).Msg("Scheduler initiated")
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			activateDueSessions()
+		}
+	}()
+
+	return nil
+}
+
+func activateDueSessions() {
+	log.Debug().Msg("Checking for scheduled sessions")
+	sessions, err := sessionStore.List()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list sessions")
+		return
+	}
+	now := time.Now()
+	for _, session := range sessions {
+		// sessions which ended before being activated are left to the reaper
+		if session.Status == store.StatusScheduled && !session.Start().After(now) && !session.Expired(now) {
+			activateSession(session)
+		}
+	}
+}
+
+// activateSession reserves the resources booked by a scheduled session; a
+// failed activation is recorded and retried upon the next scheduler ticks,
+// the session is failed after maxActivationAttempts
+func activateSession(booking *store.Session) {
+	log.Info().Str("UserID", booking.ID).Msg("Activating scheduled session")
+	data := goopentestbed.NewTestbed()
+	err := data.Unmarshal().FromJson(booking.Request)
+	if err == nil {
+		_, err = reserve(context.Background(), data, ReserveOptions{booking: booking})
+	}
+	if err == nil {
+		log.Info().Str("UserID", booking.ID).Msg("Activated scheduled session")
+		return
+	}
+	log.Error().Err(err).Str("UserID", booking.ID).Msg("Failed to activate scheduled session")
+
+	unlock := sessionLocks.lock(booking.ID)
+	defer unlock()
+	session, getErr := sessionStore.Get(booking.ID)
+	if getErr != nil {
+		if !errors.Is(getErr, store.ErrNotFound) {
+			log.Error().Err(getErr).Str("UserID", booking.ID).Msg("Failed to get session")
+			return
+		}
+		// rolled back, keep the booking around to retry or report the failure
+		session = booking
+	} else if session.Status != store.StatusScheduled {
+		log.Warn().Str("UserID", booking.ID).Str("Status", string(session.Status)).Msg("Scheduled session changed while activating")
+		return
+	}
+	session.Status = store.StatusScheduled
+	session.Error = err.Error()
+	session.Attempts++
+	if session.Attempts >= maxActivationAttempts {
+		log.Error().Str("UserID", booking.ID).Int("Attempts", session.Attempts).Msg("Giving up activating scheduled session")
+		session.Status = store.StatusFailed
+	}
+	if err := sessionStore.Save(session); err != nil {
+		log.Error().Err(err).Str("UserID", booking.ID).Msg("Failed to save session")
+	}
+}
+
+// Booking is a time range over which a session holds (or will hold) a device
+type Booking struct {
+	SessionID string
+	Status    store.SessionStatus
+	Start     time.Time
+	End       *time.Time
+	Ports     []string
+}
+
+// Calendar returns the bookings of each device within [from, until),
+// optionally narrowed down to a single device
+func Calendar(from time.Time, until time.Time, device string) (map[string][]Booking, error) {
+	sessions, err := sessionStore.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	calendar := map[string][]Booking{}
+	for _, session := range sessions {
+		if !session.Overlaps(from, &until) {
+			continue
+		}
+		status := session.Status
+		if status == "" {
+			status = store.StatusActive
+		}
+		for _, d := range session.Devices {
+			if device != "" && !strings.EqualFold(d.Name, device) {
+				continue
+			}
+			calendar[d.Name] = append(calendar[d.Name], Booking{
+				SessionID: session.ID,
+				Status:    status,
+				Start:     session.Start(),
+				End:       session.ExpiresAt,
+				Ports:     append([]string{}, d.Ports...),
+			})
+		}
+	}
+	for _, bookings := range calendar {
+		sort.Slice(bookings, func(i, j int) bool {
+			return bookings[i].Start.Before(bookings[j].Start)
+		})
+	}
+	return calendar, nil
+}
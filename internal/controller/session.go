@@ -0,0 +1,290 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"keysight/laas/controller/config"
+	"keysight/laas/controller/internal/store"
+	"keysight/laas/controller/internal/utils"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/open-traffic-generator/openl1s/gol1s"
+)
+
+var sessionStore store.Store
+
+// in-memory release state of sessions, guarded by stateMutex
+var (
+	releaseState = make(map[string][]map[string]interface{})
+	userConfigs  = make(map[string][]gol1s.Config)
+	stateMutex   sync.Mutex
+)
+
+// InitSessionStore opens the configured session store and reloads all
+// persisted sessions so that they can be released after a restart
+func InitSessionStore() error {
+	s, err := store.New(*config.Config.SessionStore, *config.Config.SessionStoreDir)
+	if err != nil {
+		return err
+	}
+	sessionStore = s
+
+	sessions, err := sessionStore.List()
+	if err != nil {
+		return fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		if err := restoreSession(session); err != nil {
+			log.Error().Err(err).Str("UserID", session.ID).Msg("Failed to restore session")
+			continue
+		}
+		log.Info().Str("UserID", session.ID).Msg("Restored session")
+	}
+	return nil
+}
+
+// restoreSession populates in-memory release state and resource claims from
+// a persisted session; the release state is kept even if the resources can't
+// be claimed, so that releasing the session still resets the inventory and
+// switches
+func restoreSession(session *store.Session) error {
+	configs := []gol1s.Config{}
+	for _, l1sConfig := range session.L1sConfigs {
+		cfg := gol1s.NewConfig()
+		if err := cfg.Unmarshal().FromJson(l1sConfig.Config); err != nil {
+			return fmt.Errorf("failed to unmarshal L1S config: %w", err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	objects := []map[string]interface{}{}
+	for _, object := range session.NetboxObjects {
+		objects = append(objects, map[string]interface{}{object.URL: object.Data})
+	}
+
+	stateMutex.Lock()
+	if len(configs) != 0 {
+		userConfigs[session.ID] = configs
+	}
+	if len(objects) != 0 {
+		releaseState[session.ID] = objects
+	}
+	stateMutex.Unlock()
+
+	// resources of scheduled sessions are claimed once activated
+	if session.Active() {
+		if err := claims.claim(session.ID, sessionResources(session)); err != nil {
+			return fmt.Errorf("failed to claim resources: %w", err)
+		}
+	}
+	return nil
+}
+
+// addL1sConfig records an L1 switch config pushed for a session
+func addL1sConfig(userID string, cfg gol1s.Config) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	userConfigs[userID] = append(userConfigs[userID], cfg)
+}
+
+// sessionL1sConfigs returns the L1 switch configs pushed for a session
+func sessionL1sConfigs(userID string) []gol1s.Config {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return append([]gol1s.Config{}, userConfigs[userID]...)
+}
+
+// dropL1sConfig forgets an L1 switch config of a session once it's removed
+// from the switch
+func dropL1sConfig(userID string, cfg gol1s.Config) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	configs := userConfigs[userID]
+	for i, c := range configs {
+		if c == cfg {
+			userConfigs[userID] = append(configs[:i:i], configs[i+1:]...)
+			return
+		}
+	}
+}
+
+// addReleaseState records NetBox objects patched for a session
+func addReleaseState(userID string, objects []map[string]interface{}) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if len(objects) != 0 {
+		releaseState[userID] = append(releaseState[userID], objects...)
+	}
+}
+
+// dropReleaseState forgets a NetBox object of a session once its state is
+// restored
+func dropReleaseState(userID string, url string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	objects := []map[string]interface{}{}
+	for _, object := range releaseState[userID] {
+		if _, ok := object[url]; !ok {
+			objects = append(objects, object)
+		}
+	}
+	releaseState[userID] = objects
+}
+
+// sessionReleaseState returns release state holding only the given session,
+// or nil if no NetBox object was patched for it
+func sessionReleaseState(userID string) map[string][]map[string]interface{} {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	objects, ok := releaseState[userID]
+	if !ok {
+		return nil
+	}
+	return map[string][]map[string]interface{}{
+		userID: append([]map[string]interface{}{}, objects...),
+	}
+}
+
+// Sessions returns all reserved sessions ordered by creation time
+func Sessions() ([]*store.Session, error) {
+	sessions, err := sessionStore.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// GetSession returns the reserved session for the given ID
+func GetSession(userID string) (*store.Session, error) {
+	return sessionStore.Get(userID)
+}
+
+// SessionL1sLinks returns the L1 switch port pairs cross-connected for a session
+func SessionL1sLinks(session *store.Session) ([]L1Swport, error) {
+	links := []L1Swport{}
+	for _, l1sConfig := range session.L1sConfigs {
+		cfg := gol1s.NewConfig()
+		if err := cfg.Unmarshal().FromJson(l1sConfig.Config); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal L1S config: %w", err)
+		}
+		for _, link := range cfg.Links().Items() {
+			links = append(links, L1Swport{Src: link.Src(), Dst: link.Dst()})
+		}
+	}
+	return links, nil
+}
+
+// newSession returns the session record for a reservation being made
+func newSession(userID string, devices map[string]BDevice, opts ReserveOptions) *store.Session {
+	session := &store.Session{
+		ID:             userID,
+		CreatedAt:      time.Now(),
+		Priority:       opts.Priority,
+		IdempotencyKey: opts.IdempotencyKey,
+		RequestHash:    opts.requestHash,
+		Metadata:       opts.Metadata,
+	}
+	if opts.Lease > 0 {
+		expiresAt := session.CreatedAt.Add(opts.Lease)
+		session.ExpiresAt = &expiresAt
+	}
+	if opts.booking != nil {
+		session.StartsAt = opts.booking.StartsAt
+		session.ExpiresAt = opts.booking.ExpiresAt
+		session.Priority = opts.booking.Priority
+		session.Metadata = opts.booking.Metadata
+		session.Status = store.StatusActive
+	}
+
+	session.Devices = sessionDevices(devices)
+	return session
+}
+
+// sessionDevices returns the inventory devices and ports of a testbed
+func sessionDevices(devices map[string]BDevice) []store.Device {
+	reservedDevices := []store.Device{}
+	for _, device := range devices {
+		reserved := store.Device{Name: device.Id, Role: device.Attrs["role"]}
+		for _, port := range device.Ports {
+			_, portName := utils.SplitString(port.Id)
+			reserved.Ports = append(reserved.Ports, portName)
+		}
+		reservedDevices = append(reservedDevices, reserved)
+	}
+	return reservedDevices
+}
+
+// setTopology records the testbed devices and links of a session
+func setTopology(session *store.Session, devices map[string]BDevice, links []Link) error {
+	topology, err := json.Marshal(Testbed{Desc: "testbed", Devices: devices, Links: links})
+	if err != nil {
+		return fmt.Errorf("failed to marshal topology: %w", err)
+	}
+	session.Topology = string(topology)
+	return nil
+}
+
+// sessionTopology returns the testbed devices and links of a session
+func sessionTopology(session *store.Session) (Testbed, error) {
+	topology := Testbed{}
+	if session.Topology == "" {
+		return topology, fmt.Errorf("session %s has no recorded topology", session.ID)
+	}
+	if err := json.Unmarshal([]byte(session.Topology), &topology); err != nil {
+		return topology, fmt.Errorf("failed to unmarshal topology: %w", err)
+	}
+	return topology, nil
+}
+
+// saveSession persists the session along with its current release state
+func saveSession(session *store.Session) error {
+	stateMutex.Lock()
+	session.NetboxObjects = nil
+	session.L1sConfigs = nil
+	for _, object := range releaseState[session.ID] {
+		for url, data := range object {
+			dataMap, ok := data.(map[string]interface{})
+			if !ok {
+				stateMutex.Unlock()
+				return fmt.Errorf("unexpected release state for %s", url)
+			}
+			session.NetboxObjects = append(session.NetboxObjects, store.NetboxObject{URL: url, Data: dataMap})
+		}
+	}
+
+	for _, cfg := range userConfigs[session.ID] {
+		cfgJSON, err := cfg.Marshal().ToJson()
+		if err != nil {
+			stateMutex.Unlock()
+			return fmt.Errorf("failed to marshal L1S config: %w", err)
+		}
+		session.L1sConfigs = append(session.L1sConfigs, store.L1sConfig{Config: cfgJSON})
+	}
+	stateMutex.Unlock()
+
+	return sessionStore.Save(session)
+}
+
+// deleteSession drops a released session from memory and from the store,
+// and frees the resources it claimed
+func deleteSession(userID string) error {
+	stateMutex.Lock()
+	delete(releaseState, userID)
+	delete(userConfigs, userID)
+	stateMutex.Unlock()
+
+	claims.release(userID)
+	// freed resources may let a queued reservation proceed
+	queue.notify()
+	if err := sessionStore.Delete(userID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
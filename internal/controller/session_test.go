@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"testing"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// forgetSession drops the in-memory state of a session, as a restart does
+func forgetSession(userID string) {
+	claims.release(userID)
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	delete(releaseState, userID)
+	delete(userConfigs, userID)
+}
+
+func TestRestoreSessionRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		// conflicting, if set, claims the resources of the session before it's
+		// restored
+		conflicting bool
+	}{
+		{name: "restored"},
+		{name: "resources claimed by another session", conflicting: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := useFileInventory(t, modifyInventory)
+			userID := reserveTestbed(t, `{"devices": [{"id": "d1", "role": "DUT", "vendor": "cisco", "ports": [{"id": "p1"}]}]}`)
+			picked := []inven.ReservedDevice{{Name: "dut1", Role: "DUT", Ports: []string{"eth1"}}}
+			if err := provider.Recheck(context.Background(), picked); err == nil {
+				t.Fatal("dut1 not reserved in the inventory")
+			}
+
+			forgetSession(userID)
+			session, err := sessionStore.Get(userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.conflicting {
+				if err := claims.claim("other", sessionResources(session)); err != nil {
+					t.Fatal(err)
+				}
+				defer claims.release("other")
+			}
+
+			err = restoreSession(session)
+			conflict := &ClaimConflictError{}
+			if tt.conflicting != errors.As(err, &conflict) {
+				t.Fatalf("restoreSession() = %v, want a claim conflict %v", err, tt.conflicting)
+			}
+			if got := claims.held()[userID]; tt.conflicting == (len(got.devices) != 0) {
+				t.Errorf("session holds %+v after restore", got)
+			}
+
+			// the session can be released either way, resetting the inventory
+			if _, err := Release(goopentestbed.NewSession().SetId(userID)); err != nil {
+				t.Fatalf("Release() = %v", err)
+			}
+			if err := provider.Recheck(context.Background(), picked); err != nil {
+				t.Errorf("dut1 still reserved in the inventory after release: %v", err)
+			}
+			if _, err := sessionStore.Get(userID); err == nil {
+				t.Error("session still stored after release")
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// compensation undoes a single side effect of a reservation
+type compensation struct {
+	desc string
+	undo func() error
+}
+
+// transaction records the side effects of a reservation as they happen, so
+// that they can be undone in reverse order if a later step fails
+type transaction struct {
+	userID string
+	steps  []compensation
+}
+
+func newTransaction(userID string) *transaction {
+	return &transaction{userID: userID}
+}
+
+// record registers the action compensating a side effect which took place
+func (t *transaction) record(desc string, undo func() error) {
+	t.steps = append(t.steps, compensation{desc: desc, undo: undo})
+}
+
+// rollback runs every recorded compensation, latest first; compensations
+// failing do not stop the remaining ones from running
+func (t *transaction) rollback() error {
+	var errs []error
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		step := t.steps[i]
+		if err := step.undo(); err != nil {
+			log.Error().Err(err).Str("UserID", t.userID).Str("Step", step.desc).Msg("Rollback step failed")
+			errs = append(errs, fmt.Errorf("%s: %w", step.desc, err))
+			continue
+		}
+		log.Info().Str("UserID", t.userID).Str("Step", step.desc).Msg("Rolled back")
+	}
+	t.steps = nil
+	return errors.Join(errs...)
+}
+
+// ReserveError is returned when a reservation fails after some of its side
+// effects took place, reporting whether they got rolled back
+type ReserveError struct {
+	Err         error
+	RollbackErr error
+}
+
+func (e *ReserveError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%v (rollback failed: %v)", e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("%v (rolled back)", e.Err)
+}
+
+func (e *ReserveError) Unwrap() error {
+	return e.Err
+}
+
+// RolledBack reports whether every side effect of the reservation was undone
+func (e *ReserveError) RolledBack() bool {
+	return e.RollbackErr == nil
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"keysight/laas/controller/config"
 	"keysight/laas/controller/internal/profile"
-	"os"
 	"strings"
 	"time"
 )
@@ -146,7 +145,7 @@ var log = config.GetLogger("cafy")
 func convertJSON(originalData OriginalJSON) NewJSON {
 	defer profile.LogFuncDuration(time.Now(), "convertJSON", "", "cafy")
 
-	// This function converts generated output.json to Cafyq_testbed.json
+	// This function converts the generic testbed to the cafy testbed format
 	log.Info().Msg("Invoked convertJSON")
 	newData := NewJSON{
 		Credentials: make([]struct {
@@ -339,23 +338,23 @@ func addAccessInfoIfConsolePresent(node *Node, device Device) {
 	})
 }
 
-// This function converts generated output.json to Cafyq_testbed.json
-func CafyMain() (string, error) {
+// CafyMain converts the generic testbed to a cafy testbed
+func CafyMain(testbed []byte) (string, error) {
 	defer profile.LogFuncDuration(time.Now(), "CafyMain", "", "cafy")
-	updateOutputJson()
-	// Specify the path to your JSON file
-	filePath := "output.json"
 
-	// Read the JSON data from the file
-	jsonData, err := os.ReadFile(filePath)
+	var output Output
+	if err := json.Unmarshal(testbed, &output); err != nil {
+		return "", fmt.Errorf("failed to unmarshal testbed: %w", err)
+	}
+	updatedData, err := json.Marshal(updateOutput(output))
 	if err != nil {
-		return "", fmt.Errorf("failed to read JSON file: %w", err)
+		return "", fmt.Errorf("failed to marshal updated testbed: %w", err)
 	}
 
-	// Parse the JSON data into the OriginalJSON struct
+	// Parse the updated testbed into the OriginalJSON struct
 	var originalData OriginalJSON
-	if err := json.Unmarshal(jsonData, &originalData); err != nil {
-		return "", fmt.Errorf("failed to unmarshal JSON file: %w", err)
+	if err := json.Unmarshal(updatedData, &originalData); err != nil {
+		return "", fmt.Errorf("failed to unmarshal updated testbed: %w", err)
 	}
 
 	// Convert the originalData to the desired format
@@ -364,29 +363,8 @@ func CafyMain() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to convert data to JSON file: %w", err)
 	}
-	// Specify the path for the output JSON file
-	outputFilePath := "cafy_testbed.json"
-
-	// Write the result JSON to the output file
-	err = os.WriteFile(outputFilePath, resultJSON, 0644)
-	if err != nil {
-		return "", fmt.Errorf("failed to write data to output file: %w", err)
-	}
 	log.Info().Msg("Successfully generated cafy testbed file")
-	// Read the content of the generated file
-	fileContent, err := os.ReadFile(outputFilePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read output file: %w", err)
-	}
-	// Print the content of the generated file
-	//log.Debug().Msgf("Generated cafy_testbed.json content: %s", string(fileContent))
-	// Respond to the client with the result JSON using NewJSON
-	// c.IndentedJSON(http.StatusOK, NewJSON{
-	// 	Credentials: newData.Credentials,
-	// 	Name:        "Sample_TOPO",
-	// 	Nodes:       newData.Nodes,
-	// })
 
-	log.Info().Interface("Cafy testbed data", string(fileContent)).Msg("Cafy output")
-	return string(fileContent), nil
+	log.Info().Interface("Cafy testbed data", string(resultJSON)).Msg("Cafy output")
+	return string(resultJSON), nil
 }
@@ -1,10 +1,8 @@
 package cafy
 
 import (
-	"encoding/json"
 	"fmt"
 	"keysight/laas/controller/internal/profile"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -171,21 +169,9 @@ func GenerateInterfaceMap(links []LinkConn, deviceId string, role string) map[st
 	return filteredInterfaceMap
 }
 
-func updateOutputJson() {
-	// Read the JSON file
-	file, err := os.ReadFile("output.json")
-	if err != nil {
-		log.Fatal().Msgf("Error reading file: %s, error: %s", file, err.Error())
-		return
-	}
-
-	// Parse the JSON data
-	var data Output
-	if err := json.Unmarshal(file, &data); err != nil {
-		log.Fatal().Msgf("Error unmarshalling JSON: %s", err.Error())
-		return
-	}
-
+// updateOutput renames devices of the generic testbed to cafy router names
+// (R1, R2.. for DUTs and T1, T2.. for ATEs)
+func updateOutput(data Output) Output {
 	// Track the next IDs for DUTs and ATEs
 	nextDutID := 1
 	nextAteID := 1
@@ -198,10 +184,11 @@ func updateOutputJson() {
 		var newID string
 
 		// Determine the new ID based on the role
-		if strings.ToLower(device.Attributes["role"].(string)) == "dut" {
+		role, _ := device.Attributes["role"].(string)
+		if strings.ToLower(role) == "dut" {
 			newID = fmt.Sprintf("R%d", nextDutID)
 			nextDutID++
-		} else if strings.ToLower(device.Attributes["role"].(string)) == "ate" {
+		} else if strings.ToLower(role) == "ate" {
 			newID = fmt.Sprintf("T%d", nextAteID)
 			nextAteID++
 		} else {
@@ -238,18 +225,8 @@ func updateOutputJson() {
 		}
 	}
 
-	// Write the updated data back to the JSON file
-	updatedData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		log.Fatal().Msgf("Error marshalling updated data: %s", err.Error())
-		return
-	}
-
-	if err := os.WriteFile("output.json", updatedData, 0644); err != nil {
-		log.Fatal().Msgf("Error writing updated file: %s", err.Error())
-		return
-	}
-	log.Info().Msg("Updated JSON file successfully with the proper router names.")
+	log.Info().Msg("Updated testbed successfully with the proper router names.")
+	return data
 }
 
 func stringConversion(value string, conType string) interface{} {
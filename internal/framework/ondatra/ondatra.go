@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"keysight/laas/controller/config"
 	"keysight/laas/controller/internal/profile"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -291,19 +290,15 @@ func convertToIntBool(data map[string]interface{}) {
 	}
 }
 
-func convertOutput() {
+// convertOutput converts integer and boolean device attributes of the generic
+// testbed from string to their respective types
+func convertOutput(testbed []byte) ([]byte, error) {
 	defer profile.LogFuncDuration(time.Now(), "convertOutput", "", "ondatra")
 
-	// Read the JSON file
-	data, err := os.ReadFile("output.json")
-	if err != nil {
-		log.Fatal().Msgf("Failed to read file: %v", err)
-	}
-
 	// Unmarshal the JSON data into a map[string]interface{}
 	var jsonData map[string]interface{}
-	if err := json.Unmarshal(data, &jsonData); err != nil {
-		log.Fatal().Msgf("Failed to unmarshal JSON: %v", err)
+	if err := json.Unmarshal(testbed, &jsonData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal testbed: %w", err)
 	}
 
 	// Convert integer and boolean values from string to their respective types
@@ -312,43 +307,35 @@ func convertOutput() {
 	// Marshal the modified JSON data back to a JSON string
 	modifiedData, err := json.MarshalIndent(jsonData, "", "  ")
 	if err != nil {
-		log.Fatal().Msgf("Failed to marshal JSON: %v", err)
+		return nil, fmt.Errorf("failed to marshal converted testbed: %w", err)
 	}
 
-	// Write the modified JSON back to a new file
-	if err := os.WriteFile("output.json", modifiedData, 0644); err != nil {
-		log.Fatal().Msgf("Failed to write file: %v", err)
-	}
-
-	log.Info().Msg("Data converted and written to modified_data.json successfully.")
+	log.Info().Msg("Data converted successfully.")
 	log.Debug().Interface("Ondatra converted output", modifiedData).Msg("")
+	return modifiedData, nil
 }
 
-func OndatraMain() (string, error) {
+// OndatraMain converts the generic testbed to an ondatra binding
+func OndatraMain(testbed []byte) (string, error) {
 	defer profile.LogFuncDuration(time.Now(), "OndatraMain", "", "ondatra")
 
-	convertOutput()
-	// Load the input JSON file
-	jsonData, err := os.ReadFile("output.json")
-	// Set the JSON data as an environment variable
-	os.Setenv("JSON_DATA", string(jsonData))
+	jsonData, err := convertOutput(testbed)
 	if err != nil {
-		log.Fatal().Msgf("Error reading JSON file: %v", err)
+		return "", err
 	}
-
 	// Unmarshal the JSON data into a TestData struct
 	var testData TestData
 	if err := json.Unmarshal(jsonData, &testData); err != nil {
-		log.Fatal().Msgf("Error unmarshalling JSON: %v", err)
+		return "", fmt.Errorf("error unmarshalling testbed: %w", err)
 	}
 	// Generate the binding content
 	bindingContent := generateBindingContent(testData)
 	// Marshal the binding content to text format
 	bindingText, err := prototext.Marshal(bindingContent)
 	if err != nil {
-		return "", fmt.Errorf("error writing to ondatra.binding: %w", err)
+		return "", fmt.Errorf("error generating ondatra binding: %w", err)
 	} else {
-		log.Info().Msg("ondatra.binding file created successfully.")
+		log.Info().Msg("ondatra binding generated successfully.")
 	}
 
 	log.Info().Interface("Ondatra binding data", string(bindingText)).Msg("Ondatra output")
@@ -0,0 +1,489 @@
+// Package file is an inventory backend reading the lab inventory from a local
+// YAML/JSON file, for labs without NetBox; reservation state is kept in a
+// JSON file next to the inventory file
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"keysight/laas/controller/config"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"keysight/laas/controller/internal/profile"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	// stateFileExt is appended to the inventory file path to get the path of
+	// the reservation state file
+	stateFileExt = ".state.json"
+	// reservation states, as NetBox spells them
+	stateReserved  = "Reserved"
+	stateAvailable = "Available"
+)
+
+var log = config.GetLogger("inventory")
+
+// Inventory is the content of an inventory file
+type Inventory struct {
+	Desc    string            `json:"desc"`
+	Devices map[string]Device `json:"devices"`
+	Links   []inven.DutLink   `json:"links"`
+}
+
+// Device is an inventory device, keyed by its name in the inventory file;
+// site, location, tenant, rack and tags are only used to scope the inventory
+type Device struct {
+	Vendor     string                 `json:"vendor"`
+	Model      string                 `json:"model"`
+	Role       string                 `json:"role"`
+	Platform   string                 `json:"platform"`
+	Image      string                 `json:"image"`
+	Site       string                 `json:"site"`
+	Location   string                 `json:"location"`
+	Tenant     string                 `json:"tenant"`
+	Rack       string                 `json:"rack"`
+	Tags       []string               `json:"tags"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Handles    []inven.Handles        `json:"handles"`
+	Ports      []Port                 `json:"ports"`
+}
+
+// Port is a port of an inventory device
+type Port struct {
+	Name        string                 `json:"name"`
+	Speed       string                 `json:"speed"`
+	Pmd         string                 `json:"pmd"`
+	Transceiver string                 `json:"transceiver"`
+	Attributes  map[string]interface{} `json:"attributes"`
+}
+
+// Reservation records the session an inventory object is reserved by
+type Reservation struct {
+	SessionID string            `json:"session_id"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// Provider serves the inventory of a file and keeps track of reservations;
+// objects are identified the way NetBox objects are identified by their url,
+// e.g. "file:device/dut1" or "file:port/dut1/eth1"
+type Provider struct {
+	path      string
+	statePath string
+	// scope limits the devices served
+	scope    inven.Scope
+	mu       sync.Mutex
+	reserved map[string]Reservation
+}
+
+// New returns a Provider for the inventory file at path, along with the
+// reservations persisted by a previous run
+func New(path string, scope inven.Scope) (*Provider, error) {
+	p := &Provider{path: path, statePath: path + stateFileExt, scope: scope, reserved: map[string]Reservation{}}
+	if _, err := p.load(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read inventory state %s: %w", p.statePath, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &p.reserved); err != nil {
+			return nil, fmt.Errorf("failed to parse inventory state %s: %w", p.statePath, err)
+		}
+	}
+	log.Info().Str("file", path).Int("Reserved", len(p.reserved)).Msg("Initialized file inventory")
+	return p, nil
+}
+
+func deviceURL(device string) string {
+	return "file:device/" + device
+}
+
+func portURL(device string, port string) string {
+	return "file:port/" + device + "/" + port
+}
+
+// listedRole reports whether devices of the role are part of the inventory
+// given to the controller, as with NetBox
+func listedRole(role string) bool {
+	switch strings.ToLower(role) {
+	case "dut", "ate", "tgen", "l1s":
+		return true
+	}
+	return false
+}
+
+// sharedRole reports whether devices of the role are shared between sessions,
+// in which case only their ports get reserved
+func sharedRole(role string) bool {
+	switch strings.ToLower(role) {
+	case "ate", "l1s":
+		return true
+	}
+	return false
+}
+
+// load reads and validates the inventory file; it's read on every use so
+// that edits are picked up without a restart
+func (p *Provider) load() (Inventory, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return Inventory{}, fmt.Errorf("failed to read inventory file %s: %w", p.path, err)
+	}
+	inventory := Inventory{}
+	if err := yaml.Unmarshal(data, &inventory); err != nil {
+		return Inventory{}, fmt.Errorf("failed to parse inventory file %s: %w", p.path, err)
+	}
+	for name, device := range inventory.Devices {
+		for _, port := range device.Ports {
+			if port.Name == "" {
+				return Inventory{}, fmt.Errorf("port without name on device %s in %s", name, p.path)
+			}
+		}
+	}
+	for _, link := range inventory.Links {
+		for _, endpoint := range []inven.DutLinkEndpoint{link.Src, link.Dst} {
+			if !inventory.hasPort(endpoint.Device, endpoint.Port) {
+				return Inventory{}, fmt.Errorf("link endpoint %s:%s not found in %s", endpoint.Device, endpoint.Port, p.path)
+			}
+		}
+	}
+	return inventory, nil
+}
+
+func (i Inventory) hasPort(device string, port string) bool {
+	for _, p := range i.Devices[device].Ports {
+		if p.Name == port {
+			return true
+		}
+	}
+	return false
+}
+
+// save persists the reservations; the caller must hold the lock
+func (p *Provider) save() error {
+	data, err := json.MarshalIndent(p.reserved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory state: %w", err)
+	}
+	// write to a temporary file first so that a crash never leaves a
+	// partially written state behind
+	tmpPath := p.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("failed to write inventory state: %w", err)
+	}
+	if err := os.Rename(tmpPath, p.statePath); err != nil {
+		return fmt.Errorf("failed to commit inventory state: %w", err)
+	}
+	return nil
+}
+
+// stateAttributes returns the attributes describing the reservation state of
+// an object, named like the NetBox custom fields
+func (p *Provider) stateAttributes(url string) map[string]interface{} {
+	reservation, ok := p.reserved[url]
+	if !ok {
+		return map[string]interface{}{"state": strings.ToLower(stateAvailable), "session_id": "null"}
+	}
+	attrs := map[string]interface{}{"state": strings.ToLower(stateReserved), "session_id": reservation.SessionID}
+	for key, value := range reservation.Metadata {
+		attrs[key] = value
+	}
+	return attrs
+}
+
+// Inventory returns the complete inventory along with the inventory available
+// for reservation, limited to the devices in scope
+func (p *Provider) Inventory(ctx context.Context, scope inven.Scope) (inven.Dut, inven.Dut, error) {
+	defer profile.LogFuncDuration(time.Now(), "Inventory", "", "inventory")
+
+	inventory, err := p.load()
+	if err != nil {
+		return inven.Dut{}, inven.Dut{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	global := inven.Dut{Name: "Inventory", Devices: map[string]inven.Device{}, Links: inventory.Links}
+	available := inven.Dut{Name: "Inventory", Devices: map[string]inven.Device{}, Links: inventory.Links}
+	for name, device := range inventory.Devices {
+		if !listedRole(device.Role) || !device.inScope(p.scope) || !device.inScope(scope) {
+			continue
+		}
+		global.Devices[name] = p.inventoryDevice(name, device)
+		if _, ok := p.reserved[deviceURL(name)]; !ok {
+			available.Devices[name] = global.Devices[name]
+		}
+	}
+	if global.Links == nil {
+		global.Links, available.Links = []inven.DutLink{}, []inven.DutLink{}
+	}
+	if !p.scope.IsZero() || !scope.IsZero() {
+		links := []inven.DutLink{}
+		for _, link := range global.Links {
+			if _, ok := global.Devices[link.Src.Device]; !ok {
+				continue
+			}
+			if _, ok := global.Devices[link.Dst.Device]; ok {
+				links = append(links, link)
+			}
+		}
+		global.Links, available.Links = links, links
+	}
+	return global, available, nil
+}
+
+// inScope reports whether the device is in scope
+func (d Device) inScope(scope inven.Scope) bool {
+	return scope.Match(d.Site, d.Location, d.Tenant, d.Rack, d.Tags)
+}
+
+// inventoryDevice returns the inventory device, in the format the NetBox
+// backend generates, along with its reservation state; the caller must hold
+// the lock
+func (p *Provider) inventoryDevice(name string, device Device) inven.Device {
+	attrs := map[string]interface{}{"address": "null"}
+	for key, value := range device.Attributes {
+		attrs[strings.ToLower(key)] = value
+	}
+	for key, value := range p.stateAttributes(deviceURL(name)) {
+		attrs[key] = value
+	}
+	attrs["devicetype"] = device.Role
+
+	ports := []interface{}{}
+	for _, port := range device.Ports {
+		portAttrs := map[string]interface{}{}
+		for key, value := range port.Attributes {
+			portAttrs[strings.ToLower(key)] = value
+		}
+		for key, value := range p.stateAttributes(portURL(name, port.Name)) {
+			portAttrs[key] = value
+		}
+		ports = append(ports, map[string]interface{}{
+			"id":          port.Name,
+			"name":        port.Name,
+			"speed":       orDefault(port.Speed, "SPEED_UNSPECIFIED"),
+			"pmd":         orDefault(port.Pmd, "PMD_UNSPECIFIED"),
+			"transceiver": strings.ToLower(orDefault(port.Transceiver, "null")),
+			"attributes":  portAttrs,
+		})
+	}
+	if len(ports) == 0 {
+		ports = append(ports, map[string]interface{}{})
+	}
+	handles := device.Handles
+	if handles == nil {
+		handles = []inven.Handles{{Connection: "null", Credential: "null", Name: "null", Via: "null"}}
+	}
+	return inven.Device{
+		ID:         name,
+		Name:       strings.ToLower(name),
+		Vendor:     strings.ToLower(orDefault(device.Vendor, "null")),
+		Model:      strings.ToLower(orDefault(device.Model, "null")),
+		Role:       device.Role,
+		Platform:   strings.ToLower(orDefault(device.Platform, "null")),
+		Image:      strings.ToLower(orDefault(device.Image, "null")),
+		Attributes: attrs,
+		Handles:    handles,
+		Ports:      ports,
+	}
+}
+
+func orDefault(value string, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// objects returns the urls of the devices and ports to update, skipping
+// shared devices and devices whose ports are updated only
+func objects(inventory Inventory, devices []inven.ReservedDevice) ([]string, error) {
+	urls := []string{}
+	for _, reserved := range devices {
+		device, ok := inventory.Devices[reserved.Name]
+		if !ok {
+			return nil, fmt.Errorf("failed to find the device: %v", reserved.Name)
+		}
+		if !reserved.PortsOnly && !sharedRole(device.Role) {
+			urls = append(urls, deviceURL(reserved.Name))
+		}
+		for _, port := range reserved.Ports {
+			if !inventory.hasPort(reserved.Name, port) {
+				return nil, fmt.Errorf("failed to find the port: %v:%v", reserved.Name, port)
+			}
+			urls = append(urls, portURL(reserved.Name, port))
+		}
+	}
+	return urls, nil
+}
+
+// customFields returns the reservation of an object the way NetBox custom
+// fields hold it; the caller must hold the lock
+func (p *Provider) customFields(url string) map[string]interface{} {
+	reservation, ok := p.reserved[url]
+	if !ok {
+		return map[string]interface{}{"state": stateAvailable, "session_id": ""}
+	}
+	fields := map[string]interface{}{"state": stateReserved, "session_id": reservation.SessionID}
+	for key, value := range reservation.Metadata {
+		fields[key] = value
+	}
+	return fields
+}
+
+// Reserve marks the devices and ports as reserved by userID, along with the
+// session metadata; every object updated, even if the update fails midway,
+// is appended to changes and to the release state of userID
+func (p *Provider) Reserve(ctx context.Context, userID string, metadata map[string]string, releaseState map[string][]map[string]interface{}, devices []inven.ReservedDevice, changes *[]inven.StateChange) error {
+	inventory, err := p.load()
+	if err != nil {
+		return err
+	}
+	urls, err := objects(inventory, devices)
+	if err != nil {
+		return err
+	}
+	reservation := Reservation{SessionID: userID, Metadata: map[string]string{}}
+	for _, field := range inven.MetadataFields {
+		if value := metadata[field]; value != "" {
+			reservation.Metadata[field] = value
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, url := range urls {
+		if current, ok := p.reserved[url]; ok {
+			if current.SessionID == userID {
+				continue
+			}
+			err = fmt.Errorf("failed to reserve %v, it's reserved by another session", url)
+			break
+		}
+		*changes = append(*changes, inven.StateChange{URL: url, CustomFields: p.customFields(url), SessionID: userID})
+		p.reserved[url] = reservation
+		releaseState[userID] = append(releaseState[userID], map[string]interface{}{
+			url: map[string]interface{}{"custom_fields": p.customFields(url)},
+		})
+	}
+	if saveErr := p.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// Release marks the devices and ports reserved by userID as available; every
+// object updated is appended to changes
+func (p *Provider) Release(userID string, devices []inven.ReservedDevice, changes *[]inven.StateChange) error {
+	inventory, err := p.load()
+	if err != nil {
+		return err
+	}
+	urls, err := objects(inventory, devices)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, url := range urls {
+		current, ok := p.reserved[url]
+		if !ok {
+			continue
+		}
+		if current.SessionID != userID {
+			err = fmt.Errorf("failed to release %v, it's reserved by another session", url)
+			break
+		}
+		*changes = append(*changes, inven.StateChange{URL: url, CustomFields: p.customFields(url), SessionID: userID})
+		delete(p.reserved, url)
+	}
+	if saveErr := p.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	if err == nil {
+		log.Info().Str("UserID", userID).Msg("Node/Interfaces details released")
+	}
+	return err
+}
+
+// ReleaseSession marks every object in the release state of userID as
+// available
+func (p *Provider) ReleaseSession(userID string, releaseState map[string][]map[string]interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, object := range releaseState[userID] {
+		for url := range object {
+			if current, ok := p.reserved[url]; ok && current.SessionID == userID {
+				delete(p.reserved, url)
+			}
+		}
+	}
+	return p.save()
+}
+
+// Restore puts an object back to the reservation state recorded in change,
+// unless another session reserved it since
+func (p *Provider) Restore(change inven.StateChange) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if current, ok := p.reserved[change.URL]; ok && change.SessionID != "" && current.SessionID != change.SessionID {
+		return nil
+	}
+
+	state, _ := change.CustomFields["state"].(string)
+	sessionID, _ := change.CustomFields["session_id"].(string)
+	if !strings.EqualFold(state, stateReserved) || sessionID == "" {
+		delete(p.reserved, change.URL)
+		return p.save()
+	}
+	reservation := Reservation{SessionID: sessionID, Metadata: map[string]string{}}
+	for _, field := range inven.MetadataFields {
+		if value, _ := change.CustomFields[field].(string); value != "" {
+			reservation.Metadata[field] = value
+		}
+	}
+	p.reserved[change.URL] = reservation
+	return p.save()
+}
+
+// Recheck returns a ReservedError if any of the devices and ports picked for
+// a reservation is reserved already
+func (p *Provider) Recheck(ctx context.Context, devices []inven.ReservedDevice) error {
+	inventory, err := p.load()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conflict := &inven.ReservedError{}
+	for _, reserved := range devices {
+		device, ok := inventory.Devices[reserved.Name]
+		if !ok {
+			return fmt.Errorf("failed to find the device: %v", reserved.Name)
+		}
+		if _, ok := p.reserved[deviceURL(reserved.Name)]; ok && !reserved.PortsOnly && !sharedRole(device.Role) {
+			conflict.Devices = append(conflict.Devices, reserved.Name)
+		}
+		for _, port := range reserved.Ports {
+			if _, ok := p.reserved[portURL(reserved.Name, port)]; ok {
+				conflict.Ports = append(conflict.Ports, reserved.Name+":"+port)
+			}
+		}
+	}
+	if len(conflict.Devices) != 0 || len(conflict.Ports) != 0 {
+		return conflict
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package file
+
+import (
+	"context"
+	"errors"
+	inven "keysight/laas/controller/internal/inventory/netbox"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testInventory = `
+desc: lab
+devices:
+  dut1:
+    vendor: Cisco
+    role: DUT
+    site: lab-a
+    ports:
+      - name: eth1
+        speed: S_100GB
+      - name: eth2
+  ate1:
+    role: ATE
+    site: lab-a
+    ports:
+      - name: p1
+      - name: p2
+  dut2:
+    role: DUT
+    site: lab-b
+    ports:
+      - name: eth1
+  server1:
+    role: Server
+links:
+  - src: {device: dut1, port: eth1}
+    dst: {device: ate1, port: p1}
+  - src: {device: dut2, port: eth1}
+    dst: {device: ate1, port: p2}
+`
+
+// writeInventory writes the inventory file into a temporary directory and
+// returns its path
+func writeInventory(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "inventory.yaml")
+	if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newProvider(t *testing.T) *Provider {
+	t.Helper()
+	p, err := New(writeInventory(t, testInventory), inven.Scope{})
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	return p
+}
+
+// deviceNames returns the sorted names of the devices of an inventory
+func deviceNames(dut inven.Dut) []string {
+	names := []string{}
+	for name := range dut.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// portState returns the reservation state and session of a port of an
+// inventory device
+func portState(t *testing.T, device inven.Device, name string) (string, string) {
+	t.Helper()
+	for _, port := range device.Ports {
+		port := port.(map[string]interface{})
+		if port["name"] == name {
+			attrs := port["attributes"].(map[string]interface{})
+			return attrs["state"].(string), attrs["session_id"].(string)
+		}
+	}
+	t.Fatalf("port %s not found on device %s", name, device.ID)
+	return "", ""
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		state   string
+		errText string
+	}{
+		{name: "YAML inventory", content: testInventory},
+		{name: "JSON inventory", content: `{"devices": {"dut1": {"role": "DUT", "ports": [{"name": "eth1"}]}}, "links": []}`},
+		{name: "unparsable inventory", content: "devices: [", errText: "failed to parse inventory file"},
+		{name: "port without name", content: "devices:\n  dut1:\n    ports:\n      - speed: S_10GB\n", errText: "port without name on device dut1"},
+		{
+			name:    "link to an unknown port",
+			content: "devices:\n  dut1:\n    ports:\n      - name: eth1\nlinks:\n  - src: {device: dut1, port: eth1}\n    dst: {device: ate1, port: p1}\n",
+			errText: "link endpoint ate1:p1 not found",
+		},
+		{name: "corrupt state", content: testInventory, state: "{", errText: "failed to parse inventory state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeInventory(t, tt.content)
+			if tt.state != "" {
+				if err := os.WriteFile(path+stateFileExt, []byte(tt.state), 0640); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err := New(path, inven.Scope{})
+			if tt.errText == "" {
+				if err != nil {
+					t.Errorf("New() = %v, want nil", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Errorf("New() = %v, want an error containing %q", err, tt.errText)
+			}
+		})
+	}
+
+	if _, err := New(filepath.Join(t.TempDir(), "missing.yaml"), inven.Scope{}); err == nil {
+		t.Error("New() of a missing file = nil, want an error")
+	}
+}
+
+func TestInventory(t *testing.T) {
+	tests := []struct {
+		name    string
+		scope   inven.Scope
+		devices []string
+		links   int
+	}{
+		{name: "every listed role", devices: []string{"ate1", "dut1", "dut2"}, links: 2},
+		{name: "scoped to a site", scope: inven.Scope{Sites: []string{"LAB-A"}}, devices: []string{"ate1", "dut1"}, links: 1},
+		{name: "scoped to an unknown site", scope: inven.Scope{Sites: []string{"lab-c"}}, devices: []string{}, links: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			global, _, err := newProvider(t).Inventory(context.Background(), tt.scope)
+			if err != nil {
+				t.Fatalf("Inventory() = %v", err)
+			}
+			if got := deviceNames(global); strings.Join(got, ",") != strings.Join(tt.devices, ",") {
+				t.Errorf("Inventory() devices = %v, want %v", got, tt.devices)
+			}
+			if len(global.Links) != tt.links {
+				t.Errorf("Inventory() links = %v, want %d", global.Links, tt.links)
+			}
+		})
+	}
+}
+
+func TestReserveRelease(t *testing.T) {
+	ctx := context.Background()
+	p := newProvider(t)
+	picked := []inven.ReservedDevice{
+		{Name: "dut1", Role: "DUT", Ports: []string{"eth1"}},
+		{Name: "ate1", Role: "ATE", Ports: []string{"p1"}},
+	}
+	releaseState := map[string][]map[string]interface{}{}
+	changes := []inven.StateChange{}
+	if err := p.Reserve(ctx, "s1", map[string]string{"owner": "alice"}, releaseState, picked, &changes); err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+	// the ATE is shared, only its port is reserved
+	if len(changes) != 3 || len(releaseState["s1"]) != 3 {
+		t.Errorf("Reserve() recorded %d changes and %d release states, want 3", len(changes), len(releaseState["s1"]))
+	}
+
+	global, available, err := p.Inventory(ctx, inven.Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := deviceNames(available); strings.Join(got, ",") != "ate1,dut2" {
+		t.Errorf("available devices = %v, want [ate1 dut2]", got)
+	}
+	if attrs := global.Devices["dut1"].Attributes; attrs["state"] != "reserved" || attrs["session_id"] != "s1" || attrs["owner"] != "alice" {
+		t.Errorf("dut1 attributes = %v, want reserved by s1 of alice", attrs)
+	}
+	if state, session := portState(t, global.Devices["ate1"], "p1"); state != "reserved" || session != "s1" {
+		t.Errorf("ate1:p1 = %s by %s, want reserved by s1", state, session)
+	}
+	if state, _ := portState(t, global.Devices["ate1"], "p2"); state != "available" {
+		t.Errorf("ate1:p2 = %s, want available", state)
+	}
+
+	// another session can neither reserve nor release the same objects
+	otherChanges := []inven.StateChange{}
+	if err := p.Reserve(ctx, "s2", nil, map[string][]map[string]interface{}{}, picked[1:], &otherChanges); err == nil {
+		t.Error("Reserve() of a port reserved by another session = nil, want an error")
+	}
+	var conflict *inven.ReservedError
+	if err := p.Recheck(ctx, picked); !errors.As(err, &conflict) || len(conflict.Devices) != 1 || len(conflict.Ports) != 2 {
+		t.Errorf("Recheck() = %v, want dut1, dut1:eth1 and ate1:p1 reserved", err)
+	}
+	if err := p.Release("s2", picked, &otherChanges); err == nil {
+		t.Error("Release() of objects reserved by another session = nil, want an error")
+	}
+
+	releaseChanges := []inven.StateChange{}
+	if err := p.Release("s1", picked, &releaseChanges); err != nil {
+		t.Fatalf("Release() = %v", err)
+	}
+	if len(releaseChanges) != 3 {
+		t.Errorf("Release() recorded %d changes, want 3", len(releaseChanges))
+	}
+	if _, available, _ := p.Inventory(ctx, inven.Scope{}); len(available.Devices) != 3 {
+		t.Errorf("available devices after release = %v, want all 3", deviceNames(available))
+	}
+	if err := p.Recheck(ctx, picked); err != nil {
+		t.Errorf("Recheck() after release = %v, want nil", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	p := newProvider(t)
+	picked := []inven.ReservedDevice{{Name: "dut1", Role: "DUT", Ports: []string{"eth1", "eth2"}}}
+	changes := []inven.StateChange{}
+	if err := p.Reserve(ctx, "s1", nil, map[string][]map[string]interface{}{}, picked, &changes); err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+
+	// eth2 got released and reserved by another session in between
+	delete(p.reserved, portURL("dut1", "eth2"))
+	if err := p.Reserve(ctx, "s2", nil, map[string][]map[string]interface{}{}, []inven.ReservedDevice{{Name: "dut1", PortsOnly: true, Ports: []string{"eth2"}}}, &[]inven.StateChange{}); err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		if err := p.Restore(changes[i]); err != nil {
+			t.Fatalf("Restore() = %v", err)
+		}
+	}
+	if _, ok := p.reserved[deviceURL("dut1")]; ok {
+		t.Error("dut1 still reserved after restore")
+	}
+	if _, ok := p.reserved[portURL("dut1", "eth1")]; ok {
+		t.Error("dut1:eth1 still reserved after restore")
+	}
+	if reservation := p.reserved[portURL("dut1", "eth2")]; reservation.SessionID != "s2" {
+		t.Errorf("dut1:eth2 reserved by %q after restore, want s2", reservation.SessionID)
+	}
+}
+
+func TestStatePersisted(t *testing.T) {
+	ctx := context.Background()
+	path := writeInventory(t, testInventory)
+	p, err := New(path, inven.Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseState := map[string][]map[string]interface{}{}
+	picked := []inven.ReservedDevice{{Name: "dut1", Role: "DUT", Ports: []string{"eth1"}}, {Name: "dut2", Role: "DUT"}}
+	if err := p.Reserve(ctx, "s1", map[string]string{"team": "qa"}, releaseState, picked, &[]inven.StateChange{}); err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+
+	// a restarted controller picks up the reservations
+	restarted, err := New(path, inven.Scope{})
+	if err != nil {
+		t.Fatalf("New() after restart = %v", err)
+	}
+	if len(restarted.reserved) != 3 {
+		t.Fatalf("reservations after restart = %v, want 3", restarted.reserved)
+	}
+	if reservation := restarted.reserved[deviceURL("dut1")]; reservation.SessionID != "s1" || reservation.Metadata["team"] != "qa" {
+		t.Errorf("dut1 reservation after restart = %+v, want s1 of team qa", reservation)
+	}
+	if _, err := os.Stat(path + stateFileExt + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary state file left behind: %v", err)
+	}
+
+	if err := restarted.ReleaseSession("s1", releaseState); err != nil {
+		t.Fatalf("ReleaseSession() = %v", err)
+	}
+	reloaded, err := New(path, inven.Scope{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.reserved) != 0 {
+		t.Errorf("reservations after release = %v, want none", reloaded.reserved)
+	}
+}
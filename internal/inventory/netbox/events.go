@@ -0,0 +1,202 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"keysight/laas/controller/internal/netbox"
+	"time"
+)
+
+// Event is a NetBox webhook event; Data holds the object as serialized by
+// the NetBox REST API
+type Event struct {
+	Event     string          `json:"event"`
+	Model     string          `json:"model"`
+	Timestamp string          `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// kinds of webhook events
+const (
+	eventCreated = "created"
+	eventUpdated = "updated"
+	eventDeleted = "deleted"
+)
+
+// ApplyEvent updates the snapshot with a device, interface or cable event;
+// events about other objects are ignored. Webhooks may arrive late or out of
+// order, so device and interface events older than the object in the
+// snapshot, or about objects deleted since, are dropped
+func (s *Snapshot) ApplyEvent(event Event) error {
+	switch event.Event {
+	case eventCreated, eventUpdated, eventDeleted:
+	default:
+		return fmt.Errorf("unsupported event: %s", event.Event)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	switch event.Model {
+	case "device":
+		device := netbox.Device{}
+		if err = json.Unmarshal(event.Data, &device); err == nil {
+			s.applyDevice(event.Event, device)
+		}
+	case "interface":
+		iface := netbox.Interface{}
+		if err = json.Unmarshal(event.Data, &iface); err == nil {
+			s.applyInterface(event.Event, iface)
+		}
+	case "cable":
+		cable := netbox.Cable{}
+		if err = json.Unmarshal(event.Data, &cable); err == nil {
+			s.applyCable(event.Event, cable)
+		}
+	default:
+		log.Debug().Str("Model", event.Model).Str("Event", event.Event).Msg("Ignored NetBox event")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %s of %s event: %w", event.Model, event.Event, err)
+	}
+	log.Info().Str("Model", event.Model).Str("Event", event.Event).Msg("Applied NetBox event")
+	return nil
+}
+
+// stale reports whether an event about an object last updated at
+// lastUpdated is older than the object as held by the snapshot; objects
+// whose update time is unknown are never stale
+func stale(lastUpdated string, held string) bool {
+	updated, err := time.Parse(time.RFC3339Nano, lastUpdated)
+	if err != nil {
+		return false
+	}
+	heldUpdated, err := time.Parse(time.RFC3339Nano, held)
+	if err != nil {
+		return false
+	}
+	return updated.Before(heldUpdated)
+}
+
+// applyDevice updates a device along with the references to it held by
+// interfaces; the caller must hold the lock
+func (s *Snapshot) applyDevice(kind string, device netbox.Device) {
+	if kind != eventDeleted {
+		if s.deletedDevices[device.ID] {
+			log.Debug().Int("ID", device.ID).Msg("Dropped event about deleted device")
+			return
+		}
+		if held, ok := s.devices[device.ID]; ok && stale(device.LastUpdated, held.LastUpdated) {
+			log.Debug().Int("ID", device.ID).Str("LastUpdated", device.LastUpdated).Msg("Dropped stale device event")
+			return
+		}
+	}
+	if kind == eventDeleted {
+		s.deletedDevices[device.ID] = true
+	}
+	// devices moved out of scope are dropped as NetBox doesn't list them
+	if kind == eventDeleted || !s.scope.matchDevice(device) {
+		delete(s.devices, device.ID)
+		for id, iface := range s.interfaces {
+			if iface.Device.ID == device.ID {
+				s.dropInterface(id)
+			}
+		}
+		return
+	}
+	s.devices[device.ID] = device
+	// objects are copied rather than modified in place, they may be in use
+	// outside the lock
+	for id, iface := range s.interfaces {
+		if iface.Device.ID == device.ID {
+			iface.Device.Name = device.Name
+		}
+		peers := make([]netbox.LinkPeer, len(iface.LinkPeers))
+		for i, peer := range iface.LinkPeers {
+			if peer.Device != nil && peer.Device.ID == device.ID {
+				nested := *peer.Device
+				nested.Name = device.Name
+				peer.Device = &nested
+			}
+			peers[i] = peer
+		}
+		iface.LinkPeers = peers
+		s.interfaces[id] = iface
+	}
+}
+
+// applyInterface updates an interface; the caller must hold the lock
+func (s *Snapshot) applyInterface(kind string, iface netbox.Interface) {
+	if kind == eventDeleted {
+		s.deletedInterfaces[iface.ID] = true
+		s.dropInterface(iface.ID)
+		return
+	}
+	if s.deletedInterfaces[iface.ID] {
+		log.Debug().Int("ID", iface.ID).Msg("Dropped event about deleted interface")
+		return
+	}
+	if held, ok := s.interfaces[iface.ID]; ok && stale(iface.LastUpdated, held.LastUpdated) {
+		log.Debug().Int("ID", iface.ID).Str("LastUpdated", iface.LastUpdated).Msg("Dropped stale interface event")
+		return
+	}
+	s.interfaces[iface.ID] = iface
+}
+
+// dropInterface removes an interface along with the links to it; the caller
+// must hold the lock
+func (s *Snapshot) dropInterface(id int) {
+	delete(s.interfaces, id)
+	for otherID, other := range s.interfaces {
+		peers := []netbox.LinkPeer{}
+		for _, peer := range other.LinkPeers {
+			if peer.ID != id {
+				peers = append(peers, peer)
+			}
+		}
+		if len(peers) != len(other.LinkPeers) {
+			other.LinkPeers = peers
+			s.interfaces[otherID] = other
+		}
+	}
+}
+
+// applyCable updates the link peers of the interfaces the cable is attached
+// to; the caller must hold the lock
+func (s *Snapshot) applyCable(kind string, cable netbox.Cable) {
+	// detach the cable first, its terminations may have changed
+	for id, iface := range s.interfaces {
+		if iface.Cable != nil && iface.Cable.ID == cable.ID {
+			iface.Cable = nil
+			iface.LinkPeers = nil
+			s.interfaces[id] = iface
+		}
+	}
+	if kind == eventDeleted {
+		return
+	}
+	s.attachCable(cable, cable.ATerminations, cable.BTerminations)
+	s.attachCable(cable, cable.BTerminations, cable.ATerminations)
+}
+
+// attachCable makes the far end terminations of the cable link peers of the
+// near end interfaces; the caller must hold the lock
+func (s *Snapshot) attachCable(cable netbox.Cable, near []netbox.CableTermination, far []netbox.CableTermination) {
+	peers := []netbox.LinkPeer{}
+	for _, termination := range far {
+		peers = append(peers, termination.Object)
+	}
+	for _, termination := range near {
+		if termination.ObjectType != "dcim.interface" {
+			continue
+		}
+		iface, ok := s.interfaces[termination.ObjectID]
+		if !ok {
+			continue
+		}
+		iface.Cable = &netbox.NestedObject{ID: cable.ID, URL: cable.URL}
+		iface.LinkPeers = peers
+		s.interfaces[iface.ID] = iface
+	}
+}
@@ -0,0 +1,246 @@
+package inventory
+
+import (
+	"encoding/json"
+	"keysight/laas/controller/internal/netbox"
+	"testing"
+)
+
+// newTestSnapshot returns a snapshot holding a DUT and an ATE, each with one
+// interface, linked by cable 10
+func newTestSnapshot() *Snapshot {
+	s := NewSnapshot("http://netbox.invalid/api/", "token", Scope{})
+	s.devices[1] = netbox.Device{ID: 1, Name: "dut1", LastUpdated: "2026-01-01T10:00:00Z"}
+	s.devices[2] = netbox.Device{ID: 2, Name: "ate1", LastUpdated: "2026-01-01T10:00:00Z"}
+	s.interfaces[11] = netbox.Interface{
+		ID: 11, Name: "eth1", Device: netbox.NestedDevice{ID: 1, Name: "dut1"},
+		Cable:       &netbox.NestedObject{ID: 10},
+		LinkPeers:   []netbox.LinkPeer{{ID: 21, Name: "p1", Device: &netbox.NestedDevice{ID: 2, Name: "ate1"}}},
+		LastUpdated: "2026-01-01T10:00:00Z",
+	}
+	s.interfaces[21] = netbox.Interface{
+		ID: 21, Name: "p1", Device: netbox.NestedDevice{ID: 2, Name: "ate1"},
+		Cable:       &netbox.NestedObject{ID: 10},
+		LinkPeers:   []netbox.LinkPeer{{ID: 11, Name: "eth1", Device: &netbox.NestedDevice{ID: 1, Name: "dut1"}}},
+		LastUpdated: "2026-01-01T10:00:00Z",
+	}
+	return s
+}
+
+// newEvent returns a webhook event about the object given in JSON format
+func newEvent(t *testing.T, kind string, model string, data string) Event {
+	t.Helper()
+	if !json.Valid([]byte(data)) {
+		t.Fatalf("invalid event data: %s", data)
+	}
+	return Event{Event: kind, Model: model, Data: json.RawMessage(data)}
+}
+
+// peerNames returns the "device:port" names of the link peers of an interface
+func peerNames(iface netbox.Interface) []string {
+	names := []string{}
+	for _, peer := range iface.LinkPeers {
+		device := ""
+		if peer.Device != nil {
+			device = peer.Device.Name
+		}
+		names = append(names, device+":"+peer.Name)
+	}
+	return names
+}
+
+func TestApplyEventDevices(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		check  func(t *testing.T, s *Snapshot)
+	}{
+		{
+			name:   "created",
+			events: []Event{newEvent(t, "created", "device", `{"id": 3, "name": "dut2", "last_updated": "2026-01-01T11:00:00Z"}`)},
+			check: func(t *testing.T, s *Snapshot) {
+				if s.devices[3].Name != "dut2" {
+					t.Errorf("device 3 = %+v, want dut2", s.devices[3])
+				}
+			},
+		},
+		{
+			name: "updated, renamed along with interfaces and link peers",
+			events: []Event{newEvent(t, "updated", "device",
+				`{"id": 1, "name": "dut9", "custom_fields": {"state": "Reserved", "session_id": "s1"}, "last_updated": "2026-01-01T11:00:00Z"}`)},
+			check: func(t *testing.T, s *Snapshot) {
+				if device := s.devices[1]; device.Name != "dut9" || device.Fields.SessionID != "s1" || !device.Fields.Reserved() {
+					t.Errorf("device 1 = %+v, want dut9 reserved by s1", device)
+				}
+				if name := s.interfaces[11].Device.Name; name != "dut9" {
+					t.Errorf("device of interface 11 = %s, want dut9", name)
+				}
+				if peers := peerNames(s.interfaces[21]); len(peers) != 1 || peers[0] != "dut9:eth1" {
+					t.Errorf("peers of interface 21 = %v, want [dut9:eth1]", peers)
+				}
+			},
+		},
+		{
+			name: "stale update dropped",
+			events: []Event{
+				newEvent(t, "updated", "device", `{"id": 1, "name": "dut1", "custom_fields": {"state": "Reserved", "session_id": "s1"}, "last_updated": "2026-01-01T12:00:00Z"}`),
+				newEvent(t, "updated", "device", `{"id": 1, "name": "dut1", "custom_fields": {"state": "Available", "session_id": ""}, "last_updated": "2026-01-01T11:00:00Z"}`),
+			},
+			check: func(t *testing.T, s *Snapshot) {
+				if device := s.devices[1]; !device.Fields.Reserved() || device.LastUpdated != "2026-01-01T12:00:00Z" {
+					t.Errorf("device 1 = %+v, want the newer reserved state", device)
+				}
+			},
+		},
+		{
+			name: "deleted along with interfaces and links",
+			events: []Event{
+				newEvent(t, "deleted", "device", `{"id": 2, "name": "ate1", "last_updated": "2026-01-01T11:00:00Z"}`),
+				// late update about the deleted device
+				newEvent(t, "updated", "device", `{"id": 2, "name": "ate1", "last_updated": "2026-01-01T10:30:00Z"}`),
+			},
+			check: func(t *testing.T, s *Snapshot) {
+				if _, ok := s.devices[2]; ok {
+					t.Error("deleted device 2 is still in the snapshot")
+				}
+				if _, ok := s.interfaces[21]; ok {
+					t.Error("interface 21 of deleted device 2 is still in the snapshot")
+				}
+				if peers := s.interfaces[11].LinkPeers; len(peers) != 0 {
+					t.Errorf("peers of interface 11 = %v, want none", peers)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSnapshot()
+			for _, event := range tt.events {
+				if err := s.ApplyEvent(event); err != nil {
+					t.Fatalf("ApplyEvent() = %v", err)
+				}
+			}
+			tt.check(t, s)
+		})
+	}
+}
+
+func TestApplyEventInterfaces(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		check  func(t *testing.T, s *Snapshot)
+	}{
+		{
+			name:   "created",
+			events: []Event{newEvent(t, "created", "interface", `{"id": 12, "name": "eth2", "device": {"id": 1, "name": "dut1"}, "speed": 100000000, "last_updated": "2026-01-01T11:00:00Z"}`)},
+			check: func(t *testing.T, s *Snapshot) {
+				if iface := s.interfaces[12]; iface.Name != "eth2" || iface.Speed == nil || *iface.Speed != 100000000 {
+					t.Errorf("interface 12 = %+v, want eth2 at 100G", iface)
+				}
+			},
+		},
+		{
+			name: "updated",
+			events: []Event{newEvent(t, "updated", "interface",
+				`{"id": 11, "name": "eth1", "device": {"id": 1, "name": "dut1"}, "custom_fields": {"state": "Reserved", "session_id": "s1"}, "last_updated": "2026-01-01T11:00:00Z"}`)},
+			check: func(t *testing.T, s *Snapshot) {
+				if iface := s.interfaces[11]; !iface.Fields.Reserved() || iface.Fields.SessionID != "s1" {
+					t.Errorf("interface 11 = %+v, want reserved by s1", iface)
+				}
+			},
+		},
+		{
+			name: "stale update dropped",
+			events: []Event{newEvent(t, "updated", "interface",
+				`{"id": 11, "name": "eth1", "device": {"id": 1, "name": "dut1"}, "custom_fields": {"state": "Reserved", "session_id": "s1"}, "last_updated": "2026-01-01T09:00:00Z"}`)},
+			check: func(t *testing.T, s *Snapshot) {
+				if iface := s.interfaces[11]; iface.Fields.Reserved() {
+					t.Errorf("interface 11 = %+v, want the newer available state", iface)
+				}
+			},
+		},
+		{
+			name: "deleted along with links",
+			events: []Event{
+				newEvent(t, "deleted", "interface", `{"id": 21, "name": "p1", "device": {"id": 2, "name": "ate1"}, "last_updated": "2026-01-01T11:00:00Z"}`),
+				newEvent(t, "updated", "interface", `{"id": 21, "name": "p1", "device": {"id": 2, "name": "ate1"}, "last_updated": "2026-01-01T10:30:00Z"}`),
+			},
+			check: func(t *testing.T, s *Snapshot) {
+				if _, ok := s.interfaces[21]; ok {
+					t.Error("deleted interface 21 is still in the snapshot")
+				}
+				if peers := s.interfaces[11].LinkPeers; len(peers) != 0 {
+					t.Errorf("peers of interface 11 = %v, want none", peers)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSnapshot()
+			for _, event := range tt.events {
+				if err := s.ApplyEvent(event); err != nil {
+					t.Fatalf("ApplyEvent() = %v", err)
+				}
+			}
+			tt.check(t, s)
+		})
+	}
+}
+
+func TestApplyEventCables(t *testing.T) {
+	const moved = `{"id": 10, "a_terminations": [{"object_type": "dcim.interface", "object_id": 11, "object": {"id": 11, "name": "eth1", "device": {"id": 1, "name": "dut1"}}}],
+		"b_terminations": [{"object_type": "dcim.interface", "object_id": 22, "object": {"id": 22, "name": "p2", "device": {"id": 2, "name": "ate1"}}}]}`
+	tests := []struct {
+		name  string
+		event Event
+		peers map[int][]string
+	}{
+		{
+			name: "created",
+			event: newEvent(t, "created", "cable", `{"id": 20, "a_terminations": [{"object_type": "dcim.interface", "object_id": 22, "object": {"id": 22, "name": "p2", "device": {"id": 2, "name": "ate1"}}}],
+				"b_terminations": [{"object_type": "dcim.interface", "object_id": 23, "object": {"id": 23, "name": "p3", "device": {"id": 2, "name": "ate1"}}}]}`),
+			peers: map[int][]string{11: {"ate1:p1"}, 21: {"dut1:eth1"}, 22: {"ate1:p3"}, 23: {"ate1:p2"}},
+		},
+		{
+			name:  "updated, moved to another interface",
+			event: newEvent(t, "updated", "cable", moved),
+			peers: map[int][]string{11: {"ate1:p2"}, 21: {}, 22: {"dut1:eth1"}, 23: {}},
+		},
+		{
+			name:  "deleted",
+			event: newEvent(t, "deleted", "cable", `{"id": 10}`),
+			peers: map[int][]string{11: {}, 21: {}, 22: {}, 23: {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSnapshot()
+			s.interfaces[22] = netbox.Interface{ID: 22, Name: "p2", Device: netbox.NestedDevice{ID: 2, Name: "ate1"}}
+			s.interfaces[23] = netbox.Interface{ID: 23, Name: "p3", Device: netbox.NestedDevice{ID: 2, Name: "ate1"}}
+			if err := s.ApplyEvent(tt.event); err != nil {
+				t.Fatalf("ApplyEvent() = %v", err)
+			}
+			for id, want := range tt.peers {
+				got := peerNames(s.interfaces[id])
+				if len(got) != len(want) || (len(want) != 0 && got[0] != want[0]) {
+					t.Errorf("peers of interface %d = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyEventInvalid(t *testing.T) {
+	s := newTestSnapshot()
+	if err := s.ApplyEvent(newEvent(t, "renamed", "device", `{"id": 1}`)); err == nil {
+		t.Error("ApplyEvent() of an unsupported event = nil, want an error")
+	}
+	if err := s.ApplyEvent(newEvent(t, "updated", "device", `{"id": "one"}`)); err == nil {
+		t.Error("ApplyEvent() of an undecodable device = nil, want an error")
+	}
+	if err := s.ApplyEvent(newEvent(t, "updated", "site", `{"id": 1}`)); err != nil {
+		t.Errorf("ApplyEvent() of another model = %v, want nil", err)
+	}
+}
@@ -0,0 +1,205 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"keysight/laas/controller/config"
+	"keysight/laas/controller/internal/netbox"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sessionTagSlug returns the slug of the tag held by the objects reserved by
+// userID
+func sessionTagSlug(userID string) string {
+	return "laas-session-" + userID
+}
+
+// sessionTag returns the tag of the session, creating it unless create is
+// false; nil is returned if session tags are disabled, or the tag doesn't
+// exist and create is false
+func sessionTag(ctx context.Context, client *netbox.Client, userID string, metadata map[string]string, create bool) (*netbox.Tag, error) {
+	if !*config.Config.NetboxSessionTags {
+		return nil, nil
+	}
+	slug := sessionTagSlug(userID)
+	tag, err := client.TagBySlug(ctx, slug)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag %v: %w", slug, err)
+	}
+	if tag != nil || !create {
+		return tag, nil
+	}
+	description := "Reserved by session " + userID
+	if owner := metadata["owner"]; owner != "" {
+		description += " of " + owner
+	}
+	tag, err = client.CreateTag(ctx, netbox.Tag{Name: slug, Slug: slug, Description: description})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tag %v: %w", slug, err)
+	}
+	return tag, nil
+}
+
+// tagIDs returns the IDs of the tags
+func tagIDs(tags []netbox.Tag) []int {
+	ids := make([]int, 0, len(tags))
+	for _, tag := range tags {
+		ids = append(ids, tag.ID)
+	}
+	return ids
+}
+
+// tagRefs returns the tags with the given IDs the way they're patched
+func tagRefs(ids []int) []map[string]interface{} {
+	refs := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, map[string]interface{}{"id": id})
+	}
+	return refs
+}
+
+// retag returns the IDs of the tags with the session tag added, or removed
+// when release is set
+func retag(tags []netbox.Tag, tag netbox.Tag, release bool) []int {
+	ids := []int{}
+	for _, id := range tagIDs(tags) {
+		if id != tag.ID {
+			ids = append(ids, id)
+		}
+	}
+	if !release {
+		ids = append(ids, tag.ID)
+	}
+	return ids
+}
+
+// deleteSessionTag deletes the tag of the session, which removes it from
+// every object holding it
+func deleteSessionTag(ctx context.Context, client *netbox.Client, userID string) error {
+	tag, err := sessionTag(ctx, client, userID, nil, false)
+	if err != nil || tag == nil {
+		return err
+	}
+	if err := client.Delete(ctx, tag.URL); err != nil {
+		return fmt.Errorf("failed to delete tag %v: %w", tag.Slug, err)
+	}
+	return nil
+}
+
+// journalDevice is a device along with the names of its ports a reserve or
+// release event is journaled for
+type journalDevice struct {
+	id    int
+	name  string
+	ports []string
+}
+
+// journal adds an entry describing a reserve or release event by userID to
+// each device, if enabled; the journal is informative only, so failures are
+// logged rather than returned
+func journal(ctx context.Context, client *netbox.Client, userID string, metadata map[string]string, devices []journalDevice, release bool) {
+	if !*config.Config.NetboxJournal {
+		return
+	}
+	for _, device := range devices {
+		entry := netbox.JournalEntry{
+			AssignedObjectType: "dcim.device",
+			AssignedObjectID:   device.id,
+			Kind:               "info",
+			Comments:           journalComments(userID, metadata, device.ports, release),
+		}
+		if release {
+			entry.Kind = "success"
+		}
+		if err := client.CreateJournalEntry(ctx, entry); err != nil {
+			log.Error().Err(err).Str("UserID", userID).Str("Device", device.name).Msg("Failed to add journal entry")
+		}
+	}
+}
+
+// journalComments describes a reserve or release event in markdown, as
+// journal entries are rendered
+func journalComments(userID string, metadata map[string]string, ports []string, release bool) string {
+	action := "Reserved"
+	if release {
+		action = "Released"
+	}
+	lines := []string{fmt.Sprintf("%s by session `%s` at %s", action, userID, time.Now().UTC().Format(time.RFC3339))}
+	for _, field := range MetadataFields {
+		if value := metadata[field]; value != "" {
+			lines = append(lines, fmt.Sprintf("- %s: %s", field, value))
+		}
+	}
+	if len(ports) != 0 {
+		sort.Strings(ports)
+		lines = append(lines, "- ports: "+strings.Join(ports, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// journalTargets groups the devices and ports updated by device, for the
+// journal
+func journalTargets(targets []claimTarget, deviceByName map[string]netbox.Device) []journalDevice {
+	devices := []journalDevice{}
+	index := map[string]int{}
+	for _, target := range targets {
+		name, port, _ := strings.Cut(target.name, ":")
+		i, ok := index[name]
+		if !ok {
+			device, found := deviceByName[strings.ToLower(name)]
+			if !found {
+				continue
+			}
+			i = len(devices)
+			index[name] = i
+			devices = append(devices, journalDevice{id: device.ID, name: name})
+		}
+		if target.port {
+			devices[i].ports = append(devices[i].ports, port)
+		}
+	}
+	return devices
+}
+
+// journalReleased groups the objects at the given urls, released from the
+// release state of a session, by device for the journal; interfaces are
+// fetched to find their device
+func journalReleased(ctx context.Context, client *netbox.Client, urls []string) []journalDevice {
+	if !*config.Config.NetboxJournal {
+		return nil
+	}
+	devices := []journalDevice{}
+	index := map[int]int{}
+	add := func(id int, name string, port string) {
+		i, ok := index[id]
+		if !ok {
+			i = len(devices)
+			index[id] = i
+			devices = append(devices, journalDevice{id: id, name: name})
+		}
+		if port != "" {
+			devices[i].ports = append(devices[i].ports, port)
+		}
+	}
+	for _, url := range urls {
+		switch {
+		case strings.Contains(url, "/dcim/devices/"):
+			device := netbox.Device{}
+			if err := client.Get(ctx, url, &device); err != nil {
+				log.Error().Err(err).Str("URL", url).Msg("Failed to get device for journal entry")
+				continue
+			}
+			add(device.ID, device.Name, "")
+		case strings.Contains(url, "/dcim/interfaces/"):
+			iface := netbox.Interface{}
+			if err := client.Get(ctx, url, &iface); err != nil {
+				log.Error().Err(err).Str("URL", url).Msg("Failed to get interface for journal entry")
+				continue
+			}
+			add(iface.Device.ID, iface.Device.Name, iface.Name)
+		}
+	}
+	return devices
+}
@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"keysight/laas/controller/internal/netbox"
+	"keysight/laas/controller/internal/profile"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Severities of lint issues: errors break reservations involving the object,
+// warnings leave it out of the inventory or are likely mistakes
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintIssue is a problem found in the inventory data; Object is a device, a
+// port in "device:port" format or a link in "device:port - device:port" format
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Object   string `json:"object"`
+	Message  string `json:"message"`
+}
+
+// inventoryRoles are the device roles the inventory is built from
+var inventoryRoles = []string{"dut", "ate", "tgen", "l1s"}
+
+// Lint checks the NetBox devices in scope, and their interfaces, against
+// what the inventory is built from, refreshing the snapshot first if it's
+// older than maxAge
+func (s *Snapshot) Lint(ctx context.Context, maxAge time.Duration, scope Scope) ([]LintIssue, error) {
+	defer profile.LogFuncDuration(time.Now(), "Lint", "", "inventory")
+	if s.Age() > maxAge {
+		if err := s.Refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	devices := []netbox.Device{}
+	ids := map[int]bool{}
+	for _, device := range s.devices {
+		if s.scope.matchDevice(device) && scope.matchDevice(device) {
+			devices = append(devices, device)
+			ids[device.ID] = true
+		}
+	}
+	interfaces := []netbox.Interface{}
+	for _, iface := range s.interfaces {
+		if ids[iface.Device.ID] {
+			interfaces = append(interfaces, iface)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	sort.Slice(interfaces, func(i, j int) bool { return interfaces[i].ID < interfaces[j].ID })
+	return lint(devices, interfaces), nil
+}
+
+// lint returns the problems of the NetBox devices and interfaces which are
+// lost or hidden when the inventory is built from them
+func lint(devices []netbox.Device, interfaces []netbox.Interface) []LintIssue {
+	issues := []LintIssue{}
+	interfaceCount := map[int]int{}
+	for _, iface := range interfaces {
+		interfaceCount[iface.Device.ID]++
+	}
+	for _, device := range devices {
+		role := device.RoleName()
+		if !contains(inventoryRoles, strings.ToLower(role)) {
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Rule:     "unsupported-role",
+				Object:   device.Name,
+				Message:  fmt.Sprintf("role %q is not one of %v, the device is left out of the inventory", role, inventoryRoles),
+			})
+			continue
+		}
+		switch {
+		case device.InterfaceCount > 0 && interfaceCount[device.ID] == 0:
+			issues = append(issues, LintIssue{
+				Severity: LintError,
+				Rule:     "interfaces-missing",
+				Object:   device.Name,
+				Message:  fmt.Sprintf("interface_count is %d but no interfaces were returned", device.InterfaceCount),
+			})
+		case interfaceCount[device.ID] == 0:
+			issues = append(issues, LintIssue{
+				Severity: LintWarning,
+				Rule:     "no-interfaces",
+				Object:   device.Name,
+				Message:  "device has no interfaces",
+			})
+		}
+	}
+	for _, iface := range interfaces {
+		name := iface.Device.Name + ":" + iface.Name
+		if iface.Speed != nil {
+			if _, ok := speeds[*iface.Speed]; !ok {
+				issues = append(issues, LintIssue{
+					Severity: LintError,
+					Rule:     "speed-unmapped",
+					Object:   name,
+					Message:  fmt.Sprintf("speed %d Kbps has no S_xGB name, the inventory can't be converted", *iface.Speed),
+				})
+			}
+		}
+		for _, peer := range iface.LinkPeers {
+			if peer.Device == nil {
+				issues = append(issues, LintIssue{
+					Severity: LintWarning,
+					Rule:     "cable-non-device-peer",
+					Object:   name,
+					Message:  fmt.Sprintf("cable goes to %q which isn't a device interface, the link is left out of the inventory", peer.Name),
+				})
+			}
+		}
+	}
+	return issues
+}
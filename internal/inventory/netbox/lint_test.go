@@ -0,0 +1,72 @@
+package inventory
+
+import (
+	"keysight/laas/controller/internal/netbox"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	role := func(name string) *netbox.NestedObject { return &netbox.NestedObject{Name: name} }
+	speed := func(kbps int) *int { return &kbps }
+	device := func(id int, name string, r string, interfaceCount int) netbox.Device {
+		return netbox.Device{ID: id, Name: name, Role: role(r), InterfaceCount: interfaceCount}
+	}
+	iface := func(deviceID int, device string, name string, kbps *int, peers ...netbox.LinkPeer) netbox.Interface {
+		return netbox.Interface{Name: name, Device: netbox.NestedDevice{ID: deviceID, Name: device}, Speed: kbps, LinkPeers: peers}
+	}
+
+	tests := []struct {
+		name       string
+		devices    []netbox.Device
+		interfaces []netbox.Interface
+		want       []LintIssue
+	}{
+		{
+			name:       "consistent devices",
+			devices:    []netbox.Device{device(1, "dut1", "DUT", 1)},
+			interfaces: []netbox.Interface{iface(1, "dut1", "eth1", speed(100000000), netbox.LinkPeer{Name: "p1", Device: &netbox.NestedDevice{Name: "ate1"}})},
+		},
+		{
+			name:    "interfaces counted but not returned",
+			devices: []netbox.Device{device(1, "dut1", "DUT", 4), device(2, "dut2", "DUT", 0)},
+			want: []LintIssue{
+				{Severity: LintError, Rule: "interfaces-missing", Object: "dut1"},
+				{Severity: LintWarning, Rule: "no-interfaces", Object: "dut2"},
+			},
+		},
+		{
+			name:       "unsupported role",
+			devices:    []netbox.Device{device(1, "server1", "Server", 0)},
+			interfaces: []netbox.Interface{iface(1, "server1", "eno1", nil)},
+			want:       []LintIssue{{Severity: LintWarning, Rule: "unsupported-role", Object: "server1"}},
+		},
+		{
+			name:    "speed without S_xGB name and cable to a circuit",
+			devices: []netbox.Device{device(1, "dut1", "DUT", 2)},
+			interfaces: []netbox.Interface{
+				iface(1, "dut1", "eth1", speed(2500000)),
+				iface(1, "dut1", "eth2", nil, netbox.LinkPeer{Name: "circuit1"}),
+			},
+			want: []LintIssue{
+				{Severity: LintError, Rule: "speed-unmapped", Object: "dut1:eth1"},
+				{Severity: LintWarning, Rule: "cable-non-device-peer", Object: "dut1:eth2"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lint(tt.devices, tt.interfaces)
+			for i := range got {
+				got[i].Message = ""
+			}
+			want := tt.want
+			if want == nil {
+				want = []LintIssue{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("lint() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
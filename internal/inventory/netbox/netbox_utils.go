@@ -1,485 +1,422 @@
 package inventory
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"io"
-
 	"keysight/laas/controller/config"
+	"keysight/laas/controller/internal/netbox"
 	"keysight/laas/controller/internal/profile"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
-const (
-	// NETBOX_URL = "http://10.39.70.169:8000/api/"
-	// TOKEN      = "3a0d77d5d033af6a3e2c168f2c9e2cb6d81082b1"
-	HEADERS = "application/json"
+var (
+	// httpClient is shared by NetBox clients, set up by InitHTTPClient
+	httpClient = &http.Client{}
+	// requestSlots bounds the number of concurrent requests to NetBox across
+	// all clients
+	requestSlots     chan struct{}
+	requestSlotsOnce sync.Once
 )
 
-var httpClient = &http.Client{}
-
-func createRequest(method, url string, TOKEN string, body []byte) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+// InitHTTPClient sets up the HTTP client of NetBox connections with the
+// configured CA bundle, client certificate and certificate verification
+func InitHTTPClient() error {
+	tlsConfig, err := newTLSConfig()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// keep a connection around for each concurrent request
+	transport.MaxIdleConnsPerHost = *config.Config.NetboxConcurrency
+	httpClient = &http.Client{Transport: transport}
+	return nil
+}
+
+// newTLSConfig returns the TLS configuration of NetBox connections; a CA
+// bundle is trusted in addition to the system CAs
+func newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: *config.Config.NetboxInsecureSkipVerify}
+	if tlsConfig.InsecureSkipVerify {
+		log.Warn().Msg("NetBox server certificate verification is disabled")
+	}
+	if path := *config.Config.NetboxCACert; path != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		bundle, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read NetBox CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("failed to find any certificate in NetBox CA bundle %s", path)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certPath := *config.Config.NetboxClientCert; certPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, *config.Config.NetboxClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load NetBox client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
-	req.Header.Set("Authorization", "Token "+TOKEN)
-	req.Header.Set("Content-Type", HEADERS)
-	return req, nil
+	return tlsConfig, nil
 }
 
-func performRequest(req *http.Request) (*http.Response, error) {
-	resp, err := httpClient.Do(req)
+// newClient returns a NetBox client for the given API url and token, with
+// timeouts, retries and concurrency as configured
+func newClient(netboxApiURL string, netboxApiToken string) *netbox.Client {
+	requestSlotsOnce.Do(func() {
+		requestSlots = make(chan struct{}, *config.Config.NetboxConcurrency)
+	})
+	client := netbox.NewClient(netboxApiURL, netboxApiToken)
+	client.HTTPClient = httpClient
+	client.Timeout = time.Duration(*config.Config.NetboxTimeoutSeconds) * time.Second
+	client.Retries = *config.Config.NetboxRetries
+	client.Slots = requestSlots
+	return client
+}
+
+// fetchReserved fetches the devices picked for a reservation, keyed by
+// lowercase name, along with their interfaces keyed by lowercase
+// "device:interface"; bulk filters keep it to a couple of requests
+func fetchReserved(ctx context.Context, client *netbox.Client, devices []ReservedDevice) (map[string]netbox.Device, map[string]netbox.Interface, error) {
+	names := []string{}
+	for _, device := range devices {
+		names = append(names, device.Name)
+	}
+	found, err := client.ListDevicesByName(ctx, names)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get devices %v: %w", names, err)
+	}
+	byName := map[string]netbox.Device{}
+	ids := []int{}
+	for _, device := range found {
+		byName[strings.ToLower(device.Name)] = device
+		ids = append(ids, device.ID)
+	}
+	interfaces, err := client.ListInterfacesByDevice(ctx, ids)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("failed to get interfaces of %v: %w", names, err)
 	}
-	return resp, nil
+	byPort := map[string]netbox.Interface{}
+	for _, iface := range interfaces {
+		byPort[strings.ToLower(iface.Device.Name+":"+iface.Name)] = iface
+	}
+	return byName, byPort, nil
 }
 
-func updateDevicesData(jsonFile string, NETBOX_URL string, TOKEN string, userID string, releaseState map[string][]map[string]interface{}) error {
+// claimTarget is a device or interface whose custom fields get patched
+type claimTarget struct {
+	// name is the device name, or "device:port" for interfaces
+	name   string
+	port   bool
+	object netbox.Object
+	data   map[string]interface{}
+}
+
+// add records the target as reserved by another session
+func (e *ReservedError) add(target claimTarget) {
+	if target.port {
+		e.Ports = append(e.Ports, target.name)
+	} else {
+		e.Devices = append(e.Devices, target.name)
+	}
+}
+
+// updateDevicesData marks the devices and ports as reserved by userID, or
+// marks those reserved by userID as available again when release is set.
+// NetBox has no conditional updates, so reserving fails on any object updated
+// since it was checked, as found by fetching it again right before patching
+// it, and every object is checked to be still owned by userID afterwards.
+// This only narrows the window for conflicting writes: two controllers
+// claiming the same object at the same moment may both pass the checks, only
+// claims made through the same controller are fully serialized. A
+// ReservedError lists the devices and ports held by, or being claimed by,
+// other sessions
+func updateDevicesData(ctx context.Context, devices []ReservedDevice, NETBOX_URL string, TOKEN string, userID string, metadata map[string]string, releaseState map[string][]map[string]interface{}, changes *[]StateChange, release bool) error {
 	defer profile.LogFuncDuration(time.Now(), "updateDevicesData", "", "inventory")
-	// Read data from JSON file
-	jsonData, err := os.ReadFile(jsonFile)
+	client := newClient(NETBOX_URL, TOKEN)
+	deviceByName, interfaceByPort, err := fetchReserved(ctx, client, devices)
 	if err != nil {
-		return fmt.Errorf("failed to read JSON file: %v, Error: %v", jsonFile, err)
-	}
-
-	var data map[string]interface{}
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		return fmt.Errorf("failed to unmarshal JSON file: %v", err)
-	}
-	deviceNames := map[string]string{}
-	for _, value := range data {
-		if v, ok := value.(map[string]interface{}); ok {
-			for _, v := range v {
-				if vv, ok := v.(map[string]interface{}); ok {
-					name := vv["id"].(string)
-					if attributes, ok := vv["attributes"].(map[string]interface{}); ok {
-						model := attributes["role"].(string)
-						deviceNames[name] = model
-					}
-
-				}
-			}
-		}
+		return err
 	}
-	portNames := []map[string]string{} // Define portNames as a slice of maps
-	devices, devicesOK := data["devices"].(map[string]interface{})
-	if devicesOK {
-		for _, device := range devices {
-			deviceMap, deviceMapOK := device.(map[string]interface{})
-			if !deviceMapOK {
-				continue
+	targets := []claimTarget{}
+	for _, device := range devices {
+		// ATEs and L1 switches are shared, only their ports get reserved
+		role := strings.ToLower(device.Role)
+		if !device.PortsOnly && role != "ate" && role != "l1s" {
+			found, ok := deviceByName[strings.ToLower(device.Name)]
+			if !ok {
+				return fmt.Errorf("failed to find the device: %v", device.Name)
 			}
-			deviceID, idOK := deviceMap["id"].(string) // Get device ID
-			if !idOK {
-				continue
-			}
-			ports, portsOK := deviceMap["ports"].(map[string]interface{})
-			if !portsOK {
+			targets = append(targets, claimTarget{
+				name:   device.Name,
+				object: netbox.Object{ID: found.ID, URL: found.URL, CustomFields: found.CustomFields, Fields: found.Fields.ReservationFields, Tags: found.Tags, LastUpdated: found.LastUpdated},
+				data: map[string]interface{}{
+					"name":        found.Name,
+					"device_type": found.DeviceType.ID,
+				},
+			})
+		}
+		for _, name := range device.Ports {
+			iface, ok := interfaceByPort[strings.ToLower(device.Name+":"+name)]
+			if !ok {
 				continue
 			}
-			for _, port := range ports {
-				portMap, portMapOK := port.(map[string]interface{})
-				if !portMapOK {
-					continue
-				}
-				portAttributes, attributesOK := portMap["attributes"].(map[string]interface{})
-				if !attributesOK {
-					continue
-				}
-				// Access port attributes
-				name, nameOK := portAttributes["name"].(string)
-				if nameOK {
-					// Create a map to store device ID and port name
-					devicePort := map[string]string{
-						deviceID: name,
-					}
-					// Append the devicePort map to portNames slice
-					portNames = append(portNames, devicePort)
-				}
+			targets = append(targets, claimTarget{
+				name:   device.Name + ":" + name,
+				port:   true,
+				object: netbox.Object{ID: iface.ID, URL: iface.URL, CustomFields: iface.CustomFields, Fields: iface.Fields.ReservationFields, Tags: iface.Tags, LastUpdated: iface.LastUpdated},
+				data:   map[string]interface{}{},
+			})
+		}
+	}
+
+	conflict := &ReservedError{}
+	updates := []claimTarget{}
+	for _, target := range targets {
+		reserved := target.object.Fields.Reserved()
+		owned := ownedBy(target.object.Fields, userID)
+		switch {
+		case reserved && !owned:
+			if release {
+				return fmt.Errorf("failed to release %v, it's reserved by another session", target.name)
 			}
+			conflict.add(target)
+		case release && owned:
+			target.data["custom_fields"] = availableCustomFields(target.object.CustomFields)
+			updates = append(updates, target)
+		case !release && !reserved:
+			target.data["custom_fields"] = reservedCustomFields(userID, metadata)
+			updates = append(updates, target)
 		}
 	}
-	client := httpClient
-	for deviceName, model := range deviceNames {
-		url := fmt.Sprintf("%sdcim/devices/?name=%s", NETBOX_URL, deviceName)
-		req, err := createRequest("GET", url, TOKEN, nil)
-		if err != nil {
-			return fmt.Errorf("get request failed: %v, Error: %v", url, err)
+	if len(conflict.Devices) != 0 || len(conflict.Ports) != 0 {
+		return conflict
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	tag, err := sessionTag(ctx, client, userID, metadata, !release)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range updates {
+		object := target.object
+		if !release {
+			current := netbox.Object{}
+			if err := client.Get(ctx, target.object.URL, &current); err != nil {
+				return fmt.Errorf("error fetching %v: %w", target.name, err)
+			}
+			if current.LastUpdated != target.object.LastUpdated {
+				log.Warn().Str("UserID", userID).Str("Object", target.name).Msg("Object updated concurrently")
+				conflict.add(target)
+				return conflict
+			}
+			object = current
 		}
-		response, err := performRequest(req)
-		if err != nil {
-			return fmt.Errorf("failed to get response: %v, Error: %v", url, err)
+		change := newStateChange(userID, target.object.URL, object.CustomFields)
+		patch := map[string]interface{}{}
+		for key, value := range target.data {
+			patch[key] = value
 		}
-		defer response.Body.Close()
-
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read response url: %v, Error: %v", url, err)
+		if tag != nil {
+			change.Tags = tagIDs(object.Tags)
+			patch["tags"] = tagRefs(retag(object.Tags, *tag, release))
 		}
-
-		var deviceDict map[string]interface{}
-		if err := json.Unmarshal(body, &deviceDict); err != nil {
-			return fmt.Errorf("failed to unmarshal deviceDict: %v", err)
+		*changes = append(*changes, change)
+		if err := client.Patch(ctx, target.object.URL, patch); err != nil {
+			return fmt.Errorf("error updating %v: %w", target.name, err)
 		}
-		results := deviceDict["results"].([]interface{})
-		if len(results) > 0 {
-			deviceDict := results[0].(map[string]interface{})
-			if strings.ToLower(model) != "ate" && strings.ToLower(model) != "l1s" && strings.ToLower(deviceDict["custom_fields"].(map[string]interface{})["state"].(string)) != "reserved" {
-				if strings.EqualFold(deviceDict["name"].(string), deviceName) {
-					deviceURL := deviceDict["url"].(string)
-					updateData := map[string]interface{}{
-						"name":        deviceDict["name"],
-						"device_type": deviceDict["device_type"].(map[string]interface{})["id"],
-						"custom_fields": map[string]interface{}{
-							"session_id": userID,
-							"state":      "Reserved",
-						},
-					}
-					// Store the updateData and deviceURL in the global variable
-					deviceUpdate := map[string]interface{}{
-						deviceURL: updateData,
-					}
-					// Ensure userID is a string
-					if _, exists := releaseState[userID]; !exists {
-						releaseState[userID] = []map[string]interface{}{deviceUpdate}
-					} else {
-						releaseState[userID] = append(releaseState[userID], deviceUpdate)
-					}
-					updateDataJSON, err := json.Marshal(updateData)
-					if err != nil {
-						return fmt.Errorf("failed Json marshal with updateData: %v", err)
-					}
-					req, err := http.NewRequest("PATCH", deviceURL, bytes.NewBuffer(updateDataJSON))
-					if err != nil {
-						return fmt.Errorf("failed to patch the data with url: %v, Error: %v", deviceURL, err)
-					}
-					req.Header.Set("Authorization", "Token "+TOKEN)
-					req.Header.Set("Content-Type", HEADERS)
-					response, err := client.Do(req)
-					if err != nil {
-						return fmt.Errorf("failed to get response for deviceURL: %v, Error: %v", deviceURL, err)
-					}
-					defer response.Body.Close()
-					if response.StatusCode != http.StatusOK {
-						return fmt.Errorf("error updating device details, status code: %v", response.StatusCode)
-					}
-				} else {
-					return fmt.Errorf("failed to find the device: %v", deviceName)
-				}
-
-			} else if strings.ToLower(model) != "ate" && strings.ToLower(model) != "l1s" && strings.ToLower(deviceDict["custom_fields"].(map[string]interface{})["state"].(string)) == "reserved" && strings.ToLower(deviceDict["custom_fields"].(map[string]interface{})["session_id"].(string)) == userID {
-				if strings.EqualFold(deviceDict["name"].(string), deviceName) {
-					deviceURL := deviceDict["url"].(string)
-					updateData := map[string]interface{}{
-						"name":        deviceDict["name"],
-						"device_type": deviceDict["device_type"].(map[string]interface{})["id"],
-						"custom_fields": map[string]interface{}{
-							"state":      "Available",
-							"session_id": "",
-						},
-					}
-					updateDataJSON, err := json.Marshal(updateData)
-					if err != nil {
-						return fmt.Errorf("failed Json marshal with updateData: %v", err)
-					}
-					req, err := http.NewRequest("PATCH", deviceURL, bytes.NewBuffer(updateDataJSON))
-					if err != nil {
-						return fmt.Errorf("failed to patch the data with url: %v, Error: %v", deviceURL, err)
-					}
-					req.Header.Set("Authorization", "Token "+TOKEN)
-					req.Header.Set("Content-Type", HEADERS)
-					response, err := client.Do(req)
-					if err != nil {
-						return fmt.Errorf("failed to get response for deviceURL: %v, Error: %v", deviceURL, err)
-					}
-					defer response.Body.Close()
-					if response.StatusCode != http.StatusOK {
-						return fmt.Errorf("error updating device details, status code: %v", response.StatusCode)
-					}
-				} else {
-					return fmt.Errorf("failed to find the device: %v", deviceName)
-				}
-			} else if strings.ToLower(model) != "ate" && strings.ToLower(model) != "l1s" && strings.ToLower(deviceDict["custom_fields"].(map[string]interface{})["state"].(string)) == "reserved" && strings.ToLower(deviceDict["custom_fields"].(map[string]interface{})["session_id"].(string)) != userID {
-				return fmt.Errorf("failed to update device details")
-			}
+		if !release {
+			// Store the updateData and object URL in the release state, only
+			// once the object is claimed
+			releaseState[userID] = append(releaseState[userID], map[string]interface{}{target.object.URL: target.data})
 		}
 	}
-	for _, portName := range portNames {
-		for deviceID, name := range portName {
-			url := fmt.Sprintf("%sdcim/interfaces/?name=%s", NETBOX_URL, name)
-			req, err := createRequest("GET", url, TOKEN, nil)
-			if err != nil {
-				return fmt.Errorf("get request failed: %v, Error: %v", url, err)
-			}
-			response, err := performRequest(req)
-			if err != nil {
-				return fmt.Errorf("failed to get response: %v, Error: %v", url, err)
-			}
-			defer response.Body.Close()
-
-			body, err := io.ReadAll(response.Body)
-			if err != nil {
-				return fmt.Errorf("failed to read response url: %v, Error: %v", url, err)
-			}
+	if release {
+		journal(ctx, client, userID, metadata, journalTargets(updates, deviceByName), true)
+		return nil
+	}
 
-			var portDict map[string]interface{}
-			if err := json.Unmarshal(body, &portDict); err != nil {
-				return fmt.Errorf("failed to unmarshal deviceDict: %v", err)
-			}
-			results := portDict["results"].([]interface{})
-			for _, result := range results {
-				portDict := result.(map[string]interface{})
-				var devicename string
-				if deviceData, ok := portDict["device"].(map[string]interface{}); ok {
-					devicename = deviceData["name"].(string)
-				}
-				if strings.EqualFold(portDict["name"].(string), name) && strings.EqualFold(devicename, deviceID) && strings.ToLower(portDict["custom_fields"].(map[string]interface{})["state"].(string)) != "reserved" {
-					portURL := portDict["url"].(string)
-					updateData := map[string]interface{}{
-						"custom_fields": map[string]interface{}{
-							"session_id": userID,
-							"state":      "Reserved",
-						},
-					}
-					// Store the updateData and deviceURL in the global variable
-					deviceUpdate := map[string]interface{}{
-						portURL: updateData,
-					}
-					// Ensure userID is a string
-					if _, exists := releaseState[userID]; !exists {
-						releaseState[userID] = []map[string]interface{}{deviceUpdate}
-					} else {
-						releaseState[userID] = append(releaseState[userID], deviceUpdate)
-					}
-					updateDataJSON, err := json.Marshal(updateData)
-					if err != nil {
-						return fmt.Errorf("failed Json marshal with updateData: %v", err)
-					}
-					req, err := http.NewRequest("PATCH", portURL, bytes.NewBuffer(updateDataJSON))
-					if err != nil {
-						return fmt.Errorf("failed to patch the data with url: %v Error: %v", portURL, err)
-					}
-					req.Header.Set("Authorization", "Token "+TOKEN)
-					req.Header.Set("Content-Type", HEADERS)
-					response, err := client.Do(req)
-					if err != nil {
-						return fmt.Errorf("failed to get response for portURL: %v Error: %v", portURL, err)
-					}
-					defer response.Body.Close()
-					if response.StatusCode != http.StatusOK {
-						return fmt.Errorf("error updating port details, status code: %v", response.StatusCode)
-					}
-				} else if strings.EqualFold(portDict["name"].(string), name) && strings.EqualFold(devicename, deviceID) && strings.ToLower(portDict["custom_fields"].(map[string]interface{})["state"].(string)) == "reserved" && strings.ToLower(portDict["custom_fields"].(map[string]interface{})["session_id"].(string)) == userID {
-					portURL := portDict["url"].(string)
-					updateData := map[string]interface{}{
-						"custom_fields": map[string]interface{}{
-							"state":      "Available",
-							"session_id": "",
-						},
-					}
-					updateDataJSON, err := json.Marshal(updateData)
-					if err != nil {
-						return fmt.Errorf("failed Json marshal with updateData: %v", err)
-					}
-					req, err := http.NewRequest("PATCH", portURL, bytes.NewBuffer(updateDataJSON))
-					if err != nil {
-						return fmt.Errorf("failed to patch the data with url: %v Error: %v", portURL, err)
-					}
-					req.Header.Set("Authorization", "Token "+TOKEN)
-					req.Header.Set("Content-Type", HEADERS)
-					response, err := client.Do(req)
-					if err != nil {
-						return fmt.Errorf("failed to get response for portURL: %v Error: %v", portURL, err)
-					}
-					defer response.Body.Close()
-					if response.StatusCode != http.StatusOK {
-						return fmt.Errorf("error updating port details, status code: %v", response.StatusCode)
-					}
-				} else if strings.EqualFold(portDict["name"].(string), name) && strings.EqualFold(devicename, deviceID) && strings.ToLower(portDict["custom_fields"].(map[string]interface{})["state"].(string)) == "reserved" && strings.ToLower(portDict["custom_fields"].(map[string]interface{})["session_id"].(string)) != userID {
-					return fmt.Errorf("failed to update interface details")
-				}
-			}
+	// A session claiming the same objects concurrently may have patched them
+	// right after this one did
+	deviceByName, interfaceByPort, err = fetchReserved(ctx, client, devices)
+	if err != nil {
+		return err
+	}
+	for _, target := range updates {
+		var fields netbox.ReservationFields
+		if target.port {
+			fields = interfaceByPort[strings.ToLower(target.name)].Fields.ReservationFields
+		} else {
+			fields = deviceByName[strings.ToLower(target.name)].Fields.ReservationFields
+		}
+		if !ownedBy(fields, userID) {
+			conflict.add(target)
 		}
 	}
+	if len(conflict.Devices) != 0 || len(conflict.Ports) != 0 {
+		return conflict
+	}
+	journal(ctx, client, userID, metadata, journalTargets(updates, deviceByName), false)
 	return nil
-	// Check if the file exists before attempting to delete
-	// if _, err := os.Stat(jsonFile); err == nil {
-	// 	// Delete the file
-	// 	err := os.Remove(jsonFile)
-	// 	if err != nil {
-	// 		log.Fatalf("Error deleting file: %v\n", err)
-	// 	}
-	// 	log.Printf("File '%s' deleted successfully.\n", jsonFile)
-	// } else {
-	// 	log.Printf("File '%s' does not exist.\n", jsonFile)
-	// }
 }
 
-func getDeviceDetails(deviceName string, NETBOX_URL string, TOKEN string) map[string]interface{} {
-	url := fmt.Sprintf("%sdcim/devices/?name=%s", NETBOX_URL, deviceName)
-	req, err := createRequest("GET", url, TOKEN, nil)
-	if err != nil {
-		log.Fatal().Msgf("Get request failed: %v Error: %v", url, err)
-	}
-	response, err := performRequest(req)
-	if err != nil {
-		log.Fatal().Msgf("Failed to get response with the url: %v Error: %v", url, err)
-	}
-	defer response.Body.Close()
-	if response.StatusCode == http.StatusOK {
-		var result map[string]interface{}
-		_ = json.NewDecoder(response.Body).Decode(&result)
-		deviceDetails := result["results"].([]interface{})[0].(map[string]interface{})
-		return deviceDetails
-	} else {
-		log.Error().Interface("Status Code", response.StatusCode).Msg("Error fetching device details")
-		return nil
+// ownedBy reports whether the custom fields mark an object as reserved by
+// userID
+func ownedBy(fields netbox.ReservationFields, userID string) bool {
+	return fields.Reserved() && strings.EqualFold(fields.SessionID, userID)
+}
+
+// inventoryDevice is a NetBox device along with its interfaces, holding what
+// the inventory is built from
+type inventoryDevice struct {
+	Name       string
+	Role       string
+	DeviceType string
+	Vendor     string
+	Platform   string
+	Image      string
+	State      string
+	Connection string
+	Credential string
+	HandleName string
+	Via        string
+	Attributes map[string]interface{}
+	Interfaces []interface{}
+}
+
+// nullable returns "null" for empty values, as the inventory expects
+func nullable(value string) string {
+	if value == "" {
+		return "null"
 	}
+	return value
 }
 
-func getDevicesDetails(NETBOX_URL string, TOKEN string) []string {
-	defer profile.LogFuncDuration(time.Now(), "getDevicesDetails", "", "inventory")
+// speeds maps interface speeds in Kbps to the testbed speed names
+var speeds = map[int]string{
+	1000000:   "S_1GB",
+	5000000:   "S_5GB",
+	10000000:  "S_10GB",
+	25000000:  "S_25GB",
+	40000000:  "S_40GB",
+	50000000:  "S_50GB",
+	100000000: "S_100GB",
+	200000000: "S_200GB",
+	400000000: "S_400GB",
+}
 
-	var allDevices []string
-	offset := 0
-	limit := 1000
-	for {
-		url := fmt.Sprintf("%sdcim/devices/?offset=%d&limit=%d", NETBOX_URL, offset, limit)
-		req, err := createRequest("GET", url, TOKEN, nil)
-		if err != nil {
-			log.Fatal().Msgf("Get request failed: %v, Error: %v", url, err)
+// lowerCustomFields returns the custom fields with lowercase names and string
+// values, except for the given ones which are kept as they are
+func lowerCustomFields(customFields netbox.CustomFields, keep ...string) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, value := range customFields {
+		if contains(keep, key) {
+			result[key] = value
+			continue
 		}
-		response, err := performRequest(req)
-		if err != nil {
-			log.Fatal().Msgf("Failed to get response with the url: %v, Error: %v", url, err)
+		if v, ok := value.(string); ok {
+			value = strings.ToLower(v)
 		}
-		defer response.Body.Close()
-		if response.StatusCode == http.StatusOK {
-			var result map[string]interface{}
-			_ = json.NewDecoder(response.Body).Decode(&result)
-			deviceDetails := result["results"].([]interface{})
-			for _, device := range deviceDetails {
-				allDevices = append(allDevices, device.(map[string]interface{})["name"].(string))
-			}
-
-			// Check if there are more pages
-			nextPage := result["next"]
-			if nextPage == nil {
-				break
-			}
+		result[strings.ToLower(key)] = value
+	}
+	return result
+}
 
-			// Update the offset for the next page
-			offset += limit
+// interfaceData returns the inventory port of a NetBox interface
+func interfaceData(iface netbox.Interface) map[string]interface{} {
+	var speedValue interface{} = "SPEED_UNSPECIFIED"
+	if iface.Speed != nil {
+		if speed, ok := speeds[*iface.Speed]; ok {
+			speedValue = speed
 		} else {
-			log.Error().Interface("Status Code", response.StatusCode).Msg("Error fetching device details")
-			break
+			speedValue = *iface.Speed
 		}
 	}
-
-	log.Debug().Interface("All Devices", allDevices).Msg("Obtained devices details")
-	return allDevices
-}
-
-func getInterfacesDetails(deviceName string, NETBOX_URL string, TOKEN string) []map[string]interface{} {
-	defer profile.LogFuncDuration(time.Now(), "getInterfacesDetails", "", "inventory")
-
-	url := fmt.Sprintf("%sdcim/interfaces/?device=%s", NETBOX_URL, deviceName)
-
-	req, err := createRequest("GET", url, TOKEN, nil)
-	if err != nil {
-		log.Fatal().Msgf("Get request failed: %v, Error: %v", url, err)
+	pmdValue := iface.Fields.Pmd
+	if pmdValue == "" || pmdValue == "null" {
+		pmdValue = "PMD_UNSPECIFIED"
 	}
-	response, err := performRequest(req)
-	if err != nil {
-		log.Fatal().Msgf("Failed to get response with the url: %v, Error: %v", url, err)
-	}
-	defer response.Body.Close()
-	if response.StatusCode == http.StatusOK {
-		var result map[string]interface{}
-		_ = json.NewDecoder(response.Body).Decode(&result)
-		interfaceDetails := result["results"].([]interface{})
-		var interfaceList []map[string]interface{}
-		for _, iface := range interfaceDetails {
-			interfaceList = append(interfaceList, iface.(map[string]interface{}))
-		}
-		log.Debug().Interface("Interfaces", interfaceList).Msg("Obtained Interfaces details")
-		return interfaceList
-	} else {
-		log.Error().Interface("Status Code", response.StatusCode).Msg("Error fetching interface details")
-		return nil
+	// Convert custom field keys and values to lowercase except "pmd" and "speed"
+	customFields := lowerCustomFields(iface.CustomFields, "pmd", "speed")
+	if pmd, exists := customFields["pmd"]; !exists || pmd == nil || pmd == "null" {
+		customFields["pmd"] = "PMD_UNSPECIFIED"
+	}
+	return map[string]interface{}{
+		"id":          iface.Name,
+		"name":        iface.Name,
+		"speed":       speedValue,
+		"pmd":         pmdValue,
+		"transceiver": strings.ToLower(nullable(iface.Fields.Transceiver)),
+		"attributes":  customFields,
 	}
 }
 
-func getEachInterfaceDetails(NETBOX_URL string, TOKEN string) []map[string]interface{} {
-	var allInterfaces []map[string]interface{}
-	offset := 0
-	limit := 1000
-	for {
-		url := fmt.Sprintf("%sdcim/interfaces/?offset=%d&limit=%d", NETBOX_URL, offset, limit)
-		req, err := createRequest("GET", url, TOKEN, nil)
-		if err != nil {
-			log.Fatal().Msgf("Get request failed: %v, Error: %v", url, err)
-		}
+// inventoryDevices returns the inventory devices built from the NetBox
+// devices and interfaces
+func inventoryDevices(devices []netbox.Device, interfaces []netbox.Interface) []inventoryDevice {
+	defer profile.LogFuncDuration(time.Now(), "inventoryDevices", "", "inventory")
 
-		response, err := performRequest(req)
-		if err != nil {
-			log.Fatal().Msgf("Failed to get response with the url: %v, Error: %v", url, err)
+	deviceInterfaces := map[string][]interface{}{}
+	for _, iface := range interfaces {
+		deviceInterfaces[iface.Device.Name] = append(deviceInterfaces[iface.Device.Name], interfaceData(iface))
+	}
+	records := []inventoryDevice{}
+	for _, device := range devices {
+		ports := deviceInterfaces[device.Name]
+		if len(ports) == 0 {
+			ports = append(ports, map[string]interface{}{})
 		}
-		defer response.Body.Close()
-		if response.StatusCode == http.StatusOK {
-			var result map[string]interface{}
-			_ = json.NewDecoder(response.Body).Decode(&result)
-			interfaceDetails := result["results"].([]interface{})
-			for _, iface := range interfaceDetails {
-				allInterfaces = append(allInterfaces, iface.(map[string]interface{}))
+		role := nullable(device.RoleName())
+		attrs := netbox.CustomFields{}
+		for key, value := range device.CustomFields {
+			if contains([]string{"Connection", "Credential", "Handle_Name", "Via", "Image"}, key) {
+				continue
 			}
-
-			// Check if there are more pages
-			nextPage := result["next"]
-			if nextPage == nil {
-				break
+			if value == nil {
+				value = "null"
 			}
-
-			// Update the offset for the next page
-			offset += limit
-		} else {
-			log.Error().Interface("Status Code", response.StatusCode).Msg("Error fetching interface details")
-			break
+			attrs[key] = value
 		}
-	}
-	return allInterfaces
-}
-
-func getValue(val interface{}) string {
-	switch v := val.(type) {
-	case string:
-		return v
-	case map[string]interface{}:
-		value, exists := v["value"].(string)
-		if exists {
-			return value
+		attrs["address"] = "null"
+		if device.PrimaryIP != nil {
+			attrs["address"] = nullable(device.PrimaryIP.Address)
 		}
-		return "val"
-	default:
-		return "val"
-	}
-}
-func removeKeys(input map[string]interface{}, keysToRemove []string) map[string]interface{} {
-	result := make(map[string]interface{})
-
-	for key, value := range input {
-		// Check if the key is not in the keysToRemove list
-		if !contains(keysToRemove, key) {
-			result[key] = value
+		attrs["devicetype"] = role
+		platform := "null"
+		if device.Platform != nil {
+			platform = nullable(device.Platform.Name)
 		}
+		records = append(records, inventoryDevice{
+			Name:       device.Name,
+			Role:       role,
+			DeviceType: nullable(device.Fields.Model),
+			Vendor:     nullable(device.Fields.Vendor),
+			Platform:   platform,
+			Image:      nullable(device.Fields.Image),
+			State:      nullable(device.Fields.State),
+			Connection: nullable(device.Fields.Connection),
+			Credential: nullable(device.Fields.Credential),
+			HandleName: nullable(device.Fields.HandleName),
+			Via:        nullable(device.Fields.Via),
+			// Convert custom field keys and values to lowercase except "role"
+			Attributes: lowerCustomFields(attrs, "role"),
+			Interfaces: ports,
+		})
 	}
-	return result
+	log.Debug().Int("Devices", len(records)).Msg("Obtained devices details")
+	return records
 }
 
 // contains checks if a given string exists in a slice
@@ -492,259 +429,140 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-func getDevicesData(netboxApiURL string, netboxApiToken string) []byte {
-	defer profile.LogFuncDuration(time.Now(), "getDevicesData", "", "inventory")
-
-	deviceList := getDevicesDetails(netboxApiURL, netboxApiToken)
-	var listOfDeviceDicts []map[string]interface{}
-	for _, deviceName := range deviceList {
-		interfaceDict := make([]map[string]interface{}, 0)
-		deviceDetails := getDeviceDetails(deviceName, netboxApiURL, netboxApiToken)
-
-		if deviceDetails["interface_count"].(float64) > 0 {
-			interfaceDetails := getInterfacesDetails(deviceName, netboxApiURL, netboxApiToken)
-			for _, iface := range interfaceDetails {
-				if iface["device"].(map[string]interface{})["name"].(string) == deviceName {
-					if iface["speed"] != nil {
-						speed := iface["speed"].(float64)
-						switch speed {
-						case 1000000:
-							iface["speed"] = "S_1GB"
-						case 5000000:
-							iface["speed"] = "S_5GB"
-						case 10000000:
-							iface["speed"] = "S_10GB"
-						case 25000000:
-							iface["speed"] = "S_25GB"
-						case 40000000:
-							iface["speed"] = "S_40GB"
-						case 50000000:
-							iface["speed"] = "S_50GB"
-						case 100000000:
-							iface["speed"] = "S_100GB"
-						case 200000000:
-							iface["speed"] = "S_200GB"
-						case 400000000:
-							iface["speed"] = "S_400GB"
-						}
-					}
-					pmdValue := getValidValue(iface, "custom_fields.pmd")
-					if pmdValue == "" || pmdValue == "null" {
-						pmdValue = "PMD_UNSPECIFIED"
-					}
-					customFields, ok := iface["custom_fields"].(map[string]interface{})
-					if !ok {
-						log.Fatal().Msgf("custom_fields is not a map[string]interface{}")
+// deviceLinks returns the links between device interfaces, as given by the
+// link peers of each interface
+func deviceLinks(interfaces []netbox.Interface) []DutLink {
+	defer profile.LogFuncDuration(time.Now(), "deviceLinks", "", "inventory")
+
+	links := []DutLink{}
+	seenLinks := make(map[string]struct{})
+	for _, iface := range interfaces {
+		for _, peer := range iface.LinkPeers {
+			if peer.Device == nil {
+				continue
+			}
+			link := DutLink{
+				Src: DutLinkEndpoint{Device: iface.Device.Name, Port: iface.Name},
+				Dst: DutLinkEndpoint{Device: peer.Device.Name, Port: peer.Name},
+			}
+			// Deduplicate links
+			key := link.Src.Device + ":" + link.Src.Port + link.Dst.Device + ":" + link.Dst.Port
+			if _, seen := seenLinks[key]; !seen {
+				links = append(links, link)
+				seenLinks[key] = struct{}{}
+			}
+		}
+	}
+	log.Debug().Interface("Devices links", links).Msg("Obtained Devices links")
+	return links
+}
+
+// updateNodeState marks the objects in the release state of user_id as
+// available; objects no longer reserved by user_id are left as they are
+func updateNodeState(ctx context.Context, releaseState map[string][]map[string]interface{}, user_id string) error {
+	defer profile.LogFuncDuration(time.Now(), "updateNodeState", "", "inventory")
+	client := newClient(*config.Config.NetboxApiURL, *config.Config.NetboxUserToken)
+	released := []string{}
+	for userId, nodes := range releaseState {
+		if userId == user_id {
+			for _, details := range nodes {
+				for url, data := range details {
+					current := netbox.Object{}
+					if err := client.Get(ctx, url, &current); err != nil {
+						return fmt.Errorf("error fetching %v: %w", url, err)
 					}
-					// Convert custom field keys and values to lowercase except "pmd" and "speed"
-					for key, value := range customFields {
-						if key == "pmd" || key == "speed" {
-							continue
-						}
-						lowerKey := strings.ToLower(key)
-						switch v := value.(type) {
-						case string:
-							customFields[lowerKey] = strings.ToLower(v)
-						default:
-							customFields[lowerKey] = value
-						}
-						// Remove the original key if it differs in case
-						if lowerKey != key {
-							delete(customFields, key)
-						}
+					if !ownedBy(current.Fields, user_id) {
+						log.Warn().Str("UserID", user_id).Str("URL", url).Msg("Not releasing object no longer reserved by the session")
+						continue
 					}
-					speedValue := iface["speed"]
-					if iface["speed"] == "" || iface["speed"] == "null" || iface["speed"] == nil {
-						speedValue = "SPEED_UNSPECIFIED"
+					updatedData, err := stateUpdate(data)
+					if err != nil {
+						return fmt.Errorf("failed to get updated Json Data: %v", err)
 					}
-					// Update the "pmd" field if necessary
-					if pmd, exists := customFields["pmd"]; !exists || pmd == nil || pmd == "null" {
-						customFields["pmd"] = "PMD_UNSPECIFIED"
+					if err := client.Patch(ctx, url, updatedData); err != nil {
+						return fmt.Errorf("error releasing %v: %w", url, err)
 					}
-					interfaceDict = append(interfaceDict, map[string]interface{}{
-						"id":          iface["name"],
-						"name":        iface["name"],
-						"speed":       speedValue,
-						"pmd":         pmdValue,
-						"transceiver": strings.ToLower(getValidValue(iface, "custom_fields.transceiver")),
-						"attributes":  customFields,
-					})
+					released = append(released, url)
 				}
 			}
 		}
-		if deviceDetails != nil {
-			if len(interfaceDict) == 0 {
-				interfaceDict = append(interfaceDict, map[string]interface{}{})
-			}
-			inventoryDeviceAttr1 := deviceDetails["custom_fields"].(map[string]interface{})
-			keysToRemove := []string{"Connection", "Credential", "Handle_Name", "Via", "Image"}
-			inventoryDeviceAttr := removeKeys(inventoryDeviceAttr1, keysToRemove)
-			addr := getValidValue(deviceDetails, "primary_ip.address")
-			rol := getValidValue(deviceDetails, "role.name")
-			inventoryDeviceAttr["address"] = addr
-			inventoryDeviceAttr["devicetype"] = rol
-			for key, value := range inventoryDeviceAttr {
-				inventoryDeviceAttr[key] = replaceNilWithNull(value)
-			}
-			// Convert custom field keys and values to lowercase except "pmd" and "speed"
-			for key, value := range inventoryDeviceAttr {
-				if key == "role" {
-					continue
-				}
-				lowerKey := strings.ToLower(key)
-				switch v := value.(type) {
-				case string:
-					inventoryDeviceAttr[lowerKey] = strings.ToLower(v)
-				default:
-					inventoryDeviceAttr[lowerKey] = value
-				}
-				// Remove the original key if it differs in case
-				if lowerKey != key {
-					delete(inventoryDeviceAttr, key)
-				}
-			}
-			deviceData := map[string]interface{}{
-				"Id":   deviceDetails["id"],
-				"Name": deviceDetails["name"],
-				"Role": rol,
-				// "DeviceType":    getValidValue(deviceDetails, "device_type.model"),
-				"DeviceType": getValidValue(deviceDetails, "custom_fields.Model"),
-				// "Manufacturer":  getValidValue(deviceDetails, "device_type.manufacturer.name"),
-				"Vendor":        getValidValue(deviceDetails, "custom_fields.Vendor"),
-				"Platform":      getValidValue(deviceDetails, "platform.name"),
-				"Image":         getValidValue(deviceDetails, "custom_fields.Image"),
-				"State":         getValidValue(deviceDetails, "custom_fields.State"),
-				"Connec":        getValidValue(deviceDetails, "custom_fields.Connection"),
-				"Cred":          getValidValue(deviceDetails, "custom_fields.Credential"),
-				"HandleName":    getValidValue(deviceDetails, "custom_fields.Handle_Name"),
-				"Via":           getValidValue(deviceDetails, "custom_fields.Via"),
-				"inventoryAttr": inventoryDeviceAttr,
-				"interfaces":    interfaceDict,
-			}
-			listOfDeviceDicts = append(listOfDeviceDicts, deviceData)
-		} else {
-			log.Error().Interface("Device not found", deviceName).Msg("")
-		}
 	}
-	result, err := json.MarshalIndent(listOfDeviceDicts, "", "  ")
-	if err != nil {
-		log.Fatal().Msgf("Failed to Marshal listOfDeviceDicts: %v", err)
+	if err := deleteSessionTag(ctx, client, user_id); err != nil {
+		return err
 	}
-	return result
+	journal(ctx, client, user_id, nil, journalReleased(ctx, client, released), true)
+	return nil
 }
 
-func replaceNilWithNull(value interface{}) interface{} {
-	if value == nil {
-		return "null"
+// MetadataFields are the custom fields, other than session_id, describing the
+// session a NetBox object is reserved by
+var MetadataFields = []string{"owner", "team", "purpose", "ci_job_url"}
+
+// reservedCustomFields returns the custom fields of an object reserved by
+// userID; empty metadata is left out, so that the custom fields are only
+// required to exist in NetBox once used
+func reservedCustomFields(userID string, metadata map[string]string) map[string]interface{} {
+	customFields := map[string]interface{}{
+		"session_id": userID,
+		"state":      "Reserved",
 	}
-	return value
-}
-
-func getValidValue(data map[string]interface{}, path string) string {
-	state := getFieldValue(data, path)
-	return getValue(state)
-}
-
-func getFieldValue(data map[string]interface{}, path string) interface{} {
-	keys := strings.Split(path, ".")
-	current := data
-
-	for _, key := range keys {
-		// Case-insensitive key comparison
-		found := false
-		for actualKey, value := range current {
-			if strings.EqualFold(actualKey, key) {
-
-				// If the key is found, update 'current' and set found to true
-				if v, ok := value.(map[string]interface{}); ok {
-					current, found = v, true
-				} else if v, ok := value.(string); ok {
-					current, found = map[string]interface{}{"value": v}, true
-				} else {
-					current, found = nil, true
-				}
-				break
-			}
+	for _, field := range MetadataFields {
+		if value := metadata[field]; value != "" {
+			customFields[field] = value
 		}
+	}
+	return customFields
+}
 
-		// If the key is not found or value is nil, return "null"
-		if !found || current == nil {
-			return "null"
+// availableCustomFields returns the custom fields of an object once released,
+// clearing the metadata custom fields the object has
+func availableCustomFields(fields netbox.CustomFields) map[string]interface{} {
+	customFields := map[string]interface{}{
+		"state":      "Available",
+		"session_id": "",
+	}
+	for _, field := range MetadataFields {
+		if fields.Has(field) {
+			customFields[field] = ""
 		}
 	}
-
-	return current
+	return customFields
 }
 
-func getDevicesLinks(netboxApiURL string, netboxApiToken string) []byte {
-	defer profile.LogFuncDuration(time.Now(), "getDevicesLinks", "", "inventory")
-
-	interfaceDetails := getEachInterfaceDetails(netboxApiURL, netboxApiToken)
-	links := make([]map[string]string, 0)
-	for _, iface := range interfaceDetails {
-		srcDeviceName := iface["device"].(map[string]interface{})["name"].(string)
-		src := srcDeviceName + ":" + iface["name"].(string)
-		var dst string
-		if linkPeers, ok := iface["link_peers"].([]interface{}); ok && len(linkPeers) > 0 {
-			for _, peer := range linkPeers {
-				peerMap := peer.(map[string]interface{})
-				dstDeviceName := peerMap["device"].(map[string]interface{})["name"].(string)
-				dst = dstDeviceName + ":" + peerMap["name"].(string)
-				if src != "" && dst != "" {
-					links = append(links, map[string]string{"src": src, "dst": dst})
-				}
-			}
-		}
+// newStateChange records the state, session_id and metadata custom fields of
+// a NetBox object about to be patched by userID
+func newStateChange(userID string, url string, fields netbox.CustomFields) StateChange {
+	customFields := map[string]interface{}{
+		"state":      fields["state"],
+		"session_id": fields["session_id"],
 	}
-	// Deduplicate links
-	uniqueLinks := make([]map[string]string, 0)
-	seenLinks := make(map[string]struct{})
-	for _, link := range links {
-		key := link["src"] + link["dst"]
-		if _, seen := seenLinks[key]; !seen {
-			uniqueLinks = append(uniqueLinks, link)
-			seenLinks[key] = struct{}{}
+	for _, field := range MetadataFields {
+		if value, ok := fields[field]; ok {
+			customFields[field] = value
 		}
 	}
-	log.Debug().Interface("Devices links", uniqueLinks).Msg("Obtained Devices links")
-	jsonStr, err := json.MarshalIndent(uniqueLinks, "", "  ")
-	if err != nil {
-		log.Fatal().Msgf("Failed to Marshal uniqueLinks: %v", err)
-	}
-	return jsonStr
+	return StateChange{URL: url, CustomFields: customFields, SessionID: userID}
 }
 
-func updateNodeState(releaseState map[string][]map[string]interface{}, user_id string) error {
-	defer profile.LogFuncDuration(time.Now(), "updateNodeState", "", "inventory")
-	for userId, nodes := range releaseState {
-		if userId == user_id {
-			for _, details := range nodes {
-				for url, data := range details {
-					updatedData, err := stateUpdate(data)
-					if err != nil {
-						return fmt.Errorf("failed to get updated Json Data: %v", err)
-					}
-					updateDataJSON, err := json.Marshal(updatedData)
-					if err != nil {
-						return fmt.Errorf("failed Json marshal with updateData: %v", err)
-					}
-					req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(updateDataJSON))
-					if err != nil {
-						return fmt.Errorf("failed to patch the data with url: %v Error: %v", url, err)
-					}
-					req.Header.Set("Authorization", "Token "+*config.Config.NetboxUserToken)
-					req.Header.Set("Content-Type", HEADERS)
-					response, err := httpClient.Do(req)
-					if err != nil {
-						return fmt.Errorf("failed to get response for portURL: %v Error: %v", url, err)
-					}
-					defer response.Body.Close()
-					if response.StatusCode != http.StatusOK {
-						return fmt.Errorf("error updating port details, status code: %v", response.StatusCode)
-					}
-				}
-			}
-		}
+// restoreObjectState patches an object back to the state recorded in change,
+// unless another session reserved it since
+func restoreObjectState(ctx context.Context, change StateChange, TOKEN string) error {
+	// the change holds the absolute url of the object
+	client := newClient("", TOKEN)
+	current := netbox.Object{}
+	if err := client.Get(ctx, change.URL, &current); err != nil {
+		return fmt.Errorf("error fetching %v: %w", change.URL, err)
+	}
+	if current.Fields.Reserved() && change.SessionID != "" && !ownedBy(current.Fields, change.SessionID) {
+		log.Warn().Str("UserID", change.SessionID).Str("URL", change.URL).Msg("Not restoring object reserved by another session")
+		return nil
+	}
+	data := map[string]interface{}{"custom_fields": change.CustomFields}
+	if change.Tags != nil {
+		data["tags"] = tagRefs(change.Tags)
+	}
+	if err := client.Patch(ctx, change.URL, data); err != nil {
+		return fmt.Errorf("error restoring details of %v: %w", change.URL, err)
 	}
 	return nil
 }
@@ -769,6 +587,11 @@ func updateState(data map[string]interface{}) map[string]interface{} {
 		if customFields["state"] == "Reserved" {
 			customFields["state"] = "Available"
 			customFields["session_id"] = ""
+			for _, field := range MetadataFields {
+				if _, ok := customFields[field]; ok {
+					customFields[field] = ""
+				}
+			}
 		}
 	}
 	return data
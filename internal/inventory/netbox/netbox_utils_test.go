@@ -0,0 +1,170 @@
+package inventory
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"keysight/laas/controller/config"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePEM writes PEM blocks of the given type into a file of dir and
+// returns its path
+func writePEM(t *testing.T, dir string, name string, blockType string, blocks ...[]byte) string {
+	t.Helper()
+	content := []byte{}
+	for _, block := range blocks {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: block})...)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newKeyPair returns a self-signed certificate along with its private key,
+// both DER encoded
+func newKeyPair(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, keyDER
+}
+
+// setTLSConfig sets the NetBox TLS options for the duration of the test
+func setTLSConfig(t *testing.T, caCert string, clientCert string, clientKey string, insecure bool) {
+	t.Helper()
+	caCertBefore, clientCertBefore := *config.Config.NetboxCACert, *config.Config.NetboxClientCert
+	clientKeyBefore, insecureBefore := *config.Config.NetboxClientKey, *config.Config.NetboxInsecureSkipVerify
+	*config.Config.NetboxCACert, *config.Config.NetboxClientCert = caCert, clientCert
+	*config.Config.NetboxClientKey, *config.Config.NetboxInsecureSkipVerify = clientKey, insecure
+	t.Cleanup(func() {
+		*config.Config.NetboxCACert, *config.Config.NetboxClientCert = caCertBefore, clientCertBefore
+		*config.Config.NetboxClientKey, *config.Config.NetboxInsecureSkipVerify = clientKeyBefore, insecureBefore
+	})
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := newKeyPair(t, "controller")
+	otherCert, _ := newKeyPair(t, "other")
+	certPath := writePEM(t, dir, "client.crt", "CERTIFICATE", cert)
+	keyPath := writePEM(t, dir, "client.key", "EC PRIVATE KEY", key)
+	otherCertPath := writePEM(t, dir, "other.crt", "CERTIFICATE", otherCert)
+	bundlePath := writePEM(t, dir, "bundle.pem", "CERTIFICATE", cert, otherCert)
+	garbagePath := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbagePath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missingPath := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name         string
+		caCert       string
+		clientCert   string
+		clientKey    string
+		insecure     bool
+		errText      string
+		rootCAs      bool
+		certificates int
+	}{
+		{name: "defaults"},
+		{name: "insecure", insecure: true},
+		{name: "CA bundle", caCert: bundlePath, rootCAs: true},
+		{name: "missing CA bundle", caCert: missingPath, errText: "failed to read NetBox CA bundle"},
+		{name: "CA bundle without certificates", caCert: garbagePath, errText: "failed to find any certificate"},
+		{name: "client certificate", clientCert: certPath, clientKey: keyPath, certificates: 1},
+		{name: "CA bundle and client certificate", caCert: certPath, clientCert: certPath, clientKey: keyPath, rootCAs: true, certificates: 1},
+		{name: "missing client certificate", clientCert: missingPath, clientKey: keyPath, errText: "failed to load NetBox client certificate"},
+		{name: "missing client key", clientCert: certPath, clientKey: missingPath, errText: "failed to load NetBox client certificate"},
+		{name: "client key of another certificate", clientCert: otherCertPath, clientKey: keyPath, errText: "failed to load NetBox client certificate"},
+		{name: "invalid client certificate", clientCert: garbagePath, clientKey: keyPath, errText: "failed to load NetBox client certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTLSConfig(t, tt.caCert, tt.clientCert, tt.clientKey, tt.insecure)
+			tlsConfig, err := newTLSConfig()
+			if tt.errText != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("newTLSConfig() = %v, want an error containing %q", err, tt.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newTLSConfig() = %v", err)
+			}
+			if tlsConfig.InsecureSkipVerify != tt.insecure {
+				t.Errorf("InsecureSkipVerify = %v, want %v", tlsConfig.InsecureSkipVerify, tt.insecure)
+			}
+			if (tlsConfig.RootCAs != nil) != tt.rootCAs {
+				t.Errorf("RootCAs set = %v, want %v", tlsConfig.RootCAs != nil, tt.rootCAs)
+			}
+			if len(tlsConfig.Certificates) != tt.certificates {
+				t.Errorf("%d client certificates, want %d", len(tlsConfig.Certificates), tt.certificates)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caPath := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	tests := []struct {
+		name     string
+		caCert   string
+		insecure bool
+		wantErr  bool
+	}{
+		{name: "server not trusted", wantErr: true},
+		{name: "server trusted through the CA bundle", caCert: caPath},
+		{name: "server not verified", insecure: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTLSConfig(t, tt.caCert, "", "", tt.insecure)
+			tlsConfig, err := newTLSConfig()
+			if err != nil {
+				t.Fatalf("newTLSConfig() = %v", err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET %s = %v, wantErr %v", server.URL, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package inventory
+
+import (
+	"keysight/laas/controller/internal/netbox"
+	"net/url"
+	"strings"
+)
+
+// Scope limits the inventory to the devices in any of the given sites,
+// locations, tenants and racks, and holding any of the given tags; empty
+// fields don't limit it. Sites, locations, tenants and tags are given by
+// slug, racks by name
+type Scope struct {
+	Sites     []string
+	Locations []string
+	Tenants   []string
+	Racks     []string
+	Tags      []string
+}
+
+// IsZero reports whether the scope doesn't limit the inventory
+func (s Scope) IsZero() bool {
+	return len(s.Sites) == 0 && len(s.Locations) == 0 && len(s.Tenants) == 0 && len(s.Racks) == 0 && len(s.Tags) == 0
+}
+
+// Match reports whether a device with the given site, location, tenant, rack
+// and tags is in scope
+func (s Scope) Match(site string, location string, tenant string, rack string, tags []string) bool {
+	return matchAny(s.Sites, site) && matchAny(s.Locations, location) &&
+		matchAny(s.Tenants, tenant) && matchAny(s.Racks, rack) && matchAny(s.Tags, tags...)
+}
+
+// matchAny reports whether any of the values is one of the wanted ones,
+// regardless of case; no wanted values match everything
+func matchAny(wanted []string, values ...string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, w := range wanted {
+		for _, value := range values {
+			if value != "" && strings.EqualFold(w, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchDevice reports whether the NetBox device is in scope
+func (s Scope) matchDevice(device netbox.Device) bool {
+	tags := []string{}
+	for _, tag := range device.Tags {
+		tags = append(tags, tag.Slug)
+	}
+	rack := ""
+	if device.Rack != nil {
+		rack = device.Rack.Name
+	}
+	return s.Match(slug(device.Site), slug(device.Location), slug(device.Tenant), rack, tags)
+}
+
+// slug returns the slug of a related object, or "" if there's none
+func slug(object *netbox.NestedObject) string {
+	if object == nil {
+		return ""
+	}
+	return object.Slug
+}
+
+// deviceQuery returns the NetBox filters of the devices in scope; racks are
+// filtered by the IDs they were resolved to
+func (s Scope) deviceQuery(rackIDs []string) url.Values {
+	query := url.Values{}
+	add(query, "site", s.Sites)
+	add(query, "location", s.Locations)
+	add(query, "tenant", s.Tenants)
+	add(query, "tag", s.Tags)
+	add(query, "rack_id", rackIDs)
+	return query
+}
+
+// interfaceQuery returns the NetBox filters of the interfaces of the devices
+// in scope, as far as interfaces can be filtered by device; tenants and tags
+// only apply to devices
+func (s Scope) interfaceQuery(rackIDs []string) url.Values {
+	query := url.Values{}
+	add(query, "site", s.Sites)
+	add(query, "location", s.Locations)
+	add(query, "rack_id", rackIDs)
+	return query
+}
+
+// add sets the filter to the values
+func add(query url.Values, filter string, values []string) {
+	for _, value := range values {
+		query.Add(filter, value)
+	}
+}
+
+// scopedLinks returns the links between the given devices only
+func scopedLinks(links []DutLink, devices map[string]bool) []DutLink {
+	scoped := []DutLink{}
+	for _, link := range links {
+		if devices[link.Src.Device] && devices[link.Dst.Device] {
+			scoped = append(scoped, link)
+		}
+	}
+	return scoped
+}
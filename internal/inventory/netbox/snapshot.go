@@ -0,0 +1,308 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"keysight/laas/controller/internal/netbox"
+	"keysight/laas/controller/internal/profile"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshOverlap is subtracted from the last_updated cursor, so that
+	// objects saved while the previous refresh was running aren't missed
+	refreshOverlap = 10 * time.Second
+	// fullRefreshInterval bounds how long the snapshot is only refreshed
+	// incrementally; a full reload picks up changes which don't touch
+	// last_updated, e.g. renamed peer devices
+	fullRefreshInterval = 10 * time.Minute
+	// lastUpdatedFormat is the format of last_updated filter values
+	lastUpdatedFormat = "2006-01-02T15:04:05-07:00"
+)
+
+// Snapshot is an in-memory copy of the NetBox devices and interfaces the
+// inventory is built from; it's refreshed by fetching only the objects
+// updated since the previous refresh
+type Snapshot struct {
+	client *netbox.Client
+	// scope limits the devices fetched, and the interfaces as far as NetBox
+	// filters them by device
+	scope Scope
+	// refreshMu serializes refreshes, mu guards everything below
+	refreshMu  sync.Mutex
+	mu         sync.Mutex
+	devices    map[int]netbox.Device
+	interfaces map[int]netbox.Interface
+	// deletedDevices and deletedInterfaces hold the IDs of the objects
+	// deleted by webhook events since the last full reload, so that late
+	// events about them are dropped
+	deletedDevices    map[int]bool
+	deletedInterfaces map[int]bool
+	cursor            time.Time
+	refreshedAt       time.Time
+	fullReloadAt      time.Time
+	// invalidations counts calls to Invalidate, so that a refresh racing
+	// with one doesn't mark the snapshot up to date
+	invalidations int
+}
+
+// NewSnapshot returns an empty snapshot of the devices in scope in the NetBox
+// at the given API url; it's loaded upon first refresh
+func NewSnapshot(netboxApiURL string, netboxApiToken string, scope Scope) *Snapshot {
+	return &Snapshot{
+		client:            newClient(netboxApiURL, netboxApiToken),
+		scope:             scope,
+		devices:           map[int]netbox.Device{},
+		interfaces:        map[int]netbox.Interface{},
+		deletedDevices:    map[int]bool{},
+		deletedInterfaces: map[int]bool{},
+	}
+}
+
+// ReservedError lists devices and ports (in "device:port" format) found
+// reserved in the inventory while checked before being claimed
+type ReservedError struct {
+	Devices []string
+	Ports   []string
+}
+
+func (e *ReservedError) Error() string {
+	return fmt.Sprintf("resources already reserved in the inventory, devices: %v, ports: %v", e.Devices, e.Ports)
+}
+
+// Age returns the time since the snapshot was last refreshed
+func (s *Snapshot) Age() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refreshedAt.IsZero() {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Since(s.refreshedAt)
+}
+
+// Invalidate marks the snapshot as out of date, e.g. after objects were
+// patched, so that it's refreshed before it's used next
+func (s *Snapshot) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshedAt = time.Time{}
+	s.invalidations++
+}
+
+// Refresh brings the snapshot up to date, fetching the objects updated since
+// the previous refresh, or every object if it's due for a full reload
+func (s *Snapshot) Refresh(ctx context.Context) error {
+	defer profile.LogFuncDuration(time.Now(), "Refresh", "", "inventory")
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	s.mu.Lock()
+	full := s.fullReloadAt.IsZero() || time.Since(s.fullReloadAt) > fullRefreshInterval
+	s.mu.Unlock()
+	complete, err := s.refresh(ctx, full)
+	if err != nil || complete {
+		return err
+	}
+	// objects got deleted since the previous refresh
+	_, err = s.refresh(ctx, true)
+	return err
+}
+
+// refresh fetches every object, or the objects updated since the cursor, and
+// reports whether the snapshot holds as many objects as NetBox afterwards
+func (s *Snapshot) refresh(ctx context.Context, full bool) (bool, error) {
+	started := time.Now()
+	s.mu.Lock()
+	cursor, invalidations := s.cursor, s.invalidations
+	s.mu.Unlock()
+	rackIDs, err := s.rackIDs(ctx)
+	if err != nil {
+		return false, err
+	}
+	deviceQuery, interfaceQuery := s.scope.deviceQuery(rackIDs), s.scope.interfaceQuery(rackIDs)
+	updatedQuery := func(query url.Values) url.Values {
+		updated := url.Values{}
+		for key, values := range query {
+			updated[key] = values
+		}
+		if !full {
+			updated.Set("last_updated__gte", cursor.Add(-refreshOverlap).UTC().Format(lastUpdatedFormat))
+		}
+		return updated
+	}
+	devices, interfaces, err := fetchAll(ctx, s.client, updatedQuery(deviceQuery), updatedQuery(interfaceQuery))
+	if err != nil {
+		return false, err
+	}
+	deviceCount, interfaceCount := len(devices), len(interfaces)
+	if !full {
+		if deviceCount, err = s.client.CountDevices(ctx, deviceQuery); err != nil {
+			return false, fmt.Errorf("failed to count devices: %w", err)
+		}
+		if interfaceCount, err = s.client.CountInterfaces(ctx, interfaceQuery); err != nil {
+			return false, fmt.Errorf("failed to count interfaces: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if full {
+		s.devices = map[int]netbox.Device{}
+		s.interfaces = map[int]netbox.Interface{}
+		s.deletedDevices = map[int]bool{}
+		s.deletedInterfaces = map[int]bool{}
+		s.fullReloadAt = started
+	}
+	for _, device := range devices {
+		s.devices[device.ID] = device
+		s.advance(device.LastUpdated)
+	}
+	for _, iface := range interfaces {
+		s.interfaces[iface.ID] = iface
+		s.advance(iface.LastUpdated)
+	}
+	if s.invalidations == invalidations {
+		s.refreshedAt = started
+	}
+	log.Debug().Bool("Full", full).Int("Devices", len(devices)).Int("Interfaces", len(interfaces)).Msg("Refreshed inventory snapshot")
+	return len(s.devices) == deviceCount && len(s.interfaces) == interfaceCount, nil
+}
+
+// rackIDs returns the IDs of the racks in scope, as NetBox filters devices
+// by rack ID only
+func (s *Snapshot) rackIDs(ctx context.Context) ([]string, error) {
+	if len(s.scope.Racks) == 0 {
+		return nil, nil
+	}
+	racks, err := s.client.ListRacksByName(ctx, s.scope.Racks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get racks %v: %w", s.scope.Racks, err)
+	}
+	if len(racks) == 0 {
+		return nil, fmt.Errorf("failed to find any of the racks: %v", s.scope.Racks)
+	}
+	ids := []string{}
+	for _, rack := range racks {
+		ids = append(ids, fmt.Sprint(rack.ID))
+	}
+	return ids, nil
+}
+
+// fetchAll fetches the devices and interfaces matching the queries
+// concurrently
+func fetchAll(ctx context.Context, client *netbox.Client, deviceQuery url.Values, interfaceQuery url.Values) ([]netbox.Device, []netbox.Interface, error) {
+	var (
+		devices   []netbox.Device
+		deviceErr error
+		done      = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		devices, deviceErr = client.ListDevices(ctx, deviceQuery)
+	}()
+	interfaces, err := client.ListInterfaces(ctx, interfaceQuery)
+	<-done
+	if deviceErr != nil {
+		return nil, nil, fmt.Errorf("failed to get devices: %w", deviceErr)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get interfaces: %w", err)
+	}
+	return devices, interfaces, nil
+}
+
+// advance moves the cursor to the given last_updated value if it's later;
+// the caller must hold the lock
+func (s *Snapshot) advance(lastUpdated string) {
+	updated, err := time.Parse(time.RFC3339Nano, lastUpdated)
+	if err != nil {
+		return
+	}
+	if updated.After(s.cursor) {
+		s.cursor = updated
+	}
+}
+
+// Inventory returns the complete inventory along with the inventory
+// available for reservation, limited to the devices in scope, refreshing the
+// snapshot first if it's older than maxAge
+func (s *Snapshot) Inventory(ctx context.Context, maxAge time.Duration, scope Scope) (Dut, Dut, error) {
+	defer profile.LogFuncDuration(time.Now(), "Inventory", "", "inventory")
+	if s.Age() > maxAge {
+		if err := s.Refresh(ctx); err != nil {
+			return Dut{}, Dut{}, err
+		}
+	}
+
+	s.mu.Lock()
+	devices := make([]netbox.Device, 0, len(s.devices))
+	names := map[string]bool{}
+	for _, device := range s.devices {
+		// devices updated by webhook events may be out of scope
+		if s.scope.matchDevice(device) && scope.matchDevice(device) {
+			devices = append(devices, device)
+			names[device.Name] = true
+		}
+	}
+	interfaces := make([]netbox.Interface, 0, len(s.interfaces))
+	for _, iface := range s.interfaces {
+		interfaces = append(interfaces, iface)
+	}
+	s.mu.Unlock()
+	// keep the inventory stable across calls
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	sort.Slice(interfaces, func(i, j int) bool { return interfaces[i].ID < interfaces[j].ID })
+
+	records := inventoryDevices(devices, interfaces)
+	links := deviceLinks(interfaces)
+	if !s.scope.IsZero() || !scope.IsZero() {
+		links = scopedLinks(links, names)
+	}
+	return createInventory(records, links, "all"), createInventory(records, links, "NA"), nil
+}
+
+// Recheck fetches the current state of the devices and ports picked for a
+// reservation, updating the snapshot with it; a ReservedError is returned if
+// any of them got reserved since the snapshot was taken
+func (s *Snapshot) Recheck(ctx context.Context, devices []ReservedDevice) error {
+	defer profile.LogFuncDuration(time.Now(), "Recheck", "", "inventory")
+	deviceByName, interfaceByPort, err := fetchReserved(ctx, s.client, devices)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for _, device := range deviceByName {
+		s.devices[device.ID] = device
+	}
+	for _, iface := range interfaceByPort {
+		s.interfaces[iface.ID] = iface
+	}
+	s.mu.Unlock()
+
+	conflict := &ReservedError{}
+	for _, reserved := range devices {
+		device, ok := deviceByName[strings.ToLower(reserved.Name)]
+		if !ok {
+			return fmt.Errorf("failed to find the device: %v", reserved.Name)
+		}
+		role := strings.ToLower(reserved.Role)
+		if !reserved.PortsOnly && role != "ate" && role != "l1s" && device.Fields.Reserved() {
+			conflict.Devices = append(conflict.Devices, reserved.Name)
+		}
+		for _, port := range reserved.Ports {
+			iface, ok := interfaceByPort[strings.ToLower(reserved.Name+":"+port)]
+			if ok && iface.Fields.Reserved() {
+				conflict.Ports = append(conflict.Ports, reserved.Name+":"+port)
+			}
+		}
+	}
+	if len(conflict.Devices) != 0 || len(conflict.Ports) != 0 {
+		return conflict
+	}
+	return nil
+}
@@ -2,11 +2,10 @@ package inventory
 
 // go clean -modcache
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"keysight/laas/controller/config"
 	"keysight/laas/controller/internal/profile"
-	"keysight/laas/controller/internal/utils"
 	"os"
 	"strings"
 	"time"
@@ -114,71 +113,30 @@ func AddDevice(counter *Counter, devices map[int]Device, name, role, deviceType,
 	return devices
 }
 
-func createInventory(listOfDicts []map[string]interface{}, linksOfDicts []map[string]interface{}, inventoryFile string, inventoryType string) {
+func createInventory(records []inventoryDevice, links []DutLink, inventoryType string) Dut {
 	defer profile.LogFuncDuration(time.Now(), "createInventory", "", "inventory")
-	// Parse JSON output into Go data structure
 	// Initialize an empty map for devices
 	devices := make(map[int]Device)
 	devicesSlice := make(map[string]Device)
-	for _, dict := range listOfDicts {
-		// Get values using keys
-		name := dict["Name"].(string)
-		role := dict["Role"].(string)
-		deviceType := dict["DeviceType"].(string)
-		// manufacturer := dict["Manufacturer"].(string)
-		manufacturer := dict["Vendor"].(string)
-		platform := dict["Platform"].(string)
-		image := dict["Image"].(string)
-		state := dict["State"].(string)
-		connection := dict["Connec"].(string)
-		credential := dict["Cred"].(string)
-		handlename := dict["HandleName"].(string)
-		via := dict["Via"].(string)
-		inventoryAttrs := dict["inventoryAttr"].(map[string]interface{})
-		interfaces := dict["interfaces"].([]interface{})
+	for _, record := range records {
 		idCounter := &Counter{}
 		if strings.ToLower(inventoryType) == "all" {
-			devices = AddDevice(idCounter, devices, name, role, deviceType, platform, image, state, manufacturer, connection, credential, handlename, via, inventoryAttrs, interfaces)
+			devices = AddDevice(idCounter, devices, record.Name, record.Role, record.DeviceType, record.Platform, record.Image, record.State, record.Vendor, record.Connection, record.Credential, record.HandleName, record.Via, record.Attributes, record.Interfaces)
 		} else {
-			if strings.ToLower(state) != "reserved" {
-				devices = AddDevice(idCounter, devices, name, role, deviceType, platform, image, state, manufacturer, connection, credential, handlename, via, inventoryAttrs, interfaces)
+			if strings.ToLower(record.State) != "reserved" {
+				devices = AddDevice(idCounter, devices, record.Name, record.Role, record.DeviceType, record.Platform, record.Image, record.State, record.Vendor, record.Connection, record.Credential, record.HandleName, record.Via, record.Attributes, record.Interfaces)
 			} else {
 				devices = make(map[int]Device)
 			}
 		}
 		// Convert the map to a slice
-		if strings.ToLower(role) == "dut" || strings.ToLower(role) == "ate" || strings.ToLower(role) == "tgen" || strings.ToLower(role) == "l1s" {
+		role := strings.ToLower(record.Role)
+		if role == "dut" || role == "ate" || role == "tgen" || role == "l1s" {
 			for _, device := range devices {
 				devicesSlice[device.ID] = device
 			}
 		}
 	}
-	// Initialize an empty slice for links
-	links := make([]DutLink, 0)
-
-	// Iterate through linksOfDicts and convert them to DutLink instances
-	for _, linkDict := range linksOfDicts {
-		src := linkDict["src"].(string)
-		dst := linkDict["dst"].(string)
-		srcDevice, srcPort := utils.SplitString(src)
-		dstDevice, dstPort := utils.SplitString(dst)
-
-		// Create DutLinkEndpoint instances for Src and Dst
-		srcEndpoint := DutLinkEndpoint{
-			Device: srcDevice,
-			Port:   srcPort,
-		}
-		dstEndpoint := DutLinkEndpoint{
-			Device: dstDevice,
-			Port:   dstPort,
-		}
-		// Create DutLink instance and append it to the links slice
-		link := DutLink{
-			Src: srcEndpoint,
-			Dst: dstEndpoint,
-		}
-		links = append(links, link)
-	}
 
 	// Create Dut with devices and links
 	duts := Dut{
@@ -186,20 +144,8 @@ func createInventory(listOfDicts []map[string]interface{}, linksOfDicts []map[st
 		Devices: devicesSlice,
 		Links:   links,
 	}
-	// Marshal the Dut into JSON
-	dutsJSON, err := json.MarshalIndent(duts, "", "    ")
-	if err != nil {
-		log.Fatal().Msgf("Failed to marshal duts: %v", err)
-		return
-	}
-	// Write JSON to a file
-	err = os.WriteFile(inventoryFile, dutsJSON, 0644)
-	if err != nil {
-		log.Fatal().Msgf("Failed to write Dut JSON: %v", err)
-		return
-	}
-	log.Info().Msgf("JSON written to %v", inventoryFile)
-	log.Debug().Interface("Inventory data", dutsJSON).Msg("Obtained inventory data")
+	log.Debug().Str("Type", inventoryType).Interface("Inventory data", duts).Msg("Obtained inventory data")
+	return duts
 }
 
 // FileExists checks if a file exists
@@ -211,51 +157,71 @@ func FileExists(filePath string) (bool, error) {
 	return true, nil
 }
 
-func UpdateInventory(netboxApiURL string, netboxApiToken string, userID string, devices map[string][]map[string]interface{}) (string, error) {
-	filePath := "output.json"
-	exists, err := FileExists(filePath)
-	if err != nil {
-		// log.Fatal().Msgf("Failed to read file: %v, Error: %v", filePath, err) // Log the error and terminate the program
-		return "", fmt.Errorf("failed to read file: %v, Error: %v", filePath, err)
+// ReservedDevice is a device picked for a session along with the names of
+// the ports picked on it; with PortsOnly set, only the ports are updated
+type ReservedDevice struct {
+	Name      string
+	Role      string
+	Ports     []string
+	PortsOnly bool
+}
+
+// StateChange holds the state and session_id custom fields of a NetBox object
+// as they were before it got patched by UpdateInventory; SessionID is the
+// session which patched it, the object is only restored while no other
+// session holds it. Tags holds the IDs of the tags of the object if session
+// tags were changed along
+type StateChange struct {
+	URL          string
+	CustomFields map[string]interface{}
+	SessionID    string
+	Tags         []int
+}
+
+// UpdateInventory marks the devices and ports as reserved by userID, along
+// with the session metadata keyed by custom field name; every object patched,
+// even if the update fails midway, is appended to changes
+func UpdateInventory(ctx context.Context, netboxApiURL string, netboxApiToken string, userID string, metadata map[string]string, releaseState map[string][]map[string]interface{}, devices []ReservedDevice, changes *[]StateChange) (string, error) {
+	updateerr := updateDevicesData(ctx, devices, netboxApiURL, netboxApiToken, userID, metadata, releaseState, changes, false)
+	if updateerr != nil {
+		// log.Fatal().Msgf("updateDevicesData failed: %v", updateerr)
+		return "", fmt.Errorf("%w", updateerr)
 	}
-	if exists {
-		updateerr := updateDevicesData(filePath, netboxApiURL, netboxApiToken, userID, devices)
-		if updateerr != nil {
-			// log.Fatal().Msgf("updateDevicesData failed: %v", updateerr)
-			return "", fmt.Errorf("%v", updateerr)
-		} else {
-			log.Info().Msg("Node/Interfaces details updated successfully as per testbed details.")
-			return "Node/Interfaces details updated successfully as per testbed details.", nil
-		}
-	} else {
-		// log.Fatal().Msgf("Output file does not exist.")
-		return "", fmt.Errorf("output file does not exist: %v", filePath)
+	log.Info().Msg("Node/Interfaces details updated successfully as per testbed details.")
+	return "Node/Interfaces details updated successfully as per testbed details.", nil
+}
+
+// ReleaseInventory marks the devices and ports reserved by userID as
+// available; every object patched is appended to changes
+func ReleaseInventory(ctx context.Context, netboxApiURL string, netboxApiToken string, userID string, devices []ReservedDevice, changes *[]StateChange) error {
+	if err := updateDevicesData(ctx, devices, netboxApiURL, netboxApiToken, userID, nil, nil, changes, true); err != nil {
+		return fmt.Errorf("%v", err)
 	}
+	log.Info().Str("UserID", userID).Msg("Node/Interfaces details released")
+	return nil
 }
 
-func GetCreateInvFromNetbox(netboxApiURL string, netboxApiToken string) {
+// GetCreateInvFromNetbox returns the complete inventory along with the
+// inventory available for reservation
+func GetCreateInvFromNetbox(ctx context.Context, netboxApiURL string, netboxApiToken string) (Dut, Dut, error) {
 	defer profile.LogFuncDuration(time.Now(), "GetCreateInvFromNetbox", "", "inventory")
 
-	output := getDevicesData(netboxApiURL, netboxApiToken)
-	var listOfDicts []map[string]interface{}
-	err := json.Unmarshal(output, &listOfDicts)
-	if err != nil {
-		log.Fatal().Msgf("Failed to unmarsh the JSON file: %v", err)
-		return
-	}
-	linksoutput := getDevicesLinks(netboxApiURL, netboxApiToken)
-	var linksOfDicts []map[string]interface{}
-	err = json.Unmarshal(linksoutput, &linksOfDicts)
+	devices, interfaces, err := fetchAll(ctx, newClient(netboxApiURL, netboxApiToken), nil, nil)
 	if err != nil {
-		log.Fatal().Msgf("Failed to unmarsh the JSON file: %v", err)
-		return
+		return Dut{}, Dut{}, err
 	}
-	createInventory(listOfDicts, linksOfDicts, "inventory_global.json", "all")
-	createInventory(listOfDicts, linksOfDicts, "inventory.json", "NA")
+	records := inventoryDevices(devices, interfaces)
+	links := deviceLinks(interfaces)
+	return createInventory(records, links, "all"), createInventory(records, links, "NA"), nil
+}
+
+// RestoreState patches a NetBox object back to the state recorded in change
+func RestoreState(ctx context.Context, netboxApiToken string, change StateChange) error {
+	return restoreObjectState(ctx, change, netboxApiToken)
 }
 
-func ReleaseStateWithInvenData(releaseState map[string][]map[string]interface{}, user_id string) error {
-	updateerr := updateNodeState(releaseState, user_id)
+func ReleaseStateWithInvenData(ctx context.Context, releaseState map[string][]map[string]interface{}, user_id string) error {
+	updateerr := updateNodeState(ctx, releaseState, user_id)
 	if updateerr != nil {
 		return fmt.Errorf("%v", updateerr)
 	}
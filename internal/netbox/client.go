@@ -0,0 +1,434 @@
+// Package netbox is a typed client for the parts of the NetBox REST API the
+// controller uses
+package netbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pageSize is the number of objects requested per page when listing
+	pageSize = 1000
+	// bulkFilterSize is the number of values passed at most to a single
+	// filter, e.g. ?name=a&name=b, keeping request URLs reasonably short
+	bulkFilterSize = 100
+)
+
+// retryBackoff is the delay before the first retry, doubled afterwards
+var retryBackoff = 500 * time.Millisecond
+
+// Client talks to the NetBox REST API at BaseURL, e.g. "http://netbox/api/"
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+	// Timeout bounds each attempt of a request; zero means no timeout
+	Timeout time.Duration
+	// Retries is the number of times a request failing with a 429 or 5xx
+	// status code, or a transport error, is retried; requests which aren't
+	// idempotent, i.e. POST, are only retried upon a 429 status code or a
+	// connection failure, when they're known not to have been applied
+	Retries int
+	// Slots, if set, bounds the number of concurrent requests; it may be
+	// shared between clients
+	Slots chan struct{}
+}
+
+// NewClient returns a client for the NetBox API at baseURL using token
+func NewClient(baseURL string, token string) *Client {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &Client{BaseURL: baseURL, Token: token, HTTPClient: &http.Client{}}
+}
+
+// APIError is returned when NetBox answers with an unexpected status code
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s failed with status code %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// retryable reports whether a request failing with the status code may
+// succeed later
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// idempotent reports whether sending a request with the method twice has the
+// same effect as sending it once
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// notSent reports whether a request failed before it was sent, as the
+// connection to NetBox couldn't be set up
+func notSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// page is a single page of a NetBox list response
+type page[T any] struct {
+	Count   int     `json:"count"`
+	Next    *string `json:"next"`
+	Results []T     `json:"results"`
+}
+
+// do sends a request to the absolute url and decodes the JSON response into
+// out, unless out is nil; it's retried with exponential backoff upon
+// transient failures
+func (c *Client) do(ctx context.Context, method string, url string, body interface{}, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to marshal request body for %s: %w", url, err)
+		}
+	}
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		delay, err := c.try(ctx, method, url, data, out)
+		if err == nil || attempt >= c.Retries || ctx.Err() != nil {
+			return err
+		}
+		var apiErr *APIError
+		isAPIErr := errors.As(err, &apiErr)
+		if isAPIErr && !retryable(apiErr.StatusCode) {
+			return err
+		}
+		// a request which isn't idempotent may have been applied, unless
+		// NetBox turned it down for rate limiting or it was never sent
+		if !idempotent(method) {
+			rateLimited := isAPIErr && apiErr.StatusCode == http.StatusTooManyRequests
+			if !rateLimited && (isAPIErr || !notSent(err)) {
+				return err
+			}
+		}
+		if delay == 0 {
+			delay = backoff
+		}
+		backoff *= 2
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// try sends a request once; a delay is returned along with the error if
+// NetBox asked to retry no sooner than that
+func (c *Client) try(ctx context.Context, method string, url string, body []byte, out interface{}) (time.Duration, error) {
+	if c.Slots != nil {
+		select {
+		case c.Slots <- struct{}{}:
+			defer func() { <-c.Slots }()
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	req.Header.Set("Authorization", "Token "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s failed: %w", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read response of %s %s: %w", method, url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var delay time.Duration
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+		return delay, &APIError{Method: method, URL: url, StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	if out == nil {
+		return 0, nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return 0, fmt.Errorf("failed to decode response of %s %s: %w", method, url, err)
+	}
+	return 0, nil
+}
+
+// endpoint returns the absolute url of an API path along with its query
+func (c *Client) endpoint(path string, query url.Values) string {
+	if len(query) == 0 {
+		return c.BaseURL + path
+	}
+	return c.BaseURL + path + "?" + query.Encode()
+}
+
+// list fetches every object of an API path matching the query; pages after
+// the first one are fetched concurrently
+func list[T any](ctx context.Context, c *Client, path string, query url.Values) ([]T, error) {
+	q := url.Values{}
+	for key, values := range query {
+		q[key] = values
+	}
+	q.Set("limit", fmt.Sprint(pageSize))
+
+	first := page[T]{}
+	if err := c.do(ctx, http.MethodGet, c.endpoint(path, q), nil, &first); err != nil {
+		return nil, err
+	}
+	// NetBox may serve fewer objects per page than asked for
+	size := len(first.Results)
+	if first.Next == nil || size == 0 {
+		return first.Results, nil
+	}
+	pages := make([][]T, (first.Count+size-1)/size)
+	pages[0] = first.Results
+	err := parallel(ctx, len(pages)-1, func(ctx context.Context, i int) error {
+		pq := url.Values{}
+		for key, values := range q {
+			pq[key] = values
+		}
+		pq.Set("limit", fmt.Sprint(size))
+		pq.Set("offset", fmt.Sprint((i+1)*size))
+		result := page[T]{}
+		if err := c.do(ctx, http.MethodGet, c.endpoint(path, pq), nil, &result); err != nil {
+			return err
+		}
+		pages[i+1] = result.Results
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects := []T{}
+	for _, results := range pages {
+		objects = append(objects, results...)
+	}
+	return objects, nil
+}
+
+// listIn fetches every object of an API path whose field matches any of the
+// values, using bulk filters; chunks of values are fetched concurrently
+func listIn[T any](ctx context.Context, c *Client, path string, field string, values []string) ([]T, error) {
+	chunks := [][]string{}
+	for start := 0; start < len(values); start += bulkFilterSize {
+		end := start + bulkFilterSize
+		if end > len(values) {
+			end = len(values)
+		}
+		chunks = append(chunks, values[start:end])
+	}
+	results := make([][]T, len(chunks))
+	err := parallel(ctx, len(chunks), func(ctx context.Context, i int) error {
+		objects, err := list[T](ctx, c, path, url.Values{field: chunks[i]})
+		results[i] = objects
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects := []T{}
+	for _, result := range results {
+		objects = append(objects, result...)
+	}
+	return objects, nil
+}
+
+// parallel runs fn for 0..n-1 concurrently and returns the first error; the
+// context passed to fn is cancelled as soon as one of them fails. Requests
+// actually in flight are bounded by the client slots
+func parallel(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// count returns the number of objects of an API path matching the query,
+// fetching a single object at most
+func count(ctx context.Context, c *Client, path string, query url.Values) (int, error) {
+	q := url.Values{}
+	for key, values := range query {
+		q[key] = values
+	}
+	q.Set("limit", "1")
+	q.Set("brief", "true")
+
+	result := page[json.RawMessage]{}
+	if err := c.do(ctx, http.MethodGet, c.endpoint(path, q), nil, &result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// ListDevices returns the devices matching the query, e.g. name=<device>
+func (c *Client) ListDevices(ctx context.Context, query url.Values) ([]Device, error) {
+	return list[Device](ctx, c, "dcim/devices/", query)
+}
+
+// ListDevicesByName returns the devices with any of the given names
+func (c *Client) ListDevicesByName(ctx context.Context, names []string) ([]Device, error) {
+	return listIn[Device](ctx, c, "dcim/devices/", "name", names)
+}
+
+// ListInterfaces returns the interfaces matching the query, e.g. device=<name>
+func (c *Client) ListInterfaces(ctx context.Context, query url.Values) ([]Interface, error) {
+	return list[Interface](ctx, c, "dcim/interfaces/", query)
+}
+
+// ListInterfacesByDevice returns the interfaces of the devices with the
+// given IDs
+func (c *Client) ListInterfacesByDevice(ctx context.Context, deviceIDs []int) ([]Interface, error) {
+	ids := make([]string, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return listIn[Interface](ctx, c, "dcim/interfaces/", "device_id", ids)
+}
+
+// CountDevices returns the number of devices matching the query
+func (c *Client) CountDevices(ctx context.Context, query url.Values) (int, error) {
+	return count(ctx, c, "dcim/devices/", query)
+}
+
+// CountInterfaces returns the number of interfaces matching the query
+func (c *Client) CountInterfaces(ctx context.Context, query url.Values) (int, error) {
+	return count(ctx, c, "dcim/interfaces/", query)
+}
+
+// ListCables returns the cables matching the query
+func (c *Client) ListCables(ctx context.Context, query url.Values) ([]Cable, error) {
+	return list[Cable](ctx, c, "dcim/cables/", query)
+}
+
+// ListRacksByName returns the racks with any of the given names
+func (c *Client) ListRacksByName(ctx context.Context, names []string) ([]Rack, error) {
+	return listIn[Rack](ctx, c, "dcim/racks/", "name", names)
+}
+
+// ListRoles returns the device roles matching the query
+func (c *Client) ListRoles(ctx context.Context, query url.Values) ([]Role, error) {
+	return list[Role](ctx, c, "dcim/device-roles/", query)
+}
+
+// ListPlatforms returns the platforms matching the query
+func (c *Client) ListPlatforms(ctx context.Context, query url.Values) ([]Platform, error) {
+	return list[Platform](ctx, c, "dcim/platforms/", query)
+}
+
+// DeviceByName returns the device with the given name, or nil if there's none
+func (c *Client) DeviceByName(ctx context.Context, name string) (*Device, error) {
+	devices, err := c.ListDevices(ctx, url.Values{"name": {name}})
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		if strings.EqualFold(devices[i].Name, name) {
+			return &devices[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// TagBySlug returns the tag with the given slug, or nil if there's none
+func (c *Client) TagBySlug(ctx context.Context, slug string) (*Tag, error) {
+	tags, err := list[Tag](ctx, c, "extras/tags/", url.Values{"slug": {slug}})
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return &tags[0], nil
+}
+
+// CreateTag creates a tag and returns it as created
+func (c *Client) CreateTag(ctx context.Context, tag Tag) (*Tag, error) {
+	created := Tag{}
+	if err := c.do(ctx, http.MethodPost, c.endpoint("extras/tags/", nil), tag, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// CreateJournalEntry adds a journal entry to an object
+func (c *Client) CreateJournalEntry(ctx context.Context, entry JournalEntry) error {
+	return c.do(ctx, http.MethodPost, c.endpoint("extras/journal-entries/", nil), entry, nil)
+}
+
+// Get fetches the object at the absolute url into out
+func (c *Client) Get(ctx context.Context, url string, out interface{}) error {
+	return c.do(ctx, http.MethodGet, url, nil, out)
+}
+
+// Patch partially updates the object at the absolute url with data
+func (c *Client) Patch(ctx context.Context, url string, data interface{}) error {
+	return c.do(ctx, http.MethodPatch, url, data, nil)
+}
+
+// Delete deletes the object at the absolute url
+func (c *Client) Delete(ctx context.Context, url string) error {
+	return c.do(ctx, http.MethodDelete, url, nil, nil)
+}
+
+// PatchCustomFields updates custom fields of the object at the absolute url
+func (c *Client) PatchCustomFields(ctx context.Context, url string, customFields map[string]interface{}) error {
+	return c.Patch(ctx, url, map[string]interface{}{"custom_fields": customFields})
+}
@@ -0,0 +1,326 @@
+package netbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTransport counts the requests handed to the HTTP transport,
+// including those which never reach the server
+type countingTransport struct {
+	attempts int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.attempts, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestClient returns a client for the test server, retrying requests
+// with a short backoff
+func newTestClient(t *testing.T, server *httptest.Server, retries int) (*Client, *countingTransport) {
+	t.Helper()
+	backoff := retryBackoff
+	retryBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { retryBackoff = backoff })
+	transport := &countingTransport{}
+	c := NewClient(server.URL+"/api", "secret")
+	c.HTTPClient = &http.Client{Transport: transport}
+	c.Retries = retries
+	return c, transport
+}
+
+// failingHandler answers the first failures requests with the status code
+// and succeeds afterwards
+func failingHandler(failures int32, statusCode int, header http.Header) (http.Handler, *int32) {
+	var calls int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			for key, values := range header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(statusCode)
+			fmt.Fprint(w, `{"detail": "failed"}`)
+			return
+		}
+		fmt.Fprint(w, `{"id": 1, "name": "laas", "slug": "laas"}`)
+	}), &calls
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		statusCode int
+		failures   int32
+		retries    int
+		calls      int32
+		wantErr    bool
+	}{
+		{name: "GET retried upon 503", method: http.MethodGet, statusCode: http.StatusServiceUnavailable, failures: 2, retries: 3, calls: 3},
+		{name: "GET retried upon 429", method: http.MethodGet, statusCode: http.StatusTooManyRequests, failures: 1, retries: 3, calls: 2},
+		{name: "GET gives up after the retries", method: http.MethodGet, statusCode: http.StatusBadGateway, failures: 5, retries: 2, calls: 3, wantErr: true},
+		{name: "GET not retried upon 404", method: http.MethodGet, statusCode: http.StatusNotFound, failures: 1, retries: 3, calls: 1, wantErr: true},
+		{name: "PATCH retried upon 500", method: http.MethodPatch, statusCode: http.StatusInternalServerError, failures: 1, retries: 3, calls: 2},
+		{name: "POST not retried upon 500", method: http.MethodPost, statusCode: http.StatusInternalServerError, failures: 1, retries: 3, calls: 1, wantErr: true},
+		{name: "POST not retried upon 502", method: http.MethodPost, statusCode: http.StatusBadGateway, failures: 1, retries: 3, calls: 1, wantErr: true},
+		{name: "POST retried upon 429", method: http.MethodPost, statusCode: http.StatusTooManyRequests, failures: 2, retries: 3, calls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, calls := failingHandler(tt.failures, tt.statusCode, nil)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			c, _ := newTestClient(t, server, tt.retries)
+
+			err := c.do(context.Background(), tt.method, c.endpoint("extras/tags/", nil), Tag{Name: "laas"}, &Tag{})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("do() = %v, wantErr %v", err, tt.wantErr)
+			}
+			var apiErr *APIError
+			if tt.wantErr && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.statusCode) {
+				t.Errorf("do() = %v, want an APIError with status code %d", err, tt.statusCode)
+			}
+			if got := atomic.LoadInt32(calls); got != tt.calls {
+				t.Errorf("server got %d requests, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	c, _ := newTestClient(t, server, 3)
+	retryBackoff = 50 * time.Millisecond
+
+	if err := c.Get(context.Background(), c.endpoint("extras/tags/", nil), &Tag{}); err == nil {
+		t.Fatal("Get() = nil, want an error")
+	}
+	if len(times) != 4 {
+		t.Fatalf("server got %d requests, want 4", len(times))
+	}
+	// the delay doubles between retries
+	for i, want := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := times[i+1].Sub(times[i]); got < want {
+			t.Errorf("delay before retry %d = %v, want at least %v", i+1, got, want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	handler, calls := failingHandler(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	c, _ := newTestClient(t, server, 1)
+
+	start := time.Now()
+	if err := c.Get(context.Background(), c.endpoint("extras/tags/1/", nil), &Tag{}); err != nil {
+		t.Fatalf("Get() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Get() retried after %v, want at least the 1s asked for", elapsed)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("server got %d requests, want 2", got)
+	}
+
+	// the context bounds the wait for the retry
+	handler, _ = failingHandler(1, http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}})
+	slow := httptest.NewServer(handler)
+	defer slow.Close()
+	c, _ = newTestClient(t, slow, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var apiErr *APIError
+	if err := c.Get(ctx, c.endpoint("extras/tags/1/", nil), &Tag{}); !errors.As(err, &apiErr) {
+		t.Errorf("Get() = %v, want the 429 APIError once the context is done", err)
+	}
+}
+
+func TestRetryNotSent(t *testing.T) {
+	// a closed listener refuses connections, the requests are never sent
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &httptest.Server{URL: "http://" + listener.Addr().String()}
+	listener.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			c, transport := newTestClient(t, server, 2)
+			err := c.do(context.Background(), method, c.endpoint("extras/tags/", nil), Tag{Name: "laas"}, nil)
+			if !notSent(err) {
+				t.Errorf("do() = %v, want a dial error", err)
+			}
+			if got := atomic.LoadInt32(&transport.attempts); got != 3 {
+				t.Errorf("client made %d attempts, want 3", got)
+			}
+		})
+	}
+}
+
+// pagingHandler serves count tags at most pageLimit at a time, whatever the
+// limit asked for, and records the highest number of concurrent requests
+type pagingHandler struct {
+	count     int
+	pageLimit int
+	delay     time.Duration
+	inFlight  int32
+	maxFlight int32
+	requests  int32
+}
+
+func (h *pagingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&h.requests, 1)
+	current := atomic.AddInt32(&h.inFlight, 1)
+	defer atomic.AddInt32(&h.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&h.maxFlight)
+		if current <= max || atomic.CompareAndSwapInt32(&h.maxFlight, max, current) {
+			break
+		}
+	}
+	time.Sleep(h.delay)
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit > h.pageLimit {
+		limit = h.pageLimit
+	}
+	results := ""
+	for i := offset; i < offset+limit && i < h.count; i++ {
+		if results != "" {
+			results += ","
+		}
+		results += fmt.Sprintf(`{"id": %d, "name": "tag%d", "slug": "tag%d"}`, i+1, i, i)
+	}
+	next := "null"
+	if offset+limit < h.count {
+		next = strconv.Quote(fmt.Sprintf("http://%s%s?limit=%d&offset=%d", r.Host, r.URL.Path, limit, offset+limit))
+	}
+	fmt.Fprintf(w, `{"count": %d, "next": %s, "results": [%s]}`, h.count, next, results)
+}
+
+func TestListPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		count     int
+		pageLimit int
+		requests  int32
+	}{
+		{name: "no objects", count: 0, pageLimit: 3, requests: 1},
+		{name: "single page", count: 3, pageLimit: 3, requests: 1},
+		{name: "partial last page", count: 10, pageLimit: 3, requests: 4},
+		{name: "full pages", count: 9, pageLimit: 3, requests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &pagingHandler{count: tt.count, pageLimit: tt.pageLimit}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			c, _ := newTestClient(t, server, 0)
+
+			tags, err := list[Tag](context.Background(), c, "extras/tags/", url.Values{"q": {"tag"}})
+			if err != nil {
+				t.Fatalf("list() = %v", err)
+			}
+			if len(tags) != tt.count {
+				t.Fatalf("list() returned %d tags, want %d", len(tags), tt.count)
+			}
+			for i, tag := range tags {
+				if tag.ID != i+1 {
+					t.Errorf("tag %d has ID %d, want pages in order", i, tag.ID)
+				}
+			}
+			if got := atomic.LoadInt32(&handler.requests); got != tt.requests {
+				t.Errorf("server got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestListPaginationFailure(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Query().Get("offset") == "2" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"count": 6, "next": "next", "results": [{"id": 1}, {"id": 2}]}`)
+	}))
+	defer server.Close()
+	c, _ := newTestClient(t, server, 0)
+
+	var apiErr *APIError
+	if _, err := list[Tag](context.Background(), c, "extras/tags/", nil); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("list() = %v, want the 403 of the failing page", err)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name  string
+		slots int
+	}{
+		{name: "single slot", slots: 1},
+		{name: "two slots", slots: 2},
+		{name: "four slots", slots: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &pagingHandler{count: 20, pageLimit: 2, delay: 20 * time.Millisecond}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			c, _ := newTestClient(t, server, 0)
+			c.Slots = make(chan struct{}, tt.slots)
+
+			// two lists share the slots of the client
+			var wg sync.WaitGroup
+			errs := make([]error, 2)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, errs[i] = c.ListDevicesByName(context.Background(), []string{"dut1"})
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					t.Fatalf("ListDevicesByName() = %v", err)
+				}
+			}
+			if got := atomic.LoadInt32(&handler.maxFlight); got > int32(tt.slots) {
+				t.Errorf("server got %d concurrent requests, want %d at most", got, tt.slots)
+			}
+			if got := atomic.LoadInt32(&handler.maxFlight); tt.slots > 1 && got < 2 {
+				t.Errorf("server got %d concurrent requests, want pages fetched concurrently", got)
+			}
+			if len(c.Slots) != 0 {
+				t.Errorf("%d slots still held", len(c.Slots))
+			}
+		})
+	}
+}
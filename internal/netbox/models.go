@@ -0,0 +1,288 @@
+package netbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NestedObject is the brief form of a related object, e.g. a device type
+type NestedObject struct {
+	ID   int    `json:"id"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// NestedDevice is the brief form of a device related to another object
+type NestedDevice struct {
+	ID   int    `json:"id"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+// IPAddress is the brief form of an IP address
+type IPAddress struct {
+	ID      int    `json:"id"`
+	URL     string `json:"url"`
+	Address string `json:"address"`
+}
+
+// Device is a NetBox device
+type Device struct {
+	ID         int           `json:"id"`
+	URL        string        `json:"url"`
+	Name       string        `json:"name"`
+	DeviceType NestedObject  `json:"device_type"`
+	Role       *NestedObject `json:"role"`
+	// DeviceRole is the role of the device in NetBox releases before 4.0
+	DeviceRole     *NestedObject `json:"device_role"`
+	Platform       *NestedObject `json:"platform"`
+	Site           *NestedObject `json:"site"`
+	Location       *NestedObject `json:"location"`
+	Tenant         *NestedObject `json:"tenant"`
+	Rack           *NestedObject `json:"rack"`
+	PrimaryIP      *IPAddress    `json:"primary_ip"`
+	InterfaceCount int           `json:"interface_count"`
+	// CustomFields holds every custom field, passed through as inventory
+	// attributes; Fields holds those the controller depends on
+	CustomFields CustomFields `json:"custom_fields"`
+	Fields       DeviceFields `json:"-"`
+	Tags         []Tag        `json:"tags"`
+	LastUpdated  string       `json:"last_updated"`
+}
+
+func (d *Device) UnmarshalJSON(data []byte) error {
+	type device Device
+	if err := json.Unmarshal(data, (*device)(d)); err != nil {
+		return err
+	}
+	d.Fields = d.CustomFields.deviceFields()
+	return nil
+}
+
+// RoleName returns the name of the role of the device, or "" if it has none
+func (d *Device) RoleName() string {
+	if d.Role != nil {
+		return d.Role.Name
+	}
+	if d.DeviceRole != nil {
+		return d.DeviceRole.Name
+	}
+	return ""
+}
+
+// Interface is a NetBox device interface
+type Interface struct {
+	ID     int          `json:"id"`
+	URL    string       `json:"url"`
+	Name   string       `json:"name"`
+	Device NestedDevice `json:"device"`
+	// Speed is given in Kbps, nil if unset
+	Speed        *int            `json:"speed"`
+	Cable        *NestedObject   `json:"cable"`
+	LinkPeers    []LinkPeer      `json:"link_peers"`
+	CustomFields CustomFields    `json:"custom_fields"`
+	Fields       InterfaceFields `json:"-"`
+	Tags         []Tag           `json:"tags"`
+	LastUpdated  string          `json:"last_updated"`
+}
+
+func (i *Interface) UnmarshalJSON(data []byte) error {
+	type iface Interface
+	if err := json.Unmarshal(data, (*iface)(i)); err != nil {
+		return err
+	}
+	i.Fields = i.CustomFields.interfaceFields()
+	return nil
+}
+
+// LinkPeer is an object at the far end of the cable attached to an interface
+type LinkPeer struct {
+	ID   int    `json:"id"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+	// Device is nil for peers which aren't device components, e.g. circuit
+	// terminations
+	Device *NestedDevice `json:"device"`
+}
+
+// Cable is a NetBox cable
+type Cable struct {
+	ID            int                `json:"id"`
+	URL           string             `json:"url"`
+	Label         string             `json:"label"`
+	ATerminations []CableTermination `json:"a_terminations"`
+	BTerminations []CableTermination `json:"b_terminations"`
+}
+
+// CableTermination is an object a cable is attached to
+type CableTermination struct {
+	ObjectType string   `json:"object_type"`
+	ObjectID   int      `json:"object_id"`
+	Object     LinkPeer `json:"object"`
+}
+
+// Object holds the fields common to the NetBox objects the controller
+// reserves, e.g. devices and interfaces
+type Object struct {
+	ID           int               `json:"id"`
+	URL          string            `json:"url"`
+	CustomFields CustomFields      `json:"custom_fields"`
+	Fields       ReservationFields `json:"-"`
+	Tags         []Tag             `json:"tags"`
+	LastUpdated  string            `json:"last_updated"`
+}
+
+func (o *Object) UnmarshalJSON(data []byte) error {
+	type object Object
+	if err := json.Unmarshal(data, (*object)(o)); err != nil {
+		return err
+	}
+	o.Fields = o.CustomFields.reservationFields()
+	return nil
+}
+
+// Tag is a NetBox tag
+type Tag struct {
+	ID          int    `json:"id,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description,omitempty"`
+}
+
+// JournalEntry is a NetBox journal entry, a comment on an object
+type JournalEntry struct {
+	ID                 int    `json:"id,omitempty"`
+	AssignedObjectType string `json:"assigned_object_type"`
+	AssignedObjectID   int    `json:"assigned_object_id"`
+	// Kind is one of info, success, warning and danger
+	Kind     string `json:"kind"`
+	Comments string `json:"comments"`
+}
+
+// Rack is a NetBox rack
+type Rack struct {
+	ID   int    `json:"id"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+// Role is a NetBox device role
+type Role struct {
+	ID   int    `json:"id"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// Platform is a NetBox platform
+type Platform struct {
+	ID   int    `json:"id"`
+	URL  string `json:"url"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// CustomFields holds the custom field values of an object; values are nil
+// for custom fields which are not set
+type CustomFields map[string]interface{}
+
+// Lookup returns the value of a custom field, matching its name regardless of
+// case
+func (f CustomFields) Lookup(name string) (interface{}, bool) {
+	if value, ok := f[name]; ok {
+		return value, true
+	}
+	for key, value := range f {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// String returns the value of a custom field as a string, or "" if it's not
+// set; values of selection custom fields are returned as is
+func (f CustomFields) String(name string) string {
+	value, _ := f.Lookup(name)
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}:
+		if s, ok := v["value"].(string); ok {
+			return s
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+// Has reports whether the custom field is defined for the object
+func (f CustomFields) Has(name string) bool {
+	_, ok := f.Lookup(name)
+	return ok
+}
+
+// ReservationFields are the custom fields holding the reservation state of a
+// device or interface
+type ReservationFields struct {
+	State     string
+	SessionID string
+}
+
+// Reserved reports whether the object is reserved
+func (f ReservationFields) Reserved() bool {
+	return strings.EqualFold(f.State, "reserved")
+}
+
+// DeviceFields are the device custom fields the inventory is built from
+type DeviceFields struct {
+	ReservationFields
+	Model      string
+	Vendor     string
+	Image      string
+	Connection string
+	Credential string
+	HandleName string
+	Via        string
+}
+
+// InterfaceFields are the interface custom fields the inventory is built from
+type InterfaceFields struct {
+	ReservationFields
+	Pmd         string
+	Transceiver string
+}
+
+// reservationFields returns the reservation custom fields; custom fields are
+// matched regardless of case, as their names differ between labs
+func (f CustomFields) reservationFields() ReservationFields {
+	return ReservationFields{State: f.String("state"), SessionID: f.String("session_id")}
+}
+
+// deviceFields returns the device custom fields the inventory is built from
+func (f CustomFields) deviceFields() DeviceFields {
+	return DeviceFields{
+		ReservationFields: f.reservationFields(),
+		Model:             f.String("model"),
+		Vendor:            f.String("vendor"),
+		Image:             f.String("image"),
+		Connection:        f.String("connection"),
+		Credential:        f.String("credential"),
+		HandleName:        f.String("handle_name"),
+		Via:               f.String("via"),
+	}
+}
+
+// interfaceFields returns the interface custom fields the inventory is built
+// from
+func (f CustomFields) interfaceFields() InterfaceFields {
+	return InterfaceFields{
+		ReservationFields: f.reservationFields(),
+		Pmd:               f.String("pmd"),
+		Transceiver:       f.String("transceiver"),
+	}
+}
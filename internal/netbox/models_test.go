@@ -0,0 +1,152 @@
+package netbox
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestDeviceFields(t *testing.T) {
+	tests := []struct {
+		name         string
+		customFields string
+		want         DeviceFields
+		reserved     bool
+	}{
+		{name: "no custom fields", customFields: `{}`},
+		{name: "null custom fields", customFields: `null`},
+		{
+			name:         "unset custom fields",
+			customFields: `{"state": null, "session_id": null, "model": null, "vendor": null}`,
+		},
+		{
+			name:         "string custom fields",
+			customFields: `{"state": "reserved", "session_id": "s1", "model": "8201", "vendor": "Cisco", "image": "xr-7.9", "connection": "ssh", "credential": "admin", "handle_name": "dut", "via": "console1"}`,
+			want: DeviceFields{
+				ReservationFields: ReservationFields{State: "reserved", SessionID: "s1"},
+				Model:             "8201",
+				Vendor:            "Cisco",
+				Image:             "xr-7.9",
+				Connection:        "ssh",
+				Credential:        "admin",
+				HandleName:        "dut",
+				Via:               "console1",
+			},
+			reserved: true,
+		},
+		{
+			name:         "selection custom fields",
+			customFields: `{"state": {"value": "available", "label": "Available"}, "vendor": {"value": "arista", "label": "Arista"}}`,
+			want:         DeviceFields{ReservationFields: ReservationFields{State: "available"}, Vendor: "arista"},
+		},
+		{
+			name:         "mixed case custom field names",
+			customFields: `{"State": "Reserved", "Session_ID": "s1", "Vendor": "Juniper", "Handle_Name": "dut"}`,
+			want: DeviceFields{
+				ReservationFields: ReservationFields{State: "Reserved", SessionID: "s1"},
+				Vendor:            "Juniper",
+				HandleName:        "dut",
+			},
+			reserved: true,
+		},
+		{
+			name:         "non string custom fields",
+			customFields: `{"model": 8201, "image": true}`,
+			want:         DeviceFields{Model: "8201", Image: "true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := Device{}
+			data := `{"id": 1, "name": "dut1", "custom_fields": ` + tt.customFields + `}`
+			if err := json.Unmarshal([]byte(data), &device); err != nil {
+				t.Fatalf("failed to unmarshal device: %v", err)
+			}
+			if !reflect.DeepEqual(device.Fields, tt.want) {
+				t.Errorf("Fields = %+v, want %+v", device.Fields, tt.want)
+			}
+			if got := device.Fields.Reserved(); got != tt.reserved {
+				t.Errorf("Reserved() = %v, want %v", got, tt.reserved)
+			}
+		})
+	}
+}
+
+func TestInterfaceFields(t *testing.T) {
+	tests := []struct {
+		name         string
+		customFields string
+		want         InterfaceFields
+		reserved     bool
+	}{
+		{name: "no custom fields", customFields: `{}`},
+		{name: "null custom fields", customFields: `null`},
+		{name: "unset custom fields", customFields: `{"state": null, "pmd": null, "transceiver": null}`},
+		{
+			name:         "string custom fields",
+			customFields: `{"state": "reserved", "session_id": "s1", "pmd": "PMD_100GBASE_LR4", "transceiver": "QSFP28"}`,
+			want: InterfaceFields{
+				ReservationFields: ReservationFields{State: "reserved", SessionID: "s1"},
+				Pmd:               "PMD_100GBASE_LR4",
+				Transceiver:       "QSFP28",
+			},
+			reserved: true,
+		},
+		{
+			name:         "selection custom fields",
+			customFields: `{"state": {"value": "reserved", "label": "Reserved"}, "pmd": {"value": "PMD_10GBASE_SR", "label": "10GBASE-SR"}}`,
+			want:         InterfaceFields{ReservationFields: ReservationFields{State: "reserved"}, Pmd: "PMD_10GBASE_SR"},
+			reserved:     true,
+		},
+		{
+			name:         "mixed case custom field names",
+			customFields: `{"STATE": "available", "Session_Id": "", "PMD": "PMD_10GBASE_LR", "Transceiver": "SFP+"}`,
+			want: InterfaceFields{
+				ReservationFields: ReservationFields{State: "available"},
+				Pmd:               "PMD_10GBASE_LR",
+				Transceiver:       "SFP+",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iface := Interface{}
+			data := `{"id": 1, "name": "eth1", "custom_fields": ` + tt.customFields + `}`
+			if err := json.Unmarshal([]byte(data), &iface); err != nil {
+				t.Fatalf("failed to unmarshal interface: %v", err)
+			}
+			if !reflect.DeepEqual(iface.Fields, tt.want) {
+				t.Errorf("Fields = %+v, want %+v", iface.Fields, tt.want)
+			}
+			if got := iface.Fields.Reserved(); got != tt.reserved {
+				t.Errorf("Reserved() = %v, want %v", got, tt.reserved)
+			}
+		})
+	}
+}
+
+func TestCustomFieldsLookup(t *testing.T) {
+	fields := CustomFields{"state": "reserved", "State": "available", "Vendor": nil}
+	tests := []struct {
+		name  string
+		field string
+		want  interface{}
+		found bool
+	}{
+		{name: "exact name preferred", field: "State", want: "available", found: true},
+		{name: "exact lowercase name", field: "state", want: "reserved", found: true},
+		{name: "name in another case", field: "VENDOR", want: nil, found: true},
+		{name: "unknown name", field: "model", want: nil, found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := fields.Lookup(tt.field)
+			if got != tt.want || found != tt.found {
+				t.Errorf("Lookup(%q) = %v, %v, want %v, %v", tt.field, got, found, tt.want, tt.found)
+			}
+			if has := fields.Has(tt.field); has != tt.found {
+				t.Errorf("Has(%q) = %v, want %v", tt.field, has, tt.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"errors"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/service"
+	"net/http"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// CheckResponse tells whether a testbed can be reserved, along with the
+// candidate assignments found
+type CheckResponse struct {
+	// Satisfiable is set if the testbed fits the whole lab, ignoring current
+	// reservations
+	Satisfiable bool `json:"satisfiable"`
+	// Available is set if the testbed fits what is free right now
+	Available           bool               `json:"available"`
+	GlobalAssignment    *CandidateResponse `json:"global_assignment,omitempty"`
+	AvailableAssignment *CandidateResponse `json:"available_assignment,omitempty"`
+}
+
+// CandidateResponse maps testbed devices, ports and links onto the inventory
+type CandidateResponse struct {
+	Devices map[string]string `json:"devices"`
+	Ports   map[string]string `json:"ports"`
+	Links   []CandidateLink   `json:"links"`
+}
+
+// CandidateLink is an inventory link, with endpoints in "device:port" format
+type CandidateLink struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+func newCandidateResponse(candidate *controller.Candidate) *CandidateResponse {
+	if candidate == nil {
+		return nil
+	}
+	result := &CandidateResponse{Devices: candidate.Devices, Ports: candidate.Ports, Links: []CandidateLink{}}
+	for _, link := range candidate.Links {
+		result.Links = append(result.Links, CandidateLink{
+			Src: link.Src.Device + ":" + link.Src.Port,
+			Dst: link.Dst.Device + ":" + link.Dst.Port,
+		})
+	}
+	return result
+}
+
+// Path: /check
+// Method: POST
+func (ctrl *testbedController) Check(w http.ResponseWriter, r *http.Request) {
+	item, err := readTestbed(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+	result, err := ctrl.handler.Check(item, r)
+	if err != nil {
+		if errors.Is(err, controller.ErrInvalidTestbed) {
+			WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		} else {
+			WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		}
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) Check(rBody goopentestbed.Testbed, r *http.Request) (CheckResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "Check", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("Check failed")
+		return CheckResponse{}, err
+	}
+
+	feasibility, err := controller.Check(r.Context(), rBody, inventoryScope(r))
+	if err != nil {
+		log.Error().Err(err).Msg("Check failed")
+		return CheckResponse{}, err
+	}
+	return CheckResponse{
+		Satisfiable:         feasibility.Global != nil,
+		Available:           feasibility.Available != nil,
+		GlobalAssignment:    newCandidateResponse(feasibility.Global),
+		AvailableAssignment: newCandidateResponse(feasibility.Available),
+	}, nil
+}
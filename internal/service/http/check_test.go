@@ -0,0 +1,52 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInvalidTestbedStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{
+			name:    "duplicate device IDs",
+			request: `{"devices": [{"id": "dut1", "role": "DUT"}, {"id": "dut1", "role": "DUT"}]}`,
+		},
+		{
+			name:    "duplicate port IDs",
+			request: `{"devices": [{"id": "dut1", "role": "DUT", "ports": [{"id": "p1"}, {"id": "p1"}]}]}`,
+		},
+		{
+			name:    "state attribute",
+			request: `{"devices": [{"id": "dut1", "role": "DUT", "attributes": [{"key": "State", "value": "available"}]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := &testbedController{handler: &testbedHandler{}}
+			endpoints := []struct {
+				path  string
+				serve http.HandlerFunc
+			}{
+				{path: "/check", serve: ctrl.Check},
+				{path: "/reserve", serve: ctrl.Reserve},
+			}
+			for _, endpoint := range endpoints {
+				r := httptest.NewRequest(http.MethodPost, endpoint.path, strings.NewReader(tt.request))
+				w := httptest.NewRecorder()
+				endpoint.serve(w, r)
+
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("%s status = %d, want %d: %s", endpoint.path, w.Code, http.StatusBadRequest, w.Body.String())
+				}
+				if !strings.Contains(w.Body.String(), "invalid testbed") {
+					t.Errorf("%s body = %s, want the validation error", endpoint.path, w.Body.String())
+				}
+			}
+		})
+	}
+}
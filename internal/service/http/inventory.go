@@ -0,0 +1,150 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/service"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// responseFormat returns the format a response is asked for in, given by the
+// format query parameter or else the Accept header: json (default) or yaml
+func responseFormat(r *http.Request) (string, error) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	switch format {
+	case "":
+		if strings.Contains(r.Header.Get("Accept"), "yaml") {
+			return "yaml", nil
+		}
+		return "json", nil
+	case "json", "yaml":
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid format '%s', expected json or yaml", format)
+	}
+}
+
+// Path: /inventory?role=<role>&vendor=<vendor>&state=<state>&session=<id>&format=<json|yaml>
+// Method: GET
+func (ctrl *testbedController) Inventory(w http.ResponseWriter, r *http.Request) {
+	format, err := responseFormat(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+	query := r.URL.Query()
+	filter := controller.InventoryFilter{
+		Role:    query.Get("role"),
+		Vendor:  query.Get("vendor"),
+		State:   query.Get("state"),
+		Session: query.Get("session"),
+		Scope:   inventoryScope(r),
+	}
+	result, err := ctrl.handler.Inventory(filter, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if format == "yaml" {
+		_, err = WriteStructYAMLResponse(w, http.StatusOK, result)
+	} else {
+		_, err = WriteStructJSONResponse(w, http.StatusOK, result)
+	}
+	if err != nil {
+		log.Print(err.Error())
+	}
+}
+
+// Path: /inventory/diff?since=<time>
+// Method: GET
+func (ctrl *testbedController) InventoryDiff(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("since")
+	if value == "" {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", errors.New("since is required"))
+		return
+	}
+	since, err := parseTime("since", value)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+	result, err := ctrl.handler.InventoryDiff(since, r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+// Path: /inventory/lint?site=<slug>&location=<slug>&tenant=<slug>&rack=<name>&tag=<slug>
+// Method: GET
+func (ctrl *testbedController) InventoryLint(w http.ResponseWriter, r *http.Request) {
+	result, err := ctrl.handler.InventoryLint(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) Inventory(filter controller.InventoryFilter, r *http.Request) (controller.Inventory, error) {
+	defer profile.LogFuncDuration(time.Now(), "Inventory", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("Inventory failed")
+		return controller.Inventory{}, err
+	}
+
+	result, err := controller.ExportInventory(r.Context(), filter)
+	if err != nil {
+		log.Error().Err(err).Msg("Inventory failed")
+		return controller.Inventory{}, err
+	}
+	return result, nil
+}
+
+func (h *testbedHandler) InventoryDiff(since time.Time, r *http.Request) (controller.InventoryDiff, error) {
+	defer profile.LogFuncDuration(time.Now(), "InventoryDiff", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("Inventory diff failed")
+		return controller.InventoryDiff{}, err
+	}
+
+	result, err := controller.DiffInventory(r.Context(), since)
+	if err != nil {
+		log.Error().Err(err).Msg("Inventory diff failed")
+		return controller.InventoryDiff{}, err
+	}
+	return result, nil
+}
+
+func (h *testbedHandler) InventoryLint(r *http.Request) (controller.InventoryLint, error) {
+	defer profile.LogFuncDuration(time.Now(), "InventoryLint", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("Inventory lint failed")
+		return controller.InventoryLint{}, err
+	}
+
+	result, err := controller.LintInventory(r.Context(), inventoryScope(r))
+	if err != nil {
+		log.Error().Err(err).Msg("Inventory lint failed")
+		return controller.InventoryLint{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// JobResponse describes an asynchronous reservation job
+type JobResponse struct {
+	JobId         string     `json:"job_id"`
+	Status        string     `json:"status"`
+	Stages        []JobStage `json:"stages"`
+	QueuePosition int        `json:"queue_position,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+	SessionId     string     `json:"session_id,omitempty"`
+	Testbed       string     `json:"testbed,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// JobStage is a progress point reached by a job
+type JobStage struct {
+	Stage string    `json:"stage"`
+	At    time.Time `json:"at"`
+}
+
+func newJobResponse(job controller.Job) JobResponse {
+	result := JobResponse{
+		JobId:         job.ID,
+		Status:        string(job.Status),
+		Stages:        []JobStage{},
+		QueuePosition: job.QueuePosition,
+		CreatedAt:     job.CreatedAt,
+		FinishedAt:    job.FinishedAt,
+		SessionId:     job.SessionID,
+		Testbed:       job.Testbed,
+		Error:         job.Error,
+	}
+	for _, stage := range job.Stages {
+		result.Stages = append(result.Stages, JobStage{Stage: string(stage.Stage), At: stage.At})
+	}
+	return result
+}
+
+// asyncReserve tells whether the reservation is to be made in the background
+// e.g. /reserve?async=true
+func asyncReserve(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("async")
+	if value == "" {
+		return false, nil
+	}
+	async, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid async value '%s': %v", value, err)
+	}
+	return async, nil
+}
+
+// Path: /jobs/{id}
+// Method: GET
+func (ctrl *testbedController) GetJob(w http.ResponseWriter, r *http.Request) {
+	result, err := ctrl.handler.GetJob(mux.Vars(r)["id"], r)
+	ctrl.responseJob(w, result, err)
+}
+
+// Path: /jobs/{id}
+// Method: DELETE
+func (ctrl *testbedController) CancelJob(w http.ResponseWriter, r *http.Request) {
+	result, err := ctrl.handler.CancelJob(mux.Vars(r)["id"], r)
+	ctrl.responseJob(w, result, err)
+}
+
+func (ctrl *testbedController) responseJob(w http.ResponseWriter, result JobResponse, err error) {
+	if err != nil {
+		if errors.Is(err, controller.ErrJobNotFound) {
+			WriteErrorResponse(w, http.StatusNotFound, "validation", err)
+			return
+		}
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) ReserveAsync(rBody goopentestbed.Testbed, opts controller.ReserveOptions, r *http.Request) (JobResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "ReserveAsync", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("Reserve failed")
+		return JobResponse{}, err
+	}
+
+	job, err := controller.StartReserveJob(rBody, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Reserve failed")
+		return JobResponse{}, err
+	}
+	return newJobResponse(job), nil
+}
+
+func (h *testbedHandler) GetJob(id string, r *http.Request) (JobResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "GetJob", "", "http")
+
+	job, err := controller.GetJob(id)
+	if err != nil {
+		log.Error().Err(err).Str("JobID", id).Msg("GetJob failed")
+		return JobResponse{}, err
+	}
+	return newJobResponse(job), nil
+}
+
+func (h *testbedHandler) CancelJob(id string, r *http.Request) (JobResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "CancelJob", "", "http")
+
+	job, err := controller.CancelJob(id)
+	if err != nil {
+		log.Error().Err(err).Str("JobID", id).Msg("CancelJob failed")
+		return JobResponse{}, err
+	}
+	return newJobResponse(job), nil
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/service"
+	"keysight/laas/controller/internal/store"
+	"net/http"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// RenewResponse is returned upon successful lease renewal
+type RenewResponse struct {
+	SessionId string    `json:"session_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func parseLease(value string) (time.Duration, error) {
+	lease, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lease duration '%s': %v", value, err)
+	}
+	if lease <= 0 {
+		return 0, fmt.Errorf("lease duration must be positive, got '%s'", value)
+	}
+	return lease, nil
+}
+
+// Path: /renew?lease=<duration>
+// Method: POST
+func (ctrl *testbedController) Renew(w http.ResponseWriter, r *http.Request) {
+	var item goopentestbed.Session
+	if r.Body != nil {
+		body, readError := io.ReadAll(r.Body)
+		if body != nil {
+			item = goopentestbed.NewSession()
+			err := item.Unmarshal().FromJson(string(body))
+			if err != nil {
+				WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+				return
+			}
+		} else {
+			WriteErrorResponse(w, http.StatusBadRequest, "validation", readError)
+			return
+		}
+	} else {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", errors.New("request does not have a body"))
+		return
+	}
+	lease, err := parseLease(r.URL.Query().Get("lease"))
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+
+	result, err := ctrl.handler.Renew(item, lease, r)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			WriteErrorResponse(w, http.StatusNotFound, "validation", err)
+			return
+		}
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) Renew(rBody goopentestbed.Session, lease time.Duration, r *http.Request) (RenewResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "Renew", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("Renew failed")
+		return RenewResponse{}, err
+	}
+
+	session, err := controller.Renew(rBody.Id(), lease)
+	if err != nil {
+		log.Error().Err(err).Msg("Renew failed")
+		return RenewResponse{}, err
+	}
+	return RenewResponse{SessionId: session.ID, ExpiresAt: *session.ExpiresAt}, nil
+}
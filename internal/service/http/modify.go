@@ -0,0 +1,112 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/service"
+	"keysight/laas/controller/internal/store"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+func readTestbed(r *http.Request) (goopentestbed.Testbed, error) {
+	if r.Body == nil {
+		return nil, errors.New("request does not have a body")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	item := goopentestbed.NewTestbed()
+	if err := item.Unmarshal().FromJson(string(body)); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Path: /sessions/{id}/add
+// Method: POST
+func (ctrl *testbedController) ModifySession(w http.ResponseWriter, r *http.Request) {
+	item, err := readTestbed(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+	result, err := ctrl.handler.ModifySession(mux.Vars(r)["id"], item, r)
+	ctrl.responseSession(w, result, err)
+}
+
+// Path: /sessions/{id}/release
+// Method: POST
+func (ctrl *testbedController) ReleasePartial(w http.ResponseWriter, r *http.Request) {
+	item, err := readTestbed(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+	result, err := ctrl.handler.ReleasePartial(mux.Vars(r)["id"], item, r)
+	ctrl.responseSession(w, result, err)
+}
+
+func (ctrl *testbedController) responseSession(w http.ResponseWriter, result SessionResponse, err error) {
+	if err != nil {
+		var reserveErr *controller.ReserveError
+		if errors.Is(err, store.ErrNotFound) {
+			WriteErrorResponse(w, http.StatusNotFound, "validation", err)
+		} else if errors.Is(err, controller.ErrInvalidTestbed) {
+			WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		} else if errors.As(err, &reserveErr) {
+			rsp := reserveErrorResponse(reserveErr)
+			if _, err := WriteJSONResponse(w, int(rsp.Code()), rsp.Marshal()); err != nil {
+				log.Print(err.Error())
+			}
+		} else {
+			WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		}
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) ModifySession(id string, rBody goopentestbed.Testbed, r *http.Request) (SessionResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "ModifySession", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("ModifySession failed")
+		return SessionResponse{}, err
+	}
+
+	session, err := controller.ModifySession(r.Context(), id, rBody)
+	if err != nil {
+		log.Error().Err(err).Str("UserID", id).Msg("ModifySession failed")
+		return SessionResponse{}, err
+	}
+	return newSessionResponse(session)
+}
+
+func (h *testbedHandler) ReleasePartial(id string, rBody goopentestbed.Testbed, r *http.Request) (SessionResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "ReleasePartial", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("ReleasePartial failed")
+		return SessionResponse{}, err
+	}
+
+	session, err := controller.ReleasePartial(id, rBody)
+	if err != nil {
+		log.Error().Err(err).Str("UserID", id).Msg("ReleasePartial failed")
+		return SessionResponse{}, err
+	}
+	return newSessionResponse(session)
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"fmt"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"net/http"
+	"time"
+)
+
+// QueueEntryResponse describes a reservation waiting for resources
+type QueueEntryResponse struct {
+	QueueId    string    `json:"queue_id"`
+	Position   int       `json:"position"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Deadline   time.Time `json:"deadline"`
+}
+
+func parseWait(value string) (time.Duration, error) {
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wait duration '%s': %v", value, err)
+	}
+	if wait <= 0 {
+		return 0, fmt.Errorf("wait duration must be positive, got '%s'", value)
+	}
+	return wait, nil
+}
+
+// Path: /queue
+// Method: GET
+func (ctrl *testbedController) Queue(w http.ResponseWriter, r *http.Request) {
+	result, err := ctrl.handler.Queue(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) Queue(r *http.Request) ([]QueueEntryResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "Queue", "", "http")
+
+	result := []QueueEntryResponse{}
+	for _, entry := range controller.Queue() {
+		result = append(result, QueueEntryResponse{
+			QueueId:    entry.ID,
+			Position:   entry.Position,
+			EnqueuedAt: entry.EnqueuedAt,
+			Deadline:   entry.Deadline,
+		})
+	}
+	return result, nil
+}
@@ -2,12 +2,18 @@ package http
 
 import (
 	// "encoding/json"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"io"
+	"keysight/laas/controller/config"
 	"keysight/laas/controller/internal/controller"
+	inven "keysight/laas/controller/internal/inventory/netbox"
 	"keysight/laas/controller/internal/profile"
 	"keysight/laas/controller/internal/service"
+	"keysight/laas/controller/internal/store"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/open-traffic-generator/opentestbed/goopentestbed"
@@ -22,12 +28,42 @@ type TestbedController interface {
 	Routes() []Route
 	Reserve(http.ResponseWriter, *http.Request)
 	Release(http.ResponseWriter, *http.Request)
+	Renew(http.ResponseWriter, *http.Request)
+	Sessions(http.ResponseWriter, *http.Request)
+	GetSession(http.ResponseWriter, *http.Request)
+	GetJob(http.ResponseWriter, *http.Request)
+	Queue(http.ResponseWriter, *http.Request)
+	Calendar(http.ResponseWriter, *http.Request)
+	CancelJob(http.ResponseWriter, *http.Request)
+	ModifySession(http.ResponseWriter, *http.Request)
+	ReleasePartial(http.ResponseWriter, *http.Request)
+	Check(http.ResponseWriter, *http.Request)
+	NetboxWebhook(http.ResponseWriter, *http.Request)
+	Inventory(http.ResponseWriter, *http.Request)
+	InventoryDiff(http.ResponseWriter, *http.Request)
+	InventoryLint(http.ResponseWriter, *http.Request)
 }
 
 type TestbedHandler interface {
 	GetController() TestbedController
-	Reserve(rBody goopentestbed.Testbed, r *http.Request) (goopentestbed.ReserveResponse, error)
+	Reserve(rBody goopentestbed.Testbed, opts controller.ReserveOptions, r *http.Request) (goopentestbed.ReserveResponse, error)
+	ReserveAsync(rBody goopentestbed.Testbed, opts controller.ReserveOptions, r *http.Request) (JobResponse, error)
 	Release(rBody goopentestbed.Session, r *http.Request) (goopentestbed.ReleaseResponse, error)
+	Renew(rBody goopentestbed.Session, lease time.Duration, r *http.Request) (RenewResponse, error)
+	Sessions(r *http.Request) ([]SessionResponse, error)
+	GetSession(id string, r *http.Request) (SessionResponse, error)
+	GetJob(id string, r *http.Request) (JobResponse, error)
+	Queue(r *http.Request) ([]QueueEntryResponse, error)
+	Schedule(rBody goopentestbed.Testbed, start time.Time, end time.Time, opts controller.ReserveOptions, r *http.Request) (SessionResponse, error)
+	Calendar(from time.Time, to time.Time, device string, r *http.Request) (CalendarResponse, error)
+	CancelJob(id string, r *http.Request) (JobResponse, error)
+	ModifySession(id string, rBody goopentestbed.Testbed, r *http.Request) (SessionResponse, error)
+	ReleasePartial(id string, rBody goopentestbed.Testbed, r *http.Request) (SessionResponse, error)
+	Check(rBody goopentestbed.Testbed, r *http.Request) (CheckResponse, error)
+	NetboxWebhook(payload []byte, r *http.Request) error
+	Inventory(filter controller.InventoryFilter, r *http.Request) (controller.Inventory, error)
+	InventoryDiff(since time.Time, r *http.Request) (controller.InventoryDiff, error)
+	InventoryLint(r *http.Request) (controller.InventoryLint, error)
 }
 
 type testbedController struct {
@@ -58,6 +94,20 @@ func (ctrl *testbedController) Routes() []Route {
 	return []Route{
 		{Path: "/reserve", Method: "POST", Name: "Reserve", Handler: ctrl.Reserve},
 		{Path: "/release", Method: "POST", Name: "Release", Handler: ctrl.Release},
+		{Path: "/renew", Method: "POST", Name: "Renew", Handler: ctrl.Renew},
+		{Path: "/sessions", Method: "GET", Name: "Sessions", Handler: ctrl.Sessions},
+		{Path: "/sessions/{id}", Method: "GET", Name: "GetSession", Handler: ctrl.GetSession},
+		{Path: "/jobs/{id}", Method: "GET", Name: "GetJob", Handler: ctrl.GetJob},
+		{Path: "/queue", Method: "GET", Name: "Queue", Handler: ctrl.Queue},
+		{Path: "/calendar", Method: "GET", Name: "Calendar", Handler: ctrl.Calendar},
+		{Path: "/jobs/{id}", Method: "DELETE", Name: "CancelJob", Handler: ctrl.CancelJob},
+		{Path: "/sessions/{id}/add", Method: "POST", Name: "ModifySession", Handler: ctrl.ModifySession},
+		{Path: "/sessions/{id}/release", Method: "POST", Name: "ReleasePartial", Handler: ctrl.ReleasePartial},
+		{Path: "/check", Method: "POST", Name: "Check", Handler: ctrl.Check},
+		{Path: "/netbox/webhook", Method: "POST", Name: "NetboxWebhook", Handler: ctrl.NetboxWebhook},
+		{Path: "/inventory", Method: "GET", Name: "Inventory", Handler: ctrl.Inventory},
+		{Path: "/inventory/diff", Method: "GET", Name: "InventoryDiff", Handler: ctrl.InventoryDiff},
+		{Path: "/inventory/lint", Method: "GET", Name: "InventoryLint", Handler: ctrl.InventoryLint},
 	}
 }
 
@@ -81,7 +131,44 @@ func (ctrl *testbedController) Reserve(w http.ResponseWriter, r *http.Request) {
 		ctrl.responseReserveError(w, "validation", bodyError)
 		return
 	}
-	result, err := ctrl.handler.Reserve(item, r)
+	opts, err := reserveOptions(r)
+	if err != nil {
+		ctrl.responseReserveError(w, "validation", err)
+		return
+	}
+	start, end, err := scheduleWindow(r)
+	if err != nil {
+		ctrl.responseReserveError(w, "validation", err)
+		return
+	}
+	if start != nil {
+		session, err := ctrl.handler.Schedule(item, *start, *end, opts, r)
+		if err != nil {
+			ctrl.responseReserveError(w, "internal", err)
+			return
+		}
+		if _, err := WriteStructJSONResponse(w, http.StatusCreated, session); err != nil {
+			log.Print(err.Error())
+		}
+		return
+	}
+	async, err := asyncReserve(r)
+	if err != nil {
+		ctrl.responseReserveError(w, "validation", err)
+		return
+	}
+	if async {
+		job, err := ctrl.handler.ReserveAsync(item, opts, r)
+		if err != nil {
+			ctrl.responseReserveError(w, "internal", err)
+			return
+		}
+		if _, err := WriteStructJSONResponse(w, http.StatusAccepted, job); err != nil {
+			log.Print(err.Error())
+		}
+		return
+	}
+	result, err := ctrl.handler.Reserve(item, opts, r)
 	if err != nil {
 		ctrl.responseReserveError(w, "internal", err)
 		return
@@ -136,7 +223,88 @@ func (ctrl *testbedController) responseReserveError(w http.ResponseWriter, error
 	}
 }
 
-func (h *testbedHandler) Reserve(rBody goopentestbed.Testbed, r *http.Request) (goopentestbed.ReserveResponse, error) {
+// reserveOptions parses optional reserve parameters from the query string
+// e.g. /reserve?lease=2h&wait=10m&priority=10&preempt=true&owner=jdoe&team=qa
+func reserveOptions(r *http.Request) (controller.ReserveOptions, error) {
+	opts := controller.ReserveOptions{IdempotencyKey: r.Header.Get("Idempotency-Key")}
+	query := r.URL.Query()
+	opts.Metadata = store.Metadata{
+		Owner:    query.Get("owner"),
+		Team:     query.Get("team"),
+		Purpose:  query.Get("purpose"),
+		CIJobURL: query.Get("ci_job_url"),
+	}
+	opts.Scope = inventoryScope(r)
+	if lease := query.Get("lease"); lease != "" {
+		duration, err := parseLease(lease)
+		if err != nil {
+			return opts, err
+		}
+		opts.Lease = duration
+	}
+	if wait := query.Get("wait"); wait != "" {
+		duration, err := parseWait(wait)
+		if err != nil {
+			return opts, err
+		}
+		opts.Wait = duration
+	}
+	if priority := query.Get("priority"); priority != "" {
+		value, err := strconv.Atoi(priority)
+		if err != nil {
+			return opts, fmt.Errorf("invalid priority '%s': %v", priority, err)
+		}
+		// a higher priority lets a session outrank and be preempted later
+		// than others, so only admins may raise it above the default of 0
+		if value > 0 && !isAdmin(r) {
+			return opts, errors.New("priority above 0 requires a valid admin token")
+		}
+		opts.Priority = value
+	}
+	if preempt := query.Get("preempt"); preempt != "" {
+		value, err := strconv.ParseBool(preempt)
+		if err != nil {
+			return opts, fmt.Errorf("invalid preempt value '%s': %v", preempt, err)
+		}
+		if value && !isAdmin(r) {
+			return opts, errors.New("preemptive reserve requires a valid admin token")
+		}
+		opts.Preempt = value
+	}
+	return opts, nil
+}
+
+// inventoryScope returns the scope of the inventory given by the site,
+// location, tenant, rack and tag query parameters; each may be repeated or
+// hold a comma-separated list
+func inventoryScope(r *http.Request) inven.Scope {
+	query := r.URL.Query()
+	values := func(key string) []string {
+		items := []string{}
+		for _, value := range query[key] {
+			items = append(items, controller.SplitList(value)...)
+		}
+		return items
+	}
+	return inven.Scope{
+		Sites:     values("site"),
+		Locations: values("location"),
+		Tenants:   values("tenant"),
+		Racks:     values("rack"),
+		Tags:      values("tag"),
+	}
+}
+
+// isAdmin reports whether the request carries the admin token
+func isAdmin(r *http.Request) bool {
+	token := *config.Config.AdminToken
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) == 1
+}
+
+func (h *testbedHandler) Reserve(rBody goopentestbed.Testbed, opts controller.ReserveOptions, r *http.Request) (goopentestbed.ReserveResponse, error) {
 	defer profile.LogFuncDuration(time.Now(), "Reserve", "", "http")
 
 	// validate expiry of time-limited binary
@@ -147,9 +315,19 @@ func (h *testbedHandler) Reserve(rBody goopentestbed.Testbed, r *http.Request) (
 	}
 
 	// Call the Reserve function from the controller
-	reservedResult, err := controller.Reserve(rBody)
+	reservedResult, err := controller.Reserve(r.Context(), rBody, opts)
 	if err != nil {
 		log.Error().Err(err).Msg("Reserve failed")
+		var reserveErr *controller.ReserveError
+		if errors.As(err, &reserveErr) {
+			return nil, reserveErrorResponse(reserveErr)
+		}
+		if errors.Is(err, controller.ErrIdempotencyKeyReused) {
+			return nil, conflictErrorResponse(err)
+		}
+		if errors.Is(err, controller.ErrInvalidTestbed) {
+			return nil, validationErrorResponse(err)
+		}
 		return nil, err
 	}
 	result := goopentestbed.NewReserveResponse()
@@ -158,6 +336,44 @@ func (h *testbedHandler) Reserve(rBody goopentestbed.Testbed, r *http.Request) (
 	return result, nil
 }
 
+// reserveErrorResponse reports the failure of a reservation along with the
+// outcome of rolling it back
+func reserveErrorResponse(reserveErr *controller.ReserveError) goopentestbed.Error {
+	result := goopentestbed.NewError()
+	_ = result.SetCode(500)
+	if err := result.SetKind("internal"); err != nil {
+		log.Print(err.Error())
+	}
+	rollback := "rollback succeeded"
+	if !reserveErr.RolledBack() {
+		rollback = "rollback failed: " + reserveErr.RollbackErr.Error()
+	}
+	_ = result.SetErrors([]string{reserveErr.Err.Error(), rollback})
+	return result
+}
+
+// validationErrorResponse reports a request which is invalid as given
+func validationErrorResponse(err error) goopentestbed.Error {
+	result := goopentestbed.NewError()
+	_ = result.SetCode(http.StatusBadRequest)
+	if err := result.SetKind("validation"); err != nil {
+		log.Print(err.Error())
+	}
+	_ = result.SetErrors([]string{err.Error()})
+	return result
+}
+
+// conflictErrorResponse reports a request conflicting with an earlier one
+func conflictErrorResponse(err error) goopentestbed.Error {
+	result := goopentestbed.NewError()
+	_ = result.SetCode(http.StatusConflict)
+	if err := result.SetKind("validation"); err != nil {
+		log.Print(err.Error())
+	}
+	_ = result.SetErrors([]string{err.Error()})
+	return result
+}
+
 var controlMrlOpts = protojson.MarshalOptions{
 	UseProtoNames:   true,
 	AllowPartial:    true,
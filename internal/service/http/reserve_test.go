@@ -0,0 +1,51 @@
+package http
+
+import (
+	"keysight/laas/controller/config"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReserveOptionsPriority(t *testing.T) {
+	const adminToken = "admin-token"
+	tests := []struct {
+		name     string
+		query    string
+		token    string
+		priority int
+		preempt  bool
+		wantErr  bool
+	}{
+		{name: "default priority", query: ""},
+		{name: "priority 0 without the admin token", query: "?priority=0"},
+		{name: "lower priority without the admin token", query: "?priority=-5", priority: -5},
+		{name: "higher priority without the admin token", query: "?priority=10", wantErr: true},
+		{name: "higher priority with a wrong admin token", query: "?priority=10", token: "wrong", wantErr: true},
+		{name: "higher priority with the admin token", query: "?priority=10", token: adminToken, priority: 10},
+		{name: "invalid priority", query: "?priority=high", token: adminToken, wantErr: true},
+		{name: "preempt without the admin token", query: "?preempt=true", wantErr: true},
+		{name: "preempt with the admin token", query: "?priority=10&preempt=true", token: adminToken, priority: 10, preempt: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := *config.Config.AdminToken
+			*config.Config.AdminToken = adminToken
+			defer func() { *config.Config.AdminToken = previous }()
+
+			r := httptest.NewRequest("POST", "/reserve"+tt.query, nil)
+			if tt.token != "" {
+				r.Header.Set("X-Admin-Token", tt.token)
+			}
+			opts, err := reserveOptions(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reserveOptions() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if opts.Priority != tt.priority || opts.Preempt != tt.preempt {
+				t.Errorf("reserveOptions() priority = %d, preempt = %v, want %d, %v", opts.Priority, opts.Preempt, tt.priority, tt.preempt)
+			}
+		})
+	}
+}
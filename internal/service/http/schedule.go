@@ -0,0 +1,151 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/service"
+	"net/http"
+	"time"
+
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
+)
+
+// defaultCalendarRange is the time range covered by the calendar when the
+// end of the range is not given
+const defaultCalendarRange = 7 * 24 * time.Hour
+
+// CalendarResponse lists the bookings of each device within a time range
+type CalendarResponse struct {
+	From    time.Time                    `json:"from"`
+	To      time.Time                    `json:"to"`
+	Devices map[string][]BookingResponse `json:"devices"`
+}
+
+// BookingResponse is a time range over which a session holds a device
+type BookingResponse struct {
+	SessionId string     `json:"session_id"`
+	Status    string     `json:"status"`
+	Start     time.Time  `json:"start"`
+	End       *time.Time `json:"end,omitempty"`
+	Ports     []string   `json:"ports"`
+}
+
+func parseTime(name string, value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s time '%s', expected RFC3339 format: %v", name, value, err)
+	}
+	return t, nil
+}
+
+// scheduleWindow parses the time range of a scheduled reservation from the
+// query string e.g. /reserve?start=2024-06-01T01:00:00Z&end=2024-06-01T05:00:00Z;
+// nil times are returned for reservations to be made right away
+func scheduleWindow(r *http.Request) (*time.Time, *time.Time, error) {
+	query := r.URL.Query()
+	startValue, endValue := query.Get("start"), query.Get("end")
+	if startValue == "" && endValue == "" {
+		return nil, nil, nil
+	}
+	if startValue == "" || endValue == "" {
+		return nil, nil, errors.New("both start and end are required for a scheduled reservation")
+	}
+	for _, param := range []string{"lease", "wait", "async", "preempt"} {
+		if query.Get(param) != "" {
+			return nil, nil, fmt.Errorf("%s is not supported for a scheduled reservation", param)
+		}
+	}
+	start, err := parseTime("start", startValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	end, err := parseTime("end", endValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &start, &end, nil
+}
+
+// Path: /calendar?from=<time>&to=<time>&device=<name>
+// Method: GET
+func (ctrl *testbedController) Calendar(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from := time.Now()
+	if value := query.Get("from"); value != "" {
+		t, err := parseTime("from", value)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+			return
+		}
+		from = t
+	}
+	to := from.Add(defaultCalendarRange)
+	if value := query.Get("to"); value != "" {
+		t, err := parseTime("to", value)
+		if err != nil {
+			WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+			return
+		}
+		to = t
+	}
+	if !to.After(from) {
+		err := fmt.Errorf("to time %s is not after from time %s", to.Format(time.RFC3339), from.Format(time.RFC3339))
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+
+	result, err := ctrl.handler.Calendar(from, to, query.Get("device"), r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) Schedule(rBody goopentestbed.Testbed, start time.Time, end time.Time, opts controller.ReserveOptions, r *http.Request) (SessionResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "Schedule", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("Schedule failed")
+		return SessionResponse{}, err
+	}
+
+	session, err := controller.Schedule(r.Context(), rBody, start, end, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Schedule failed")
+		if errors.Is(err, controller.ErrInvalidTestbed) {
+			return SessionResponse{}, validationErrorResponse(err)
+		}
+		return SessionResponse{}, err
+	}
+	return newSessionResponse(session)
+}
+
+func (h *testbedHandler) Calendar(from time.Time, to time.Time, device string, r *http.Request) (CalendarResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "Calendar", "", "http")
+
+	calendar, err := controller.Calendar(from, to, device)
+	if err != nil {
+		log.Error().Err(err).Msg("Calendar failed")
+		return CalendarResponse{}, err
+	}
+	result := CalendarResponse{From: from, To: to, Devices: map[string][]BookingResponse{}}
+	for device, bookings := range calendar {
+		for _, booking := range bookings {
+			result.Devices[device] = append(result.Devices[device], BookingResponse{
+				SessionId: booking.SessionID,
+				Status:    string(booking.Status),
+				Start:     booking.Start,
+				End:       booking.End,
+				Ports:     booking.Ports,
+			})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"errors"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/store"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SessionResponse describes a reserved session
+type SessionResponse struct {
+	SessionId string          `json:"session_id"`
+	Status    string          `json:"status"`
+	CreatedAt time.Time       `json:"created_at"`
+	StartsAt  *time.Time      `json:"starts_at,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+	Devices   []SessionDevice `json:"devices"`
+	L1sLinks  []SessionL1Link `json:"l1s_links"`
+	Testbed   string          `json:"testbed"`
+	Error     string          `json:"error,omitempty"`
+	Priority  int             `json:"priority"`
+	Reason    string          `json:"reason,omitempty"`
+	Owner     string          `json:"owner,omitempty"`
+	Team      string          `json:"team,omitempty"`
+	Purpose   string          `json:"purpose,omitempty"`
+	CIJobURL  string          `json:"ci_job_url,omitempty"`
+}
+
+// SessionDevice is a device reserved by a session along with its reserved ports
+type SessionDevice struct {
+	Name  string   `json:"name"`
+	Role  string   `json:"role"`
+	Ports []string `json:"ports"`
+}
+
+// SessionL1Link is a pair of L1 switch ports cross-connected for a session
+type SessionL1Link struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+func newSessionResponse(session *store.Session) (SessionResponse, error) {
+	status := session.Status
+	if status == "" {
+		status = store.StatusActive
+	}
+	result := SessionResponse{
+		SessionId: session.ID,
+		Status:    string(status),
+		CreatedAt: session.CreatedAt,
+		StartsAt:  session.StartsAt,
+		ExpiresAt: session.ExpiresAt,
+		Devices:   []SessionDevice{},
+		L1sLinks:  []SessionL1Link{},
+		Testbed:   session.Testbed,
+		Error:     session.Error,
+		Priority:  session.Priority,
+		Reason:    session.Reason,
+		Owner:     session.Metadata.Owner,
+		Team:      session.Metadata.Team,
+		Purpose:   session.Metadata.Purpose,
+		CIJobURL:  session.Metadata.CIJobURL,
+	}
+	for _, device := range session.Devices {
+		ports := device.Ports
+		if ports == nil {
+			ports = []string{}
+		}
+		result.Devices = append(result.Devices, SessionDevice{Name: device.Name, Role: device.Role, Ports: ports})
+	}
+	links, err := controller.SessionL1sLinks(session)
+	if err != nil {
+		return result, err
+	}
+	for _, link := range links {
+		result.L1sLinks = append(result.L1sLinks, SessionL1Link{Src: link.Src, Dst: link.Dst})
+	}
+	return result, nil
+}
+
+// Path: /sessions?device=<name>
+// Method: GET
+func (ctrl *testbedController) Sessions(w http.ResponseWriter, r *http.Request) {
+	result, err := ctrl.handler.Sessions(r)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+// Path: /sessions/{id}
+// Method: GET
+func (ctrl *testbedController) GetSession(w http.ResponseWriter, r *http.Request) {
+	result, err := ctrl.handler.GetSession(mux.Vars(r)["id"], r)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			WriteErrorResponse(w, http.StatusNotFound, "validation", err)
+			return
+		}
+		WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		return
+	}
+	if _, err := WriteStructJSONResponse(w, http.StatusOK, result); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+func (h *testbedHandler) Sessions(r *http.Request) ([]SessionResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "Sessions", "", "http")
+
+	sessions, err := controller.Sessions()
+	if err != nil {
+		log.Error().Err(err).Msg("Sessions failed")
+		return nil, err
+	}
+	// optionally narrow down to the sessions holding a given device
+	device := r.URL.Query().Get("device")
+	result := []SessionResponse{}
+	for _, session := range sessions {
+		if device != "" && !session.HasDevice(device) {
+			continue
+		}
+		item, err := newSessionResponse(session)
+		if err != nil {
+			log.Error().Err(err).Msg("Sessions failed")
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func (h *testbedHandler) GetSession(id string, r *http.Request) (SessionResponse, error) {
+	defer profile.LogFuncDuration(time.Now(), "GetSession", "", "http")
+
+	session, err := controller.GetSession(id)
+	if err != nil {
+		log.Error().Err(err).Str("UserID", id).Msg("GetSession failed")
+		return SessionResponse{}, err
+	}
+	return newSessionResponse(session)
+}
@@ -1,8 +1,12 @@
 package http
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/ghodss/yaml"
+	"github.com/open-traffic-generator/opentestbed/goopentestbed"
 )
 
 type JSONWriter interface {
@@ -39,3 +43,37 @@ func WriteCustomJSONResponse(w http.ResponseWriter, statuscode int, data []byte)
 	w.WriteHeader(statuscode)
 	return w.Write([]byte(data))
 }
+
+// WriteStructJSONResponse sets an HTTP response with the provided status-code and data marshalled as JSON.
+func WriteStructJSONResponse(w http.ResponseWriter, statuscode int, data interface{}) (int, error) {
+	dataBytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return WriteDefaultResponse(w, http.StatusInternalServerError)
+	}
+	return WriteCustomJSONResponse(w, statuscode, dataBytes)
+}
+
+// WriteStructYAMLResponse sets an HTTP response with the provided status-code and data marshalled as YAML.
+func WriteStructYAMLResponse(w http.ResponseWriter, statuscode int, data interface{}) (int, error) {
+	dataBytes, err := yaml.Marshal(data)
+	if err != nil {
+		return WriteDefaultResponse(w, http.StatusInternalServerError)
+	}
+	w.Header().Set("Content-Type", "application/yaml; charset=UTF-8")
+	w.WriteHeader(statuscode)
+	return w.Write(dataBytes)
+}
+
+// WriteErrorResponse sets an HTTP response with the provided status-code and error.
+func WriteErrorResponse(w http.ResponseWriter, statuscode int, errorKind goopentestbed.ErrorKindEnum, rspErr error) {
+	result := goopentestbed.NewError()
+	_ = result.SetCode(int32(statuscode))
+	if err := result.SetKind(errorKind); err != nil {
+		log.Print(err.Error())
+	}
+	_ = result.SetErrors([]string{rspErr.Error()})
+
+	if _, err := WriteJSONResponse(w, statuscode, result.Marshal()); err != nil {
+		log.Print(err.Error())
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io"
+	"keysight/laas/controller/config"
+	"keysight/laas/controller/internal/controller"
+	"keysight/laas/controller/internal/profile"
+	"keysight/laas/controller/internal/service"
+	"net/http"
+	"time"
+)
+
+// validSignature reports whether the X-Hook-Signature header holds the
+// HMAC-SHA512 of the body keyed with the webhook secret, as NetBox signs it
+func validSignature(r *http.Request, body []byte) bool {
+	signature, err := hex.DecodeString(r.Header.Get("X-Hook-Signature"))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+	mac := hmac.New(sha512.New, []byte(*config.Config.NetboxWebhookSecret))
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// Path: /netbox/webhook
+// Method: POST
+func (ctrl *testbedController) NetboxWebhook(w http.ResponseWriter, r *http.Request) {
+	if *config.Config.NetboxWebhookSecret == "" {
+		WriteErrorResponse(w, http.StatusNotFound, "validation", errors.New("NetBox webhook is disabled"))
+		return
+	}
+	if r.Body == nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", errors.New("request does not have a body"))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		return
+	}
+	if !validSignature(r, body) {
+		WriteErrorResponse(w, http.StatusUnauthorized, "validation", errors.New("invalid webhook signature"))
+		return
+	}
+
+	if err := ctrl.handler.NetboxWebhook(body, r); err != nil {
+		switch {
+		case errors.Is(err, controller.ErrInvalidInventoryEvent):
+			WriteErrorResponse(w, http.StatusBadRequest, "validation", err)
+		case errors.Is(err, controller.ErrInventoryEventsUnsupported):
+			WriteErrorResponse(w, http.StatusNotFound, "validation", err)
+		default:
+			WriteErrorResponse(w, http.StatusInternalServerError, "internal", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *testbedHandler) NetboxWebhook(payload []byte, r *http.Request) error {
+	defer profile.LogFuncDuration(time.Now(), "NetboxWebhook", "", "http")
+
+	// validate expiry of time-limited binary
+	err := service.GetTimeExpiryStatus()
+	if err != nil {
+		log.Error().Err(err).Msg("NetBox webhook failed")
+		return err
+	}
+
+	if err := controller.ApplyInventoryEvent(payload); err != nil {
+		log.Error().Err(err).Msg("NetBox webhook failed")
+		return err
+	}
+	return nil
+}
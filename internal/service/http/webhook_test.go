@@ -0,0 +1,83 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"keysight/laas/controller/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// webhookHandler records the NetBox webhook payloads passed on to it
+type webhookHandler struct {
+	TestbedHandler
+	payloads []string
+}
+
+func (h *webhookHandler) NetboxWebhook(payload []byte, r *http.Request) error {
+	h.payloads = append(h.payloads, string(payload))
+	return nil
+}
+
+// sign returns the signature NetBox sends the body with
+func sign(secret string, body string) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestNetboxWebhookSignature(t *testing.T) {
+	const (
+		secret = "webhook-secret"
+		body   = `{"event": "updated", "model": "device", "data": {"id": 1}}`
+	)
+	tests := []struct {
+		name      string
+		secret    string
+		signature *string
+		want      int
+	}{
+		{name: "valid signature", secret: secret, signature: strPtr(sign(secret, body)), want: http.StatusNoContent},
+		{name: "uppercase hex signature", secret: secret, signature: strPtr(strings.ToUpper(sign(secret, body))), want: http.StatusNoContent},
+		{name: "signature with another secret", secret: secret, signature: strPtr(sign("other-secret", body)), want: http.StatusUnauthorized},
+		{name: "signature of another body", secret: secret, signature: strPtr(sign(secret, body+" ")), want: http.StatusUnauthorized},
+		{name: "missing header", secret: secret, want: http.StatusUnauthorized},
+		{name: "empty header", secret: secret, signature: strPtr(""), want: http.StatusUnauthorized},
+		{name: "signature not in hex", secret: secret, signature: strPtr("not-hex-" + sign(secret, body)[8:]), want: http.StatusUnauthorized},
+		{name: "webhook disabled", secret: "", signature: strPtr(sign("", body)), want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := *config.Config.NetboxWebhookSecret
+			*config.Config.NetboxWebhookSecret = tt.secret
+			defer func() { *config.Config.NetboxWebhookSecret = previous }()
+
+			handler := &webhookHandler{}
+			ctrl := &testbedController{handler: handler}
+			r := httptest.NewRequest(http.MethodPost, "/netbox/webhook", strings.NewReader(body))
+			if tt.signature != nil {
+				r.Header.Set("X-Hook-Signature", *tt.signature)
+			}
+			w := httptest.NewRecorder()
+			ctrl.NetboxWebhook(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("NetboxWebhook() status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			applied := tt.want == http.StatusNoContent
+			if applied != (len(handler.payloads) == 1) {
+				t.Errorf("NetboxWebhook() passed on %d payloads, want applied = %v", len(handler.payloads), applied)
+			}
+			if applied && handler.payloads[0] != body {
+				t.Errorf("NetboxWebhook() passed on %q, want %q", handler.payloads[0], body)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+const sessionFileExt = ".json"
+
+// FileStore keeps one JSON file per session inside a directory
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore returns a FileStore rooted at dir, creating dir if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("session store directory can not be empty")
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create session store directory %s: %w", dir, err)
+	}
+	log.Info().Str("dir", dir).Msg("Initialized file session store")
+	return &FileStore{dir: dir}, nil
+}
+
+// session IDs are not guaranteed to be valid file names
+func (s *FileStore) filePath(id string) string {
+	return path.Join(s.dir, base64.RawURLEncoding.EncodeToString([]byte(id))+sessionFileExt)
+}
+
+func (s *FileStore) Save(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session %s: %w", session.ID, err)
+	}
+	// write to a temporary file first so that a crash never leaves a
+	// partially written record behind
+	filePath := s.filePath(session.ID)
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("failed to write session %s: %w", session.ID, err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("failed to commit session %s: %w", session.ID, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Get(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read(s.filePath(id))
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.filePath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete session %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *FileStore) List() ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session store directory %s: %w", s.dir, err)
+	}
+	sessions := []*Session{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), sessionFileExt) {
+			continue
+		}
+		session, err := s.read(path.Join(s.dir, entry.Name()))
+		if err != nil {
+			log.Error().Err(err).Str("file", entry.Name()).Msg("Skipping unreadable session record")
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *FileStore) read(filePath string) (*Session, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read session record %s: %w", filePath, err)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session record %s: %w", filePath, err)
+	}
+	return &session, nil
+}
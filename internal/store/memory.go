@@ -0,0 +1,81 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// MemoryStore keeps sessions in process memory only; sessions do not
+// survive a controller restart. Sessions are copied in and out, so that
+// callers updating a session don't race with others reading it, as with the
+// FileStore
+type MemoryStore struct {
+	sessions map[string]*Session
+	mu       sync.Mutex
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]*Session{}}
+}
+
+// copySession returns a deep copy of the session, as it would be read back
+// from a FileStore
+func copySession(session *Session) (*Session, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal session %s: %w", session.ID, err)
+	}
+	var copied Session
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session %s: %w", session.ID, err)
+	}
+	return &copied, nil
+}
+
+func (s *MemoryStore) Save(session *Session) error {
+	copied, err := copySession(session)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = copied
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copySession(session)
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) List() ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		copied, err := copySession(session)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, copied)
+	}
+	return sessions, nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreCopiesSessions(t *testing.T) {
+	s := NewMemoryStore()
+	session := &Session{ID: "s1", CreatedAt: time.Now(), Devices: []Device{{Name: "dut1", Ports: []string{"eth1"}}}}
+	if err := s.Save(session); err != nil {
+		t.Fatal(err)
+	}
+
+	// updates are only seen once saved
+	session.Status = StatusPreempted
+	session.Devices[0].Ports[0] = "eth2"
+	got, err := s.Get("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "" || got.Devices[0].Ports[0] != "eth1" {
+		t.Errorf("Get() = %+v, saved session was changed in place", got)
+	}
+	got.Status = StatusFailed
+	listed, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Status != "" {
+		t.Errorf("List() = %+v, got session was changed in place", listed)
+	}
+}
+
+func TestMemoryStoreConcurrentUpdates(t *testing.T) {
+	s := NewMemoryStore()
+	if err := s.Save(&Session{ID: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			session, err := s.Get("s1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			expiresAt := time.Now()
+			session.ExpiresAt = &expiresAt
+			if err := s.Save(session); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			sessions, err := s.List()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, session := range sessions {
+				_ = session.Expired(time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"fmt"
+	"keysight/laas/controller/config"
+	"strings"
+	"time"
+)
+
+var log = config.GetLogger("store")
+
+// Session is the persisted record of a reservation; it holds everything
+// needed to tear the reservation down again after a controller restart
+type Session struct {
+	ID            string         `json:"id"`
+	CreatedAt     time.Time      `json:"created_at"`
+	ExpiresAt     *time.Time     `json:"expires_at,omitempty"`
+	Devices       []Device       `json:"devices"`
+	NetboxObjects []NetboxObject `json:"netbox_objects"`
+	L1sConfigs    []L1sConfig    `json:"l1s_configs"`
+	Testbed       string         `json:"testbed"`
+	// StartsAt is set for scheduled reservations, whose resources are
+	// reserved at that time
+	StartsAt *time.Time    `json:"starts_at,omitempty"`
+	Status   SessionStatus `json:"status,omitempty"`
+	// Request holds the testbed of a scheduled reservation in JSON format
+	Request string `json:"request,omitempty"`
+	// Error describes why a scheduled reservation failed to activate, and
+	// Attempts counts the failed activations
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	// Priority decides which sessions may be preempted in favour of others
+	Priority int `json:"priority,omitempty"`
+	// Reason tells why a preempted session lost its resources
+	Reason string `json:"reason,omitempty"`
+	// Topology holds the testbed devices and links of the session along with
+	// the inventory devices and ports they're mapped to, in JSON format
+	Topology string `json:"topology,omitempty"`
+	// IdempotencyKey is the client supplied key the session was reserved
+	// with, and RequestHash the digest of the testbed requested
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	RequestHash    string `json:"request_hash,omitempty"`
+	// Metadata describes who reserved the session and what for
+	Metadata Metadata `json:"metadata"`
+}
+
+// Metadata describes who reserved a session and what for
+type Metadata struct {
+	Owner    string `json:"owner,omitempty"`
+	Team     string `json:"team,omitempty"`
+	Purpose  string `json:"purpose,omitempty"`
+	CIJobURL string `json:"ci_job_url,omitempty"`
+}
+
+// SessionStatus tells whether the resources of a session are reserved
+type SessionStatus string
+
+const (
+	// Session statuses; records without status are active
+	StatusActive    SessionStatus = "active"
+	StatusScheduled SessionStatus = "scheduled"
+	StatusFailed    SessionStatus = "failed"
+	StatusPreempted SessionStatus = "preempted"
+)
+
+// Active reports whether the resources of the session are reserved
+func (s *Session) Active() bool {
+	return s.Status == "" || s.Status == StatusActive
+}
+
+// Start returns the time from which the session holds its resources
+func (s *Session) Start() time.Time {
+	if s.StartsAt != nil {
+		return *s.StartsAt
+	}
+	return s.CreatedAt
+}
+
+// Overlaps reports whether the session holds its resources at any time within
+// [from, until); a nil until stands for an open ended range
+func (s *Session) Overlaps(from time.Time, until *time.Time) bool {
+	if !s.Active() && s.Status != StatusScheduled {
+		return false
+	}
+	if until != nil && !s.Start().Before(*until) {
+		return false
+	}
+	return s.ExpiresAt == nil || from.Before(*s.ExpiresAt)
+}
+
+// Expired reports whether the session lease has run out; sessions without a
+// lease never expire
+func (s *Session) Expired(now time.Time) bool {
+	return s.ExpiresAt != nil && !now.Before(*s.ExpiresAt)
+}
+
+// HasDevice reports whether the named device is reserved by the session
+func (s *Session) HasDevice(name string) bool {
+	for _, device := range s.Devices {
+		if strings.EqualFold(device.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Device is a reserved inventory device along with the reserved ports on it
+type Device struct {
+	Name  string   `json:"name"`
+	Role  string   `json:"role"`
+	Ports []string `json:"ports"`
+}
+
+// NetboxObject is a NetBox device/interface which was patched during reserve
+type NetboxObject struct {
+	URL  string                 `json:"url"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// L1sConfig is an L1 switch configuration pushed during reserve; Config
+// holds the gol1s config in JSON format
+type L1sConfig struct {
+	Config string `json:"config"`
+}
+
+// Store persists reservation sessions
+type Store interface {
+	// Save creates or replaces the session record
+	Save(session *Session) error
+	// Get returns the session record for the given ID
+	Get(id string) (*Session, error)
+	// Delete removes the session record for the given ID, if any
+	Delete(id string) error
+	// List returns all session records
+	List() ([]*Session, error)
+}
+
+// StoreKind specifies the session store implementation
+type StoreKind string
+
+const (
+	// Supported session store implementations
+	StoreFile   StoreKind = "file"
+	StoreMemory StoreKind = "memory"
+)
+
+// ErrNotFound is returned when a session record does not exist
+var ErrNotFound = fmt.Errorf("session not found")
+
+// New returns the session store for the given kind; dir is only used by
+// on-disk implementations
+func New(kind string, dir string) (Store, error) {
+	switch StoreKind(strings.ToLower(kind)) {
+	case StoreFile:
+		return NewFileStore(dir)
+	case StoreMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported session store: %s", kind)
+	}
+}